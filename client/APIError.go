@@ -0,0 +1,26 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package client
+
+import (
+	"fmt"
+)
+
+/*
+APIError is returned when the service tier answers with an error
+status. Message is the server's explanation when it gave one.
+*/
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *APIError) Error() string {
+	if err.Message == "" {
+		return fmt.Sprintf("mailslurper: status %d", err.StatusCode)
+	}
+
+	return fmt.Sprintf("mailslurper: status %d: %s", err.StatusCode, err.Message)
+}
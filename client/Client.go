@@ -0,0 +1,342 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+/*
+Package client talks to the MailSlurper service tier, so Go tests can
+search, read and delete captured mail and wait for mail to arrive.
+
+	mailSlurper := client.NewClient("http://localhost:8085")
+
+	mailItems, err := mailSlurper.WaitForMail(ctx, &client.WaitCriteria{
+		To:      "user@example.com",
+		Subject: "^Welcome",
+	})
+*/
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DATE_FORMAT is the format of MailItem.DateSent
+	DATE_FORMAT string = "2006-01-02 15:04:05"
+
+	// SEARCH_DATE_FORMAT is the format of the start and end search dates
+	SEARCH_DATE_FORMAT string = "2006-01-02"
+
+	// PRUNE_60_DAYS deletes mail older than 60 days
+	PRUNE_60_DAYS string = "60plus"
+
+	// PRUNE_30_DAYS deletes mail older than 30 days
+	PRUNE_30_DAYS string = "30plus"
+
+	// PRUNE_2_WEEKS deletes mail older than two weeks
+	PRUNE_2_WEEKS string = "2wksplus"
+
+	// PRUNE_ALL deletes all mail
+	PRUNE_ALL string = "all"
+
+	// WAIT_DEADLINE_MARGIN is kept back from a context deadline so the server times out first and reports near misses
+	WAIT_DEADLINE_MARGIN time.Duration = 500 * time.Millisecond
+)
+
+/*
+Client calls the MailSlurper service tier. ServiceURL is the address of
+the service tier, including the path prefix in single-port mode.
+HTTPClient must not have a timeout shorter than the waits made with
+WaitForMail.
+*/
+type Client struct {
+	ServiceURL string
+	HTTPClient *http.Client
+}
+
+/*
+NewClient creates a new Client for the service tier at serviceURL
+*/
+func NewClient(serviceURL string) *Client {
+	return &Client{
+		ServiceURL: strings.TrimRight(serviceURL, "/"),
+		HTTPClient: &http.Client{},
+	}
+}
+
+/*
+GetMail returns one mail item
+*/
+func (client *Client) GetMail(ctx context.Context, mailID string) (*MailItem, error) {
+	result := &MailItem{}
+	return result, client.getJSON(ctx, "/mail/"+url.PathEscape(mailID), nil, result)
+}
+
+/*
+GetMailPage returns one page of the mail matching mailSearch. Pages
+start at 1. A nil mailSearch matches all mail.
+*/
+func (client *Client) GetMailPage(ctx context.Context, mailSearch *MailSearch, pageNumber int) (*MailPage, error) {
+	values := mailSearch.Values()
+	values.Set("pageNumber", fmt.Sprintf("%d", pageNumber))
+
+	result := &MailPage{}
+	return result, client.getJSON(ctx, "/mail", values, result)
+}
+
+/*
+GetAllMail returns every mail item matching mailSearch, reading one
+page at a time
+*/
+func (client *Client) GetAllMail(ctx context.Context, mailSearch *MailSearch) ([]*MailItem, error) {
+	result := make([]*MailItem, 0)
+
+	for pageNumber := 1; ; pageNumber++ {
+		page, err := client.GetMailPage(ctx, mailSearch, pageNumber)
+		if err != nil {
+			return result, err
+		}
+
+		result = append(result, page.MailItems...)
+
+		if pageNumber >= page.TotalPages || len(page.MailItems) == 0 {
+			return result, nil
+		}
+	}
+}
+
+/*
+GetMailCount returns how many mail items are stored
+*/
+func (client *Client) GetMailCount(ctx context.Context) (int, error) {
+	result := struct {
+		MailCount int `json:"mailCount"`
+	}{}
+
+	err := client.getJSON(ctx, "/mailcount", nil, &result)
+	return result.MailCount, err
+}
+
+/*
+GetMailboxes returns the mail count of each SMTP listener tag
+*/
+func (client *Client) GetMailboxes(ctx context.Context) ([]*Mailbox, error) {
+	result := make([]*Mailbox, 0)
+	err := client.getJSON(ctx, "/mailboxes", nil, &result)
+	return result, err
+}
+
+/*
+GetMailHeaders returns every header of a mail item in the order they
+appeared
+*/
+func (client *Client) GetMailHeaders(ctx context.Context, mailID string) ([]*MailHeader, error) {
+	result := make([]*MailHeader, 0)
+	err := client.getJSON(ctx, "/mail/"+url.PathEscape(mailID)+"/headers", nil, &result)
+	return result, err
+}
+
+/*
+GetMailExtraction returns the links in a mail item and the codes in it
+matching the server's code patterns, such as one-time passwords
+*/
+func (client *Client) GetMailExtraction(ctx context.Context, mailID string) (*MailExtraction, error) {
+	result := &MailExtraction{}
+	return result, client.getJSON(ctx, "/mail/"+url.PathEscape(mailID)+"/extract", nil, result)
+}
+
+/*
+GetRawMessage returns a mail item's message exactly as it was received
+*/
+func (client *Client) GetRawMessage(ctx context.Context, mailID string) ([]byte, error) {
+	response, err := client.do(ctx, "GET", "/mail/"+url.PathEscape(mailID)+"/raw", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+	return ioutil.ReadAll(response.Body)
+}
+
+/*
+GetAttachment returns the contents of an attachment
+*/
+func (client *Client) GetAttachment(ctx context.Context, mailID, attachmentID string) ([]byte, error) {
+	response, err := client.do(ctx, "GET", attachmentPath(mailID, attachmentID), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+	return ioutil.ReadAll(response.Body)
+}
+
+/*
+DownloadAttachment writes the contents of an attachment to writer and
+returns how many bytes were written
+*/
+func (client *Client) DownloadAttachment(ctx context.Context, mailID, attachmentID string, writer io.Writer) (int64, error) {
+	response, err := client.do(ctx, "GET", attachmentPath(mailID, attachmentID), nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer response.Body.Close()
+	return io.Copy(writer, response.Body)
+}
+
+/*
+GetPruneOptions returns the ways stored mail can be deleted
+*/
+func (client *Client) GetPruneOptions(ctx context.Context) ([]*PruneOption, error) {
+	result := make([]*PruneOption, 0)
+	err := client.getJSON(ctx, "/pruneoptions", nil, &result)
+	return result, err
+}
+
+/*
+DeleteMail deletes stored mail. pruneCode is one of the PRUNE_
+constants.
+*/
+func (client *Client) DeleteMail(ctx context.Context, pruneCode string) error {
+	body, err := json.Marshal(map[string]string{"pruneCode": pruneCode})
+	if err != nil {
+		return err
+	}
+
+	response, err := client.do(ctx, "DELETE", "/mail", nil, strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+
+	return response.Body.Close()
+}
+
+/*
+WaitForMail blocks until mail matching criteria has been received and
+returns it, oldest first. When the wait times out the error is a
+*WaitTimeoutError listing the mail that came closest to matching.
+*/
+func (client *Client) WaitForMail(ctx context.Context, criteria *WaitCriteria) ([]*MailItem, error) {
+	values := criteria.Values()
+
+	if deadline, ok := ctx.Deadline(); ok && criteria.Timeout <= 0 {
+		timeout := time.Until(deadline) - WAIT_DEADLINE_MARGIN
+		if timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+
+		values.Set("timeout", timeout.String())
+	}
+
+	request, err := client.newRequest(ctx, "GET", "/mail/wait", values, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		result := struct {
+			MailItems []*MailItem `json:"mailItems"`
+		}{}
+
+		err = json.NewDecoder(response.Body).Decode(&result)
+		return result.MailItems, err
+
+	case http.StatusRequestTimeout:
+		result := &WaitTimeoutError{}
+		if err = json.NewDecoder(response.Body).Decode(result); err != nil {
+			return nil, err
+		}
+
+		return nil, result
+	}
+
+	return nil, newAPIError(response)
+}
+
+func (client *Client) getJSON(ctx context.Context, path string, values url.Values, result interface{}) error {
+	response, err := client.do(ctx, "GET", path, values, nil)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+/*
+do sends a request and returns the response when it succeeded. Any
+other status is returned as an *APIError.
+*/
+func (client *Client) do(ctx context.Context, method, path string, values url.Values, body io.Reader) (*http.Response, error) {
+	request, err := client.newRequest(ctx, method, path, values, body)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		defer response.Body.Close()
+		return nil, newAPIError(response)
+	}
+
+	return response, nil
+}
+
+func (client *Client) newRequest(ctx context.Context, method, path string, values url.Values, body io.Reader) (*http.Request, error) {
+	address := client.ServiceURL + path
+	if len(values) > 0 {
+		address += "?" + values.Encode()
+	}
+
+	request, err := http.NewRequest(method, address, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	return request.WithContext(ctx), nil
+}
+
+func newAPIError(response *http.Response) *APIError {
+	result := &APIError{StatusCode: response.StatusCode}
+	body := struct {
+		Message string `json:"message"`
+	}{}
+
+	if contents, err := ioutil.ReadAll(io.LimitReader(response.Body, 4096)); err == nil {
+		if json.Unmarshal(contents, &body) == nil {
+			result.Message = body.Message
+		} else {
+			result.Message = strings.TrimSpace(string(contents))
+		}
+	}
+
+	return result
+}
+
+func attachmentPath(mailID, attachmentID string) string {
+	return "/mail/" + url.PathEscape(mailID) + "/attachment/" + url.PathEscape(attachmentID)
+}
@@ -0,0 +1,48 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package client
+
+/*
+MailExtraction holds the links found in a mail item and the codes
+matching the server's code patterns
+*/
+type MailExtraction struct {
+	Links []*MailLink `json:"links"`
+	Codes []*MailCode `json:"codes"`
+}
+
+/*
+MailLink is a link found in a mail item. Source is "html" for an anchor
+in the HTML body, whose text is in Text, or "text" for an address in
+the plain text body.
+*/
+type MailLink struct {
+	URL    string `json:"url"`
+	Text   string `json:"text"`
+	Source string `json:"source"`
+}
+
+/*
+MailCode is a code found in a mail item. Name is the name of the code
+pattern that matched.
+*/
+type MailCode struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+/*
+Code returns the first code found by the named code pattern, and false
+when there is none
+*/
+func (extraction *MailExtraction) Code(name string) (string, bool) {
+	for _, code := range extraction.Codes {
+		if code.Name == name {
+			return code.Value, true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,68 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package client
+
+/*
+MailItem is a mail item as returned by the service tier. DateSent is in
+the server's local time, formatted as DATE_FORMAT.
+*/
+type MailItem struct {
+	ID          string        `json:"id"`
+	DateSent    string        `json:"dateSent"`
+	FromAddress string        `json:"fromAddress"`
+	ToAddresses []string      `json:"toAddresses"`
+	Subject     string        `json:"subject"`
+	XMailer     string        `json:"xmailer"`
+	MIMEVersion string        `json:"mimeVersion"`
+	Body        string        `json:"body"`
+	ContentType string        `json:"contentType"`
+	Boundary    string        `json:"boundary"`
+	Attachments []*Attachment `json:"attachments"`
+}
+
+/*
+Attachment describes a file attached to a mail item. Use
+Client.GetAttachment to download its contents.
+*/
+type Attachment struct {
+	ID      string            `json:"id"`
+	MailID  string            `json:"mailId"`
+	Headers *AttachmentHeader `json:"headers"`
+}
+
+/*
+AttachmentHeader holds the MIME headers of an attachment
+*/
+type AttachmentHeader struct {
+	ContentType             string `json:"contentType"`
+	MIMEVersion             string `json:"mimeVersion"`
+	ContentTransferEncoding string `json:"contentTransferEncoding"`
+	ContentDisposition      string `json:"contentDisposition"`
+	FileName                string `json:"fileName"`
+}
+
+/*
+MailHeader is one header of a mail item
+*/
+type MailHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+/*
+Mailbox is the mail count for one SMTP listener tag
+*/
+type Mailbox struct {
+	Tag       string `json:"tag"`
+	MailCount int    `json:"mailCount"`
+}
+
+/*
+PruneOption is one of the ways stored mail can be deleted
+*/
+type PruneOption struct {
+	PruneCode   string `json:"pruneCode"`
+	Description string `json:"description"`
+}
@@ -0,0 +1,25 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package client
+
+/*
+MailPage is one page of mail items matching a search. Highlights is
+keyed by mail item ID and only present for a full-text search.
+*/
+type MailPage struct {
+	MailItems        []*MailItem                 `json:"mailItems"`
+	TotalPages       int                         `json:"totalPages"`
+	TotalRecordCount int                         `json:"totalRecordCount"`
+	Highlights       map[string]*SearchHighlight `json:"highlights"`
+}
+
+/*
+SearchHighlight describes why a mail item matched a full-text search.
+Snippet is HTML with the matching words wrapped in <mark> tags.
+*/
+type SearchHighlight struct {
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
@@ -0,0 +1,89 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package client
+
+import (
+	"net/url"
+	"time"
+)
+
+const (
+	// ORDER_BY_DATE sorts mail by the date it was received
+	ORDER_BY_DATE string = "date"
+
+	// ORDER_BY_FROM sorts mail by sender
+	ORDER_BY_FROM string = "from"
+
+	// ORDER_BY_SUBJECT sorts mail by subject
+	ORDER_BY_SUBJECT string = "subject"
+
+	// ORDER_BY_RELEVANCE sorts full-text search results best match first
+	ORDER_BY_RELEVANCE string = "relevance"
+
+	// DIRECTION_ASC sorts in ascending order
+	DIRECTION_ASC string = "asc"
+
+	// DIRECTION_DESC sorts in descending order
+	DIRECTION_DESC string = "desc"
+)
+
+/*
+MailSearch holds the criteria for Client.GetMailPage and
+Client.GetAllMail. Empty fields are not searched on. Message matches
+part of the subject or body, and Text is a full-text search. Start and
+End only use the date. Each of Headers must
+be present on a mail item; an empty value matches any value.
+*/
+type MailSearch struct {
+	Message          string
+	Text             string
+	Start            time.Time
+	End              time.Time
+	From             string
+	To               string
+	Tag              string
+	Headers          map[string]string
+	OrderByField     string
+	OrderByDirection string
+}
+
+/*
+Values returns the search as GET /mail query string parameters
+*/
+func (mailSearch *MailSearch) Values() url.Values {
+	result := url.Values{}
+
+	if mailSearch == nil {
+		return result
+	}
+
+	setValue(result, "message", mailSearch.Message)
+	setValue(result, "text", mailSearch.Text)
+	setValue(result, "from", mailSearch.From)
+	setValue(result, "to", mailSearch.To)
+	setValue(result, "tag", mailSearch.Tag)
+	setValue(result, "orderby", mailSearch.OrderByField)
+	setValue(result, "dir", mailSearch.OrderByDirection)
+
+	if !mailSearch.Start.IsZero() {
+		result.Set("start", mailSearch.Start.Format(SEARCH_DATE_FORMAT))
+	}
+
+	if !mailSearch.End.IsZero() {
+		result.Set("end", mailSearch.End.Format(SEARCH_DATE_FORMAT))
+	}
+
+	for name, value := range mailSearch.Headers {
+		result.Add("header."+name, value)
+	}
+
+	return result
+}
+
+func setValue(values url.Values, name, value string) {
+	if value != "" {
+		values.Set(name, value)
+	}
+}
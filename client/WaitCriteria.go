@@ -0,0 +1,99 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+WaitCriteria describes the mail Client.WaitForMail waits for. Every
+field that is set must match. To and From are case-insensitive
+substrings of a recipient and the sender, Subject is a regular
+expression, each of Headers must be present (an empty value matches any
+value) and the mail must have been received at or after ReceivedAfter.
+Count defaults to one. Timeout defaults to the time left on the context,
+or the server's default when the context has no deadline.
+*/
+type WaitCriteria struct {
+	To            string
+	From          string
+	Subject       string
+	Headers       map[string]string
+	ReceivedAfter time.Time
+	Count         int
+	Timeout       time.Duration
+}
+
+/*
+Values returns the criteria as GET /mail/wait query string parameters
+*/
+func (criteria *WaitCriteria) Values() url.Values {
+	result := url.Values{}
+
+	setValue(result, "to", criteria.To)
+	setValue(result, "from", criteria.From)
+	setValue(result, "subject", criteria.Subject)
+
+	if !criteria.ReceivedAfter.IsZero() {
+		result.Set("receivedAfter", criteria.ReceivedAfter.Format(time.RFC3339))
+	}
+
+	if criteria.Count > 0 {
+		result.Set("count", strconv.Itoa(criteria.Count))
+	}
+
+	if criteria.Timeout > 0 {
+		result.Set("timeout", criteria.Timeout.String())
+	}
+
+	for name, value := range criteria.Headers {
+		result.Add("header."+name, value)
+	}
+
+	return result
+}
+
+/*
+NearMiss is a mail item that failed some of the wait criteria.
+Mismatches describes each criterion it failed.
+*/
+type NearMiss struct {
+	MailItemID  string   `json:"mailItemId"`
+	DateSent    string   `json:"dateSent"`
+	FromAddress string   `json:"fromAddress"`
+	ToAddresses []string `json:"toAddresses"`
+	Subject     string   `json:"subject"`
+	Mismatches  []string `json:"mismatches"`
+}
+
+/*
+WaitTimeoutError is returned by Client.WaitForMail when not enough mail
+matched in time. MailItems holds the mail that did match and NearMisses
+the mail that came closest, best first.
+*/
+type WaitTimeoutError struct {
+	MailItems  []*MailItem `json:"mailItems"`
+	NearMisses []*NearMiss `json:"nearMisses"`
+}
+
+func (err *WaitTimeoutError) Error() string {
+	message := fmt.Sprintf("timed out waiting for mail (%d matched)", len(err.MailItems))
+
+	if len(err.NearMisses) == 0 {
+		return message + ", no near misses"
+	}
+
+	nearMisses := make([]string, 0, len(err.NearMisses))
+	for _, nearMiss := range err.NearMisses {
+		nearMisses = append(nearMisses, fmt.Sprintf("%q from %s: %s", nearMiss.Subject, nearMiss.FromAddress, strings.Join(nearMiss.Mismatches, ", ")))
+	}
+
+	return message + "; near misses: " + strings.Join(nearMisses, "; ")
+}
@@ -0,0 +1,89 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/adampresley/GoHttpService"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/mailslurper/mailslurper/services/faultinjection"
+)
+
+/*
+GetFaultRules returns the fault rules currently applied to SMTP sessions
+*/
+func GetFaultRules(writer http.ResponseWriter, request *http.Request) {
+	faultRules := (context.Get(request, "faultRules")).(*faultinjection.FaultRuleEngine)
+	GoHttpService.WriteJson(writer, faultRules.GetRules(), 200)
+}
+
+/*
+SaveFaultRules replaces every fault rule with the list in the request
+body
+*/
+func SaveFaultRules(writer http.ResponseWriter, request *http.Request) {
+	faultRules := (context.Get(request, "faultRules")).(*faultinjection.FaultRuleEngine)
+	rules := make([]*model.FaultRule, 0)
+
+	if err := json.NewDecoder(request.Body).Decode(&rules); err != nil {
+		GoHttpService.BadRequest(writer, "A JSON list of fault rules is required")
+		return
+	}
+
+	if err := faultRules.SetRules(rules); err != nil {
+		GoHttpService.BadRequest(writer, err.Error())
+		return
+	}
+
+	GoHttpService.WriteJson(writer, faultRules.GetRules(), 200)
+}
+
+/*
+AddFaultRule adds the fault rule in the request body and returns it
+with its ID
+*/
+func AddFaultRule(writer http.ResponseWriter, request *http.Request) {
+	faultRules := (context.Get(request, "faultRules")).(*faultinjection.FaultRuleEngine)
+	rule := &model.FaultRule{}
+
+	if err := json.NewDecoder(request.Body).Decode(rule); err != nil {
+		GoHttpService.BadRequest(writer, "A JSON fault rule is required")
+		return
+	}
+
+	if err := faultRules.AddRule(rule); err != nil {
+		GoHttpService.BadRequest(writer, err.Error())
+		return
+	}
+
+	GoHttpService.WriteJson(writer, rule, 200)
+}
+
+/*
+DeleteFaultRule removes a fault rule by ID
+*/
+func DeleteFaultRule(writer http.ResponseWriter, request *http.Request) {
+	faultRules := (context.Get(request, "faultRules")).(*faultinjection.FaultRuleEngine)
+
+	if !faultRules.DeleteRule(mux.Vars(request)["ruleID"]) {
+		GoHttpService.NotFound(writer, "Fault rule not found")
+		return
+	}
+
+	GoHttpService.Success(writer, "Fault rule deleted")
+}
+
+/*
+GetFaultRuleMatches returns the most recent fault rule matches, newest
+first
+*/
+func GetFaultRuleMatches(writer http.ResponseWriter, request *http.Request) {
+	faultRules := (context.Get(request, "faultRules")).(*faultinjection.FaultRuleEngine)
+	GoHttpService.WriteJson(writer, faultRules.GetMatches(), 200)
+}
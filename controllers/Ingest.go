@@ -0,0 +1,138 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/adampresley/GoHttpService"
+	"github.com/gorilla/context"
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/mailslurper/mailslurper/services/dispatch"
+	"github.com/mailslurper/mailslurper/services/mailparser"
+)
+
+const (
+	// MAX_INGEST_SIZE is the largest request body accepted by IngestMail
+	MAX_INGEST_SIZE int64 = 64 << 20
+)
+
+/*
+IngestMail stores raw RFC 5322 messages without going through SMTP. The
+body is either a single message or a multipart/form-data upload where
+every file is a message. Each one is parsed like mail received over
+SMTP and handed to the same dispatcher, so storage, webhooks and the
+mail stream behave identically. Nothing is stored unless every message
+parses.
+
+The optional query parameters "from" and "to" (repeatable) act as the
+envelope; without them the message headers are used. "tag" files the
+messages in a mailbox.
+*/
+func IngestMail(writer http.ResponseWriter, request *http.Request) {
+	dispatcher := (context.Get(request, "dispatcher")).(*dispatch.MailDispatcher)
+	request.Body = http.MaxBytesReader(writer, request.Body, MAX_INGEST_SIZE)
+
+	messages, err := readIngestMessages(request)
+	if err != nil {
+		GoHttpService.BadRequest(writer, "Unable to read the message: "+err.Error())
+		return
+	}
+
+	if len(messages) == 0 {
+		GoHttpService.BadRequest(writer, "No message was supplied")
+		return
+	}
+
+	query := request.URL.Query()
+	from := query.Get("from")
+	to := query["to"]
+
+	/*
+	 * Parse every message before storing any, so one bad file in an
+	 * upload rejects the whole request instead of leaving the files
+	 * before it stored.
+	 */
+	mailItems := make([]*mailitem.MailItem, 0, len(messages))
+
+	for index, message := range messages {
+		mailItem, err := mailparser.Parse(message, from, to)
+		if err != nil {
+			GoHttpService.BadRequest(writer, fmt.Sprintf("Unable to parse message %d: %s", index+1, err.Error()))
+			return
+		}
+
+		mailItems = append(mailItems, mailItem)
+	}
+
+	result := &model.IngestResponse{
+		MailItemIDs: make([]string, 0, len(messages)),
+	}
+
+	for index, mailItem := range mailItems {
+		if err = dispatcher.Dispatch(mailItem, messages[index], &model.MailMetadata{Tag: query.Get("tag")}); err != nil {
+			log.Printf("MailSlurper: ERROR - Unable to store ingested message %d: %s\n", index+1, err.Error())
+			GoHttpService.Error(writer, fmt.Sprintf("Unable to store message %d. Mail item(s) already stored: %s", index+1, strings.Join(result.MailItemIDs, ", ")))
+			return
+		}
+
+		result.MailItemIDs = append(result.MailItemIDs, mailItem.ID)
+	}
+
+	GoHttpService.WriteJson(writer, result, 200)
+}
+
+func readIngestMessages(request *http.Request) ([][]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+
+	if !strings.EqualFold(mediaType, "multipart/form-data") {
+		message, err := ioutil.ReadAll(request.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(message) == 0 {
+			return [][]byte{}, nil
+		}
+
+		return [][]byte{message}, nil
+	}
+
+	reader, err := request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([][]byte, 0)
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return result, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FileName() == "" {
+			continue
+		}
+
+		message, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, message)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/adampresley/GoHttpService"
+	"github.com/gorilla/context"
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/mailslurper/mailslurper/services/dispatch"
+	"github.com/mailslurper/mailslurper/services/mailarchive"
+	"github.com/mailslurper/mailslurper/services/mailquery"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+)
+
+const (
+	// MAX_IMPORT_SIZE is the largest archive accepted by ImportMail
+	MAX_IMPORT_SIZE int64 = 512 << 20
+)
+
+/*
+ExportMail streams the mail items matching a search as a download.
+"format" is "mbox" (the default) or "zip" for .eml files with a
+manifest.json. The other query parameters are the same as GET /mail,
+and mail is exported in the same order.
+*/
+func ExportMail(writer http.ResponseWriter, request *http.Request) {
+	database := (context.Get(request, "database")).(storage.IStorage)
+	mailStore := (context.Get(request, "mailStore")).(mailstore.IMailStore)
+
+	format := request.URL.Query().Get("format")
+	if format == "" {
+		format = mailarchive.FORMAT_MBOX
+	}
+
+	archiveWriter, err := mailarchive.NewArchiveWriter(format, writer)
+	if err != nil {
+		GoHttpService.BadRequest(writer, err.Error())
+		return
+	}
+
+	query := mailquery.NewMailQueryFromValues(request.URL.Query())
+	fileName := fmt.Sprintf("mailslurper-%s.%s", time.Now().Format("20060102-150405"), archiveWriter.FileExtension())
+
+	writer.Header().Set("Content-Type", archiveWriter.ContentType())
+	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileName))
+	writer.WriteHeader(http.StatusOK)
+
+	/*
+	 * The response has started, so a failure part way through can only
+	 * be logged. The archive is left unfinished, which the client sees
+	 * as a broken download.
+	 */
+	exported, err := mailarchive.Export(archiveWriter, query, database, mailStore)
+	if err != nil {
+		log.Printf("MailSlurper: ERROR - Export failed after %d mail item(s): %s\n", exported, err.Error())
+		return
+	}
+
+	if err = archiveWriter.Close(); err != nil {
+		log.Printf("MailSlurper: ERROR - Unable to finish the export: %s\n", err.Error())
+		return
+	}
+
+	log.Printf("MailSlurper: INFO - Exported %d mail item(s) as %s\n", exported, format)
+}
+
+/*
+ImportMail stores the messages of an mbox or zip export. The request
+body is the archive itself; its format is worked out from the contents.
+Every message goes through the same dispatcher as mail received over
+SMTP.
+*/
+func ImportMail(writer http.ResponseWriter, request *http.Request) {
+	dispatcher := (context.Get(request, "dispatcher")).(*dispatch.MailDispatcher)
+	request.Body = http.MaxBytesReader(writer, request.Body, MAX_IMPORT_SIZE)
+
+	mailItemIDs, err := mailarchive.Import(request.Body, dispatcher)
+	if err != nil {
+		log.Printf("MailSlurper: ERROR - Import failed after %d mail item(s): %s\n", len(mailItemIDs), err.Error())
+		GoHttpService.BadRequest(writer, fmt.Sprintf("Unable to import the archive after %d mail item(s): %s", len(mailItemIDs), err.Error()))
+		return
+	}
+
+	log.Printf("MailSlurper: INFO - Imported %d mail item(s)\n", len(mailItemIDs))
+
+	GoHttpService.WriteJson(writer, &model.IngestResponse{MailItemIDs: mailItemIDs}, 200)
+}
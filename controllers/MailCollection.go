@@ -0,0 +1,99 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/adampresley/GoHttpService"
+	"github.com/gorilla/context"
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/mailslurper/mailslurper/services/mailquery"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+)
+
+const (
+	MAIL_ITEMS_PER_PAGE int = 50
+)
+
+/*
+GetMailCollection returns a page of mail items for the service tier
+GET /mail endpoint. On top of the libmailslurper search parameters it
+accepts "tag" to only return mail received on SMTP listeners with that
+tag. "text" is a full-text search whose results come with highlighted
+snippets, and header.<name>=<value> only returns mail with that header.
+*/
+func GetMailCollection(writer http.ResponseWriter, request *http.Request) {
+	database := (context.Get(request, "database")).(storage.IStorage)
+	mailStore := (context.Get(request, "mailStore")).(mailstore.IMailStore)
+
+	var err error
+
+	pageNumber := 1
+
+	if value := request.URL.Query().Get("pageNumber"); value != "" {
+		if pageNumber, err = strconv.Atoi(value); err != nil || pageNumber < 1 {
+			GoHttpService.BadRequest(writer, "A valid page number is required")
+			return
+		}
+	}
+
+	query := mailquery.NewMailQueryFromValues(request.URL.Query())
+	offset := (pageNumber - 1) * MAIL_ITEMS_PER_PAGE
+
+	mailItems, totalRecordCount, err := query.Execute(database, mailStore, offset, MAIL_ITEMS_PER_PAGE)
+	if err != nil {
+		log.Printf("MailSlurper: ERROR - Problem getting mail collection: %s\n", err.Error())
+		GoHttpService.Error(writer, "Problem getting mail collection")
+		return
+	}
+
+	result := &model.MailCollectionResponse{
+		MailItems:        mailItems,
+		TotalPages:       (totalRecordCount + MAIL_ITEMS_PER_PAGE - 1) / MAIL_ITEMS_PER_PAGE,
+		TotalRecordCount: totalRecordCount,
+	}
+
+	if query.Text != "" {
+		if result.Highlights, err = query.Highlights(mailStore, mailItems); err != nil {
+			log.Printf("MailSlurper: ERROR - Problem highlighting search results: %s\n", err.Error())
+			GoHttpService.Error(writer, "Problem getting mail collection")
+			return
+		}
+	}
+
+	GoHttpService.WriteJson(writer, result, 200)
+}
+
+/*
+DeleteMail prunes stored mail for the service tier DELETE /mail
+endpoint. The body is a JSON object with a "pruneCode" of "60plus",
+"30plus", "2wksplus" or "all". Everything MailSlurper recorded for the
+pruned mail is removed along with it.
+*/
+func DeleteMail(writer http.ResponseWriter, request *http.Request) {
+	database := (context.Get(request, "database")).(storage.IStorage)
+	mailStore := (context.Get(request, "mailStore")).(mailstore.IMailStore)
+
+	pruneRequest := &model.PruneRequest{}
+
+	if err := json.NewDecoder(request.Body).Decode(pruneRequest); err != nil || !pruneRequest.IsValid() {
+		GoHttpService.BadRequest(writer, "A valid prune code is required")
+		return
+	}
+
+	if err := mailstore.DeleteMailsAfterDate(database, mailStore, pruneRequest.ConvertToDate()); err != nil {
+		log.Printf("MailSlurper: ERROR - Problem pruning mail (%s): %s\n", pruneRequest.PruneCode, err.Error())
+		GoHttpService.Error(writer, "Problem deleting mail")
+		return
+	}
+
+	log.Printf("MailSlurper: INFO - Pruned mail (%s)\n", pruneRequest.PruneCode)
+	GoHttpService.Success(writer, "Mail deleted")
+}
@@ -0,0 +1,47 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/adampresley/GoHttpService"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/services/mailextract"
+	"github.com/mailslurper/mailslurper/services/mailparser"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+	"github.com/mailslurper/mailslurper/services/messagebuilder"
+)
+
+/*
+GetMailExtraction returns the links in a mail item, with their anchor
+text, and the codes in it matching the configured code patterns, such
+as one-time passwords and tokens in query strings.
+*/
+func GetMailExtraction(writer http.ResponseWriter, request *http.Request) {
+	database := (context.Get(request, "database")).(storage.IStorage)
+	mailStore := (context.Get(request, "mailStore")).(mailstore.IMailStore)
+	mailExtractor := (context.Get(request, "mailExtractor")).(*mailextract.MailExtractor)
+
+	mailID := mux.Vars(request)["mailID"]
+
+	contents, err := messagebuilder.GetMessageContents(database, mailStore, mailID)
+	if err != nil {
+		GoHttpService.NotFound(writer, "Mail item not found")
+		return
+	}
+
+	htmlBody, textBody, err := mailparser.ParseBodies(contents)
+	if err != nil {
+		log.Printf("MailSlurper: ERROR - Unable to read the body of mail item %s: %s\n", mailID, err.Error())
+		GoHttpService.Error(writer, "Unable to read the mail body")
+		return
+	}
+
+	GoHttpService.WriteJson(writer, mailExtractor.Extract(htmlBody, textBody), 200)
+}
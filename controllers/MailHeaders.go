@@ -0,0 +1,53 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/adampresley/GoHttpService"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/services/mailparser"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+	"github.com/mailslurper/mailslurper/services/messagebuilder"
+)
+
+/*
+GetMailHeaders returns every header of a mail item in the order they
+appeared. Mail captured before headers were stored has them read from
+its message instead.
+*/
+func GetMailHeaders(writer http.ResponseWriter, request *http.Request) {
+	database := (context.Get(request, "database")).(storage.IStorage)
+	mailStore := (context.Get(request, "mailStore")).(mailstore.IMailStore)
+
+	mailID := mux.Vars(request)["mailID"]
+
+	headers, err := mailStore.GetMailHeaders(mailID)
+	if err != nil {
+		log.Printf("MailSlurper: ERROR - Unable to read the headers for mail item %s: %s\n", mailID, err.Error())
+		GoHttpService.Error(writer, "Unable to read the headers")
+		return
+	}
+
+	if len(headers) == 0 {
+		contents, err := messagebuilder.GetMessageContents(database, mailStore, mailID)
+		if err != nil {
+			GoHttpService.NotFound(writer, "Mail item not found")
+			return
+		}
+
+		if headers, err = mailparser.ParseHeaders(contents); err != nil {
+			log.Printf("MailSlurper: ERROR - Unable to read the headers for mail item %s: %s\n", mailID, err.Error())
+			GoHttpService.Error(writer, "Unable to read the headers")
+			return
+		}
+	}
+
+	GoHttpService.WriteJson(writer, headers, 200)
+}
@@ -0,0 +1,34 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/adampresley/GoHttpService"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+)
+
+/*
+GetMailMetadata returns the details recorded when a mail item was
+received, such as the TLS version and cipher.
+*/
+func GetMailMetadata(writer http.ResponseWriter, request *http.Request) {
+	mailStore := (context.Get(request, "mailStore")).(mailstore.IMailStore)
+
+	mailID := mux.Vars(request)["mailID"]
+
+	metadata, err := mailStore.GetMailMetadata(mailID)
+	if err != nil {
+		log.Printf("MailSlurper: ERROR - Unable to read metadata for mail item %s: %s\n", mailID, err.Error())
+		GoHttpService.Error(writer, "Unable to read mail metadata")
+		return
+	}
+
+	GoHttpService.WriteJson(writer, metadata, 200)
+}
@@ -0,0 +1,68 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/adampresley/GoHttpService"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+	"github.com/mailslurper/mailslurper/services/messagebuilder"
+)
+
+/*
+GetRawMessage returns a mail item as message/rfc822, byte for byte as it
+was received. Mail stored before raw messages were kept is rebuilt from
+its parsed parts instead, and the X-MailSlurper-Source response header
+says which one was sent ("original" or "rebuilt"). Passing
+download=true asks the browser to save the message as <mailID>.eml.
+*/
+func GetRawMessage(writer http.ResponseWriter, request *http.Request) {
+	database := (context.Get(request, "database")).(storage.IStorage)
+	mailStore := (context.Get(request, "mailStore")).(mailstore.IMailStore)
+
+	mailID := mux.Vars(request)["mailID"]
+	source := "original"
+
+	contents, err := mailStore.GetRawMessage(mailID)
+	if err != nil {
+		log.Printf("MailSlurper: ERROR - Unable to read the raw message for mail item %s: %s\n", mailID, err.Error())
+		GoHttpService.Error(writer, "Unable to read the raw message")
+		return
+	}
+
+	if contents == nil {
+		source = "rebuilt"
+
+		mailItem, err := messagebuilder.LoadMailItem(database, mailID)
+		if err != nil {
+			GoHttpService.NotFound(writer, "Mail item not found")
+			return
+		}
+
+		if contents, err = messagebuilder.Build(mailItem); err != nil {
+			log.Printf("MailSlurper: ERROR - Unable to rebuild mail item %s: %s\n", mailID, err.Error())
+			GoHttpService.Error(writer, "Unable to rebuild the message")
+			return
+		}
+	}
+
+	writer.Header().Set("Content-Type", "message/rfc822")
+	writer.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+	writer.Header().Set("X-MailSlurper-Source", source)
+
+	if download, _ := strconv.ParseBool(request.URL.Query().Get("download")); download {
+		writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.eml\"", mailID))
+	}
+
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(contents)
+}
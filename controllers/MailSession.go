@@ -0,0 +1,40 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/adampresley/GoHttpService"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+)
+
+/*
+GetMailSession returns the SMTP session record for a mail item,
+including the full command/response transcript. Mail that did not
+arrive over SMTP has no session record.
+*/
+func GetMailSession(writer http.ResponseWriter, request *http.Request) {
+	mailStore := (context.Get(request, "mailStore")).(mailstore.IMailStore)
+
+	mailID := mux.Vars(request)["mailID"]
+
+	session, err := mailStore.GetSMTPSession(mailID)
+	if err != nil {
+		log.Printf("MailSlurper: ERROR - Unable to read the SMTP session for mail item %s: %s\n", mailID, err.Error())
+		GoHttpService.Error(writer, "Unable to read the SMTP session")
+		return
+	}
+
+	if session == nil {
+		GoHttpService.NotFound(writer, "No SMTP session was recorded for this mail item")
+		return
+	}
+
+	GoHttpService.WriteJson(writer, session, 200)
+}
@@ -0,0 +1,76 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/adampresley/GoHttpService"
+	"github.com/gorilla/context"
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/mailslurper/mailslurper/services/mailstream"
+)
+
+/*
+MailStream pushes a summary of each new mail item to the browser using
+Server-Sent Events. The connection stays open until the client goes
+away.
+*/
+func MailStream(writer http.ResponseWriter, request *http.Request) {
+	mailStream := (context.Get(request, "mailStream")).(*mailstream.MailStreamReceiver)
+
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		GoHttpService.Error(writer, "Streaming is not supported by this connection")
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+
+	subscriber := mailStream.Subscribe()
+	defer mailStream.Unsubscribe(subscriber)
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	fmt.Fprintf(writer, "retry: 5000\n\n")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			fmt.Fprintf(writer, ": keep-alive\n\n")
+			flusher.Flush()
+
+		case mailItem := <-subscriber:
+			event := model.MailStreamEvent{
+				ID:              mailItem.ID,
+				DateSent:        mailItem.DateSent,
+				FromAddress:     mailItem.FromAddress,
+				ToAddresses:     mailItem.ToAddresses,
+				Subject:         mailItem.Subject,
+				AttachmentCount: len(mailItem.Attachments),
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("MailSlurper: ERROR - Unable to serialize mail stream event: %s\n", err.Error())
+				continue
+			}
+
+			fmt.Fprintf(writer, "event: mail\ndata: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/adampresley/GoHttpService"
+	"github.com/gorilla/context"
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+	"github.com/mailslurper/mailslurper/services/mailstream"
+	"github.com/mailslurper/mailslurper/services/mailwait"
+)
+
+/*
+WaitForMail blocks until mail matching the query string criteria has
+been received, so tests can wait for mail instead of polling. The
+matching mail items are returned with a 200. When the timeout passes
+first a 408 is returned with the mail items that came closest to
+matching and the criteria each one failed.
+
+	GET /mail/wait?to=user@example.com&subject=^Welcome&timeout=10
+*/
+func WaitForMail(writer http.ResponseWriter, request *http.Request) {
+	mailStream := (context.Get(request, "mailStream")).(*mailstream.MailStreamReceiver)
+	database := (context.Get(request, "database")).(storage.IStorage)
+	mailStore := (context.Get(request, "mailStore")).(mailstore.IMailStore)
+
+	criteria, err := mailwait.NewWaitCriteriaFromValues(request.URL.Query())
+	if err != nil {
+		GoHttpService.BadRequest(writer, err.Error())
+		return
+	}
+
+	waiter := mailwait.NewMailWaiter(database, mailStore, mailStream)
+
+	result, matched, err := waiter.Wait(request.Context(), criteria)
+	if err != nil {
+		log.Printf("MailSlurper: ERROR - Unable to wait for mail: %s\n", err.Error())
+		GoHttpService.Error(writer, "Unable to wait for mail")
+		return
+	}
+
+	if !matched {
+		GoHttpService.WriteJson(writer, result, http.StatusRequestTimeout)
+		return
+	}
+
+	GoHttpService.WriteJson(writer, result, 200)
+}
@@ -0,0 +1,31 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/adampresley/GoHttpService"
+	"github.com/gorilla/context"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+)
+
+/*
+GetMailboxes returns the tags of the SMTP listeners mail has been
+received on, with a mail count for each.
+*/
+func GetMailboxes(writer http.ResponseWriter, request *http.Request) {
+	mailStore := (context.Get(request, "mailStore")).(mailstore.IMailStore)
+
+	mailboxes, err := mailStore.GetMailboxes()
+	if err != nil {
+		log.Printf("MailSlurper: ERROR - Problem getting mailboxes: %s\n", err.Error())
+		GoHttpService.Error(writer, "Problem getting mailboxes")
+		return
+	}
+
+	GoHttpService.WriteJson(writer, mailboxes, 200)
+}
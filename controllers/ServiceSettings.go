@@ -15,15 +15,16 @@ import (
 
 /*
 GetServiceSettings returns the settings necessary to talk to the MailSlurper
-back-end service tier.
+back-end service tier. In single port mode that is a path on this server.
 */
 func GetServiceSettings(writer http.ResponseWriter, request *http.Request) {
 	config := (context.Get(request, "config")).(*configuration.Configuration)
 
 	settings := model.ServiceSettings{
-		ServiceAddress: config.ServiceAddress,
-		ServicePort:    config.ServicePort,
-		Version:        "v1",
+		ServiceAddress:    config.ServiceAddress,
+		ServicePort:       config.ServicePort,
+		ServicePathPrefix: (context.Get(request, "servicePathPrefix")).(string),
+		Version:           "v1",
 	}
 
 	GoHttpService.WriteJson(writer, settings, 200)
@@ -4,12 +4,8 @@
 
 package global
 
-import "github.com/mailslurper/libmailslurper/storage"
-
 const (
 	// Version of the MailSlurper Server application
 	SERVER_VERSION string = "1.11.1"
 	DEBUG_ASSETS   bool   = false
 )
-
-var Database storage.IStorage
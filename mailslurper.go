@@ -7,26 +7,42 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/adampresley/sigint"
-	"github.com/mailslurper/libmailslurper"
 	"github.com/mailslurper/libmailslurper/configuration"
 	"github.com/mailslurper/libmailslurper/receiver"
-	"github.com/mailslurper/libmailslurper/server"
 	"github.com/mailslurper/libmailslurper/storage"
 	"github.com/mailslurper/mailslurper/global"
-	"github.com/mailslurper/mailslurper/services/listener"
-	"github.com/mailslurper/mailslurper/services/middleware"
+	"github.com/mailslurper/mailslurper/server"
+	"github.com/mailslurper/mailslurper/services/appconfig"
+	"github.com/mailslurper/mailslurper/services/attachmentstore"
+	"github.com/mailslurper/mailslurper/services/dispatch"
+	"github.com/mailslurper/mailslurper/services/mailarchive"
+	"github.com/mailslurper/mailslurper/services/mailquery"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+	"github.com/mailslurper/mailslurper/services/migration"
+	"github.com/mailslurper/mailslurper/services/webhook"
 	"github.com/skratchdot/open-golang/open"
 )
 
 func main() {
 	var err error
 
+	migrateDryRun := flag.Bool("migrate-dry-run", false, "Print the SQL of pending database migrations and exit")
+	exportFile := flag.String("export", "", "Export mail to this file and exit")
+	exportFormat := flag.String("export-format", "", "Export as \"mbox\" or \"zip\" (default: from the file extension)")
+	exportQuery := flag.String("export-query", "", "Only export mail matching this GET /mail query string, such as \"tag=qa&message=invoice\"")
+	importFile := flag.String("import", "", "Import mail from an mbox or zip export and exit")
+	flag.Parse()
+
 	log.Printf("MailSlurper: INFO - Starting MailSlurper Server v%s\n", global.SERVER_VERSION)
 	/*
 	 * Prepare SIGINT handler (CTRL+C)
@@ -45,87 +61,210 @@ func main() {
 		os.Exit(0)
 	}
 
+	appConfig, err := appconfig.LoadAppConfigurationFromFile(configuration.CONFIGURATION_FILE_NAME)
+	if err != nil {
+		log.Println("MailSlurper: ERROR - There was an error reading your configuration file:", err)
+		os.Exit(0)
+	}
+
 	/*
-	 * Setup global database connection handle
+	 * A migration dry run only reads the schema version, so it happens
+	 * before any storage engine is connected; connecting creates tables.
+	 * The memory engine has no schema to migrate.
 	 */
-	storageType, databaseConnection := config.GetDatabaseConfiguration()
+	if *migrateDryRun {
+		if appConfig.IsMemoryStorage(config) {
+			log.Println("MailSlurper: ERROR - -migrate-dry-run needs a database; the memory storage engine has no schema to migrate")
+			os.Exit(0)
+		}
+
+		if err = migrateDatabase(config, true); err != nil {
+			log.Println("MailSlurper: ERROR - There was an error reading your database migrations:", err.Error())
+		}
 
-	if global.Database, err = storage.ConnectToStorage(storageType, databaseConnection); err != nil {
-		log.Println("MailSlurper: ERROR - There was an error connecting to your data storage:", err.Error())
 		os.Exit(0)
 	}
 
-	defer global.Database.Disconnect()
-
 	/*
-	 * Setup the server pool
+	 * Setup the database connection handle. The "memory" engine keeps
+	 * everything in process and stands in for both the libmailslurper
+	 * storage and the mail store. PostgreSQL storage is provided by
+	 * MailSlurper; the other engines come from libmailslurper. Database
+	 * schemas are migrated to the current version before use.
 	 */
-	pool := server.NewServerPool(config.MaxWorkers)
+	var database storage.IStorage
+	var mailStore mailstore.IMailStore
 
-	/*
-	 * Setup the SMTP listener
-	 */
-	smtpServer, err := server.SetupSMTPServerListener(config)
-	if err != nil {
-		log.Println("MailSlurper: ERROR - There was a problem starting the SMTP listener:", err)
-		os.Exit(0)
+	if appConfig.IsMemoryStorage(config) {
+		log.Printf("MailSlurper: INFO - Keeping up to %d mail items in memory\n", appConfig.MemoryMaxMailCount)
+
+		memoryStore := mailstore.NewMemoryStore(appConfig.MemoryMaxMailCount)
+		database = memoryStore
+		mailStore = memoryStore
+	} else {
+		if appConfig.IsPostgresStorage(config) {
+			database, err = mailstore.NewPostgresStorage(config)
+		} else {
+			storageType, databaseConnection := config.GetDatabaseConfiguration()
+			database, err = storage.ConnectToStorage(storageType, databaseConnection)
+		}
+
+		if err != nil {
+			log.Println("MailSlurper: ERROR - There was an error connecting to your data storage:", err.Error())
+			os.Exit(0)
+		}
+
+		if err = migrateDatabase(config, false); err != nil {
+			log.Println("MailSlurper: ERROR - There was an error migrating your database:", err.Error())
+			os.Exit(0)
+		}
+
+		if mailStore, err = mailstore.NewMailStore(config); err != nil {
+			log.Println("MailSlurper: ERROR - There was an error connecting to your data storage:", err.Error())
+			os.Exit(0)
+		}
 	}
 
-	defer server.CloseSMTPServerListener(smtpServer)
+	defer database.Disconnect()
+	defer mailStore.Disconnect()
 
 	/*
-	 * Setup receivers (subscribers) to handle new mail items.
+	 * Attachment contents can be kept on disk instead of in the database,
+	 * with each distinct file stored once
 	 */
-	receivers := []receiver.IMailItemReceiver{
-		receiver.NewDatabaseReceiver(global.Database),
+	if appConfig.IsAttachmentStoreEnabled() {
+		attachmentStore, err := attachmentstore.NewAttachmentStore(appConfig.AttachmentDirectory)
+		if err != nil {
+			log.Println("MailSlurper: ERROR - There was an error opening the attachment directory:", err.Error())
+			os.Exit(0)
+		}
+
+		log.Printf("MailSlurper: INFO - Storing attachments in %s\n", appConfig.AttachmentDirectory)
+
+		attachmentStorage := attachmentstore.NewAttachmentStorage(database, mailStore, attachmentStore)
+		database = attachmentStorage
+		mailStore = attachmentStorage
 	}
 
 	/*
-	 * Start the SMTP dispatcher
+	 * Exporting or importing an archive from the command line uses the
+	 * same storage and webhooks as the server, then exits
 	 */
-	go server.Dispatch(pool, smtpServer, receivers)
+	if *exportFile != "" || *importFile != "" {
+		receivers := []receiver.IMailItemReceiver{}
+		if len(appConfig.Webhooks) > 0 {
+			receivers = append(receivers, webhook.NewWebhookReceiver(appConfig.Webhooks))
+		}
 
-	/*
-	 * Application context gets passed around all over the place
-	 */
-	appContext := &middleware.AppContext{
-		Config: config,
-	}
+		dispatcher := dispatch.NewMailDispatcher(database, mailStore, receivers)
 
-	httpListener := listener.NewHTTPListenerService(config.WWWAddress, config.WWWPort, appContext)
+		if err = runArchiveCommand(*exportFile, *exportFormat, *exportQuery, *importFile, database, mailStore, dispatcher); err != nil {
+			log.Println("MailSlurper: ERROR -", err.Error())
+		}
 
-	setupMiddleware(httpListener, appContext)
-	setupRoutes(httpListener, appContext)
+		return
+	}
 
 	/*
-	 * Setup the app HTTP listener
+	 * Start the SMTP, POP3, IMAP and HTTP listeners and the background
+	 * services
 	 */
-	go func() {
-		if err := httpListener.StartHTTPListener(config); err != nil {
-			log.Printf("MailSlurper: ERROR - Error starting HTTP listener: %s\n", err.Error())
-			os.Exit(1)
-		}
-	}()
+	mailServer, err := server.NewServer(&server.Options{
+		Config:    config,
+		AppConfig: appConfig,
+		Database:  database,
+		MailStore: mailStore,
+	})
+
+	if err != nil {
+		log.Println("MailSlurper: ERROR -", err.Error())
+		os.Exit(1)
+	}
+
+	defer mailServer.Close()
 
 	if config.AutoStartBrowser {
 		startBrowser(config)
 	}
 
-	/*
-	 * Start the services server
-	 */
-	serviceTierConfiguration := &configuration.ServiceTierConfiguration{
-		Address:  config.ServiceAddress,
-		Port:     config.ServicePort,
-		Database: global.Database,
-		CertFile: config.CertFile,
-		KeyFile:  config.KeyFile,
+	if err = mailServer.Wait(); err != nil {
+		log.Println("MailSlurper: ERROR -", err.Error())
+		os.Exit(1)
 	}
+}
 
-	if err = libmailslurper.StartServiceTier(serviceTierConfiguration); err != nil {
-		log.Printf("MailSlurper: ERROR - Error starting MailSlurper services server: %s\n", err.Error())
-		os.Exit(1)
+/*
+migrateDatabase applies the pending schema migrations. With dryRun the
+SQL is printed instead.
+*/
+func migrateDatabase(config *configuration.Configuration, dryRun bool) error {
+	db, engine, err := mailstore.OpenDatabase(config)
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	migrator := migration.NewMigrator(db, engine)
+
+	if dryRun {
+		return migrator.WritePendingSQL(os.Stdout)
 	}
+
+	_, err = migrator.Migrate()
+	return err
+}
+
+/*
+runArchiveCommand carries out the -export or -import command line
+options
+*/
+func runArchiveCommand(exportFile, exportFormat, exportQuery, importFile string, database storage.IStorage, mailStore mailstore.IMailStore, dispatcher *dispatch.MailDispatcher) error {
+	if importFile != "" {
+		file, err := os.Open(importFile)
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+
+		mailItemIDs, err := mailarchive.Import(file, dispatcher)
+		log.Printf("MailSlurper: INFO - Imported %d mail item(s) from %s\n", len(mailItemIDs), importFile)
+		return err
+	}
+
+	if exportFormat == "" {
+		exportFormat = strings.TrimPrefix(strings.ToLower(filepath.Ext(exportFile)), ".")
+	}
+
+	values, err := url.ParseQuery(exportQuery)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(exportFile)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	archiveWriter, err := mailarchive.NewArchiveWriter(exportFormat, file)
+	if err != nil {
+		return err
+	}
+
+	exported, err := mailarchive.Export(archiveWriter, mailquery.NewMailQueryFromValues(values), database, mailStore)
+	if err != nil {
+		return err
+	}
+
+	if err = archiveWriter.Close(); err != nil {
+		return err
+	}
+
+	log.Printf("MailSlurper: INFO - Exported %d mail item(s) to %s\n", exported, exportFile)
+	return nil
 }
 
 func startBrowser(config *configuration.Configuration) {
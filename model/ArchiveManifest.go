@@ -0,0 +1,30 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+ArchiveManifest is the manifest.json of a zip export. It lists every
+.eml file in the archive with the details a message does not carry
+itself, so an import can restore them.
+*/
+type ArchiveManifest struct {
+	Version    int             `json:"version"`
+	ExportedAt string          `json:"exportedAt"`
+	MailItems  []*ArchiveEntry `json:"mailItems"`
+}
+
+/*
+ArchiveEntry describes one message in a zip export. FromAddress and
+ToAddresses are the envelope the mail was received with.
+*/
+type ArchiveEntry struct {
+	FileName    string   `json:"fileName"`
+	MailItemID  string   `json:"mailItemId"`
+	DateSent    string   `json:"dateSent"`
+	FromAddress string   `json:"fromAddress"`
+	ToAddresses []string `json:"toAddresses"`
+	Subject     string   `json:"subject"`
+	Tag         string   `json:"tag,omitempty"`
+}
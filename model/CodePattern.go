@@ -0,0 +1,15 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+CodePattern finds one kind of code, such as a one-time password, in
+captured mail. Pattern is a regular expression; when it has a capture
+group the first group is the code, otherwise the whole match is.
+*/
+type CodePattern struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
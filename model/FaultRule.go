@@ -0,0 +1,36 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+FaultRule makes the SMTP listeners misbehave on purpose so mailers can
+be tested against rejections, deferrals and timeouts. A rule applies
+at one SMTP stage: "connect", "helo", "mail", "rcpt", "data" (the DATA
+command) or "message" (after the message content is received).
+
+Every match criteria that is set must match. MailFrom and RcptTo are
+wildcard patterns such as "*@example.com", ClientIP is an address or
+CIDR range, and MinSize/MaxSize bound the message size in bytes.
+
+Action is "reply" (answer with ReplyCode and ReplyText instead of the
+normal reply), "delay" (wait DelaySeconds, then carry on normally) or
+"disconnect" (drop the connection without a reply).
+*/
+type FaultRule struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Stage string `json:"stage"`
+
+	MailFrom string `json:"mailFrom"`
+	RcptTo   string `json:"rcptTo"`
+	MinSize  int    `json:"minSize"`
+	MaxSize  int    `json:"maxSize"`
+	ClientIP string `json:"clientIP"`
+
+	Action       string `json:"action"`
+	ReplyCode    int    `json:"replyCode"`
+	ReplyText    string `json:"replyText"`
+	DelaySeconds int    `json:"delaySeconds"`
+}
@@ -0,0 +1,20 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+FaultRuleMatch records a fault rule being applied to an SMTP session
+*/
+type FaultRuleMatch struct {
+	MatchedAt  string   `json:"matchedAt"`
+	RuleID     string   `json:"ruleId"`
+	RuleName   string   `json:"ruleName"`
+	Stage      string   `json:"stage"`
+	Action     string   `json:"action"`
+	ClientIP   string   `json:"clientIP"`
+	MailFrom   string   `json:"mailFrom"`
+	Recipients []string `json:"recipients"`
+	Size       int      `json:"size"`
+}
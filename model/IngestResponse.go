@@ -0,0 +1,13 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+IngestResponse lists the IDs of the mail items created by POST /ingest
+or POST /mail/import, in the order the messages were received
+*/
+type IngestResponse struct {
+	MailItemIDs []string `json:"mailItemIds"`
+}
@@ -0,0 +1,21 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+import (
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+)
+
+/*
+MailCollectionResponse is one page of mail items returned by GET /mail.
+Highlights is keyed by mail item ID and only present for a full-text
+search.
+*/
+type MailCollectionResponse struct {
+	MailItems        []mailitem.MailItem         `json:"mailItems"`
+	TotalPages       int                         `json:"totalPages"`
+	TotalRecordCount int                         `json:"totalRecordCount"`
+	Highlights       map[string]*SearchHighlight `json:"highlights,omitempty"`
+}
@@ -0,0 +1,35 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+MailExtraction is returned by GET /mail/{mailID}/extract. It holds the
+links found in a mail item and the codes matching the configured code
+patterns.
+*/
+type MailExtraction struct {
+	Links []*MailLink `json:"links"`
+	Codes []*MailCode `json:"codes"`
+}
+
+/*
+MailLink is a link found in a mail item. Source is "html" for an anchor
+in the HTML body, whose text is in Text, or "text" for an address in
+the plain text body.
+*/
+type MailLink struct {
+	URL    string `json:"url"`
+	Text   string `json:"text"`
+	Source string `json:"source"`
+}
+
+/*
+MailCode is a code found in a mail item. Name is the name of the code
+pattern that matched.
+*/
+type MailCode struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
@@ -0,0 +1,14 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+MailHeader is one header of a received message, in the order it
+appeared. Folded values are unfolded and encoded words are decoded.
+*/
+type MailHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
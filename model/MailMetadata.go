@@ -0,0 +1,20 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+MailMetadata holds details about how a mail item was received which
+the libmailslurper mail item does not carry. TLSVersion and TLSCipher
+are empty when the message arrived over a plaintext connection, and
+AuthUser is empty when the client did not authenticate. Tag comes from
+the SMTP listener the mail arrived on.
+*/
+type MailMetadata struct {
+	MailItemID string `json:"mailItemId"`
+	TLSVersion string `json:"tlsVersion"`
+	TLSCipher  string `json:"tlsCipher"`
+	AuthUser   string `json:"authUser"`
+	Tag        string `json:"tag"`
+}
@@ -0,0 +1,15 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+MailSize is the storage used by a mail item, in bytes. This is the size
+of the raw message when it was kept, otherwise the size of the body
+and attachments.
+*/
+type MailSize struct {
+	MailItemID string `json:"mailItemId"`
+	Size       int64  `json:"size"`
+}
@@ -0,0 +1,18 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+MailStreamEvent is the summary of a new mail item pushed to browsers
+over the mail stream.
+*/
+type MailStreamEvent struct {
+	ID              string   `json:"id"`
+	DateSent        string   `json:"dateSent"`
+	FromAddress     string   `json:"fromAddress"`
+	ToAddresses     []string `json:"toAddresses"`
+	Subject         string   `json:"subject"`
+	AttachmentCount int      `json:"attachmentCount"`
+}
@@ -0,0 +1,33 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+import (
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+)
+
+/*
+MailWaitResponse is returned by GET /mail/wait. MailItems holds the
+mail matching the criteria, oldest first. When the wait times out
+NearMisses lists the mail that came closest, best first, so a failing
+test can say why nothing matched.
+*/
+type MailWaitResponse struct {
+	MailItems  []mailitem.MailItem `json:"mailItems"`
+	NearMisses []*MailNearMiss     `json:"nearMisses,omitempty"`
+}
+
+/*
+MailNearMiss is a mail item that failed some of the wait criteria.
+Mismatches describes each criterion it failed.
+*/
+type MailNearMiss struct {
+	MailItemID  string   `json:"mailItemId"`
+	DateSent    string   `json:"dateSent"`
+	FromAddress string   `json:"fromAddress"`
+	ToAddresses []string `json:"toAddresses"`
+	Subject     string   `json:"subject"`
+	Mismatches  []string `json:"mismatches"`
+}
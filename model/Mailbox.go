@@ -0,0 +1,14 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+Mailbox is a group of mail items that arrived on SMTP listeners
+sharing the same tag
+*/
+type Mailbox struct {
+	Tag       string `json:"tag"`
+	MailCount int    `json:"mailCount"`
+}
@@ -0,0 +1,71 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+import (
+	"time"
+)
+
+const (
+	// PRUNE_60_DAYS removes mail older than 60 days
+	PRUNE_60_DAYS string = "60plus"
+
+	// PRUNE_30_DAYS removes mail older than 30 days
+	PRUNE_30_DAYS string = "30plus"
+
+	// PRUNE_2_WEEKS removes mail older than two weeks
+	PRUNE_2_WEEKS string = "2wksplus"
+
+	// PRUNE_ALL removes all mail
+	PRUNE_ALL string = "all"
+
+	// PRUNE_DATE_FORMAT is the date passed to the storage engine when pruning
+	PRUNE_DATE_FORMAT string = "2006-01-02"
+)
+
+/*
+PruneRequest is the body of DELETE /mail. PruneCode is one of the
+PRUNE_ constants.
+*/
+type PruneRequest struct {
+	PruneCode string `json:"pruneCode"`
+}
+
+/*
+IsValid returns true when the prune code is known
+*/
+func (pruneRequest *PruneRequest) IsValid() bool {
+	switch pruneRequest.PruneCode {
+	case PRUNE_60_DAYS, PRUNE_30_DAYS, PRUNE_2_WEEKS, PRUNE_ALL:
+		return true
+	}
+
+	return false
+}
+
+/*
+ConvertToDate returns the cutoff date for the prune code in the form the
+storage engines expect. Pruning everything has no cutoff, which is an
+empty string.
+*/
+func (pruneRequest *PruneRequest) ConvertToDate() string {
+	days := 0
+
+	switch pruneRequest.PruneCode {
+	case PRUNE_60_DAYS:
+		days = 60
+
+	case PRUNE_30_DAYS:
+		days = 30
+
+	case PRUNE_2_WEEKS:
+		days = 14
+
+	default:
+		return ""
+	}
+
+	return time.Now().AddDate(0, 0, -days).Format(PRUNE_DATE_FORMAT)
+}
@@ -0,0 +1,25 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+RetentionPolicy limits how much mail is kept. Mail older than
+MaxAgeDays is removed, then the oldest mail is removed until no more
+than MaxMailCount mail items and MaxStorageSize bytes remain. A limit
+of zero is not enforced. The policy is applied every IntervalMinutes.
+*/
+type RetentionPolicy struct {
+	MaxAgeDays      int   `json:"maxAgeDays"`
+	MaxMailCount    int   `json:"maxMailCount"`
+	MaxStorageSize  int64 `json:"maxStorageSize"`
+	IntervalMinutes int   `json:"intervalMinutes"`
+}
+
+/*
+IsEnabled returns true when the policy sets at least one limit
+*/
+func (policy *RetentionPolicy) IsEnabled() bool {
+	return policy.MaxAgeDays > 0 || policy.MaxMailCount > 0 || policy.MaxStorageSize > 0
+}
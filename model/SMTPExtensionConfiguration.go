@@ -0,0 +1,21 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+SMTPExtensionConfiguration switches the optional ESMTP extensions on
+or off so clients can be tested against servers with and without them.
+MaxMessageSize is the largest message accepted, in bytes; zero means
+no limit, except that a message sent with BDAT is capped at 64 MB. It
+is enforced even when SIZE is not advertised.
+*/
+type SMTPExtensionConfiguration struct {
+	Size           bool `json:"size"`
+	MaxMessageSize int  `json:"maxMessageSize"`
+	EightBitMIME   bool `json:"8bitmime"`
+	Pipelining     bool `json:"pipelining"`
+	SMTPUTF8       bool `json:"smtputf8"`
+	Chunking       bool `json:"chunking"`
+}
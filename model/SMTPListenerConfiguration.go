@@ -0,0 +1,17 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+SMTPListenerConfiguration describes one SMTP listener. TLSMode is one
+of "none", "starttls" or "implicit". Every mail item received on the
+listener is stored with its Tag, which the UI shows as a mailbox.
+*/
+type SMTPListenerConfiguration struct {
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	TLSMode string `json:"tlsMode"`
+	Tag     string `json:"tag"`
+}
@@ -0,0 +1,39 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+SMTPSessionRecord describes the SMTP session a mail item arrived in:
+who connected, the envelope, the authentication and TLS state, and the
+command/response transcript up to the point the mail was accepted.
+Credentials sent during AUTH are masked in the transcript.
+*/
+type SMTPSessionRecord struct {
+	MailItemID string                 `json:"mailItemId"`
+	ClientIP   string                 `json:"clientIp"`
+	ClientPort int                    `json:"clientPort"`
+	Helo       string                 `json:"helo"`
+	MailFrom   string                 `json:"mailFrom"`
+	RcptTo     []string               `json:"rcptTo"`
+	AuthUser   string                 `json:"authUser"`
+	TLSVersion string                 `json:"tlsVersion"`
+	TLSCipher  string                 `json:"tlsCipher"`
+	StartedAt  string                 `json:"startedAt"`
+	Transcript []*SMTPTranscriptEntry `json:"transcript"`
+}
+
+/*
+SMTPTranscriptEntry is one exchange in an SMTP session. Command holds
+what the client sent, one line per client line; it is empty for the
+server greeting. Response holds the server's reply lines. Duration is
+the number of milliseconds from receiving the command to sending the
+last reply.
+*/
+type SMTPTranscriptEntry struct {
+	ReceivedAt string  `json:"receivedAt"`
+	Command    string  `json:"command"`
+	Response   string  `json:"response"`
+	Duration   float64 `json:"duration"`
+}
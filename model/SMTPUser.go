@@ -0,0 +1,14 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+SMTPUser is a set of credentials accepted by SMTP AUTH when credential
+enforcement is turned on.
+*/
+type SMTPUser struct {
+	UserName string `json:"userName"`
+	Password string `json:"password"`
+}
@@ -0,0 +1,17 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+SearchDocument is the text of a mail item as kept in the full-text
+search index. Body is plain text, with any HTML removed.
+*/
+type SearchDocument struct {
+	MailItemID  string `json:"mailItemId"`
+	Subject     string `json:"subject"`
+	Body        string `json:"body"`
+	FromAddress string `json:"fromAddress"`
+	ToAddresses string `json:"toAddresses"`
+}
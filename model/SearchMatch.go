@@ -0,0 +1,24 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+SearchMatch is a mail item found by a full-text search. A higher Rank
+is a better match. Ranks are only comparable within one search.
+*/
+type SearchMatch struct {
+	MailItemID string  `json:"mailItemId"`
+	Rank       float64 `json:"rank"`
+}
+
+/*
+SearchHighlight describes why a mail item matched a full-text search.
+Snippet is an HTML excerpt of the mail with the matching words wrapped
+in <mark> tags; everything else in it is escaped.
+*/
+type SearchHighlight struct {
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
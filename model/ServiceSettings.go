@@ -6,10 +6,13 @@ package model
 
 /*
 ServiceSettings represents the necessary settings to connect to
-and talk to the MailSlurper service tier.
+and talk to the MailSlurper service tier. When ServicePathPrefix is set
+the service tier is on the same origin as the web UI, under that path,
+and the address and port should be ignored.
 */
 type ServiceSettings struct {
-	ServiceAddress string `json:"serviceAddress"`
-	ServicePort    int    `json:"servicePort"`
-	Version        string `json:"version"`
+	ServiceAddress    string `json:"serviceAddress"`
+	ServicePort       int    `json:"servicePort"`
+	ServicePathPrefix string `json:"servicePathPrefix,omitempty"`
+	Version           string `json:"version"`
 }
@@ -0,0 +1,22 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package model
+
+/*
+WebhookConfiguration describes a single HTTP endpoint that receives
+every captured mail item as JSON. FromFilters and ToFilters are
+optional lists of wildcard patterns (e.g. "*@example.com"). When
+provided, a mail item must match at least one pattern of each list
+to be sent to this URL.
+*/
+type WebhookConfiguration struct {
+	URL               string   `json:"url"`
+	Secret            string   `json:"secret"`
+	MaxRetries        int      `json:"maxRetries"`
+	RetryDelaySeconds int      `json:"retryDelaySeconds"`
+	TimeoutSeconds    int      `json:"timeoutSeconds"`
+	FromFilters       []string `json:"fromFilters"`
+	ToFilters         []string `json:"toFilters"`
+}
@@ -4,7 +4,7 @@
 CREATE TABLE mailitem (
 	id VARCHAR(36) NOT NULL PRIMARY KEY,
 	dateSent DATETIME,
-	fromAddress VARCHAR(50) NOT NULL,
+	fromAddress VARCHAR(255) NOT NULL,
 	toAddressList VARCHAR(1024) NOT NULL,
 	subject VARCHAR(255),
 	xmailer VARCHAR(50),
@@ -23,3 +23,72 @@ CREATE TABLE attachment (
 	contentType VARCHAR(50),
 	content TEXT
 );
+
+/*
+ * Mail Metadata
+ */
+CREATE TABLE mailmetadata (
+	mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+	tlsVersion VARCHAR(20),
+	tlsCipher VARCHAR(100),
+	authUser VARCHAR(255),
+	tag VARCHAR(100)
+);
+
+/*
+ * SMTP Session
+ */
+CREATE TABLE mailsession (
+	mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+	clientIp VARCHAR(45),
+	clientPort INT,
+	helo VARCHAR(255),
+	mailFrom VARCHAR(255),
+	rcptTo TEXT,
+	authUser VARCHAR(255),
+	tlsVersion VARCHAR(20),
+	tlsCipher VARCHAR(100),
+	startedAt VARCHAR(20),
+	transcript TEXT
+);
+
+/*
+ * Raw Message
+ */
+CREATE TABLE mailraw (
+	mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+	content VARBINARY(MAX)
+);
+
+/*
+ * Attachment References
+ */
+CREATE TABLE attachmentref (
+	mailItemId VARCHAR(36) NOT NULL,
+	attachmentHash VARCHAR(64) NOT NULL
+);
+
+/*
+ * Full-Text Search
+ */
+CREATE TABLE mailsearch (
+	mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+	subject VARCHAR(MAX),
+	body VARCHAR(MAX),
+	fromAddress VARCHAR(255),
+	toAddresses VARCHAR(MAX)
+);
+
+/*
+ * Headers
+ */
+CREATE TABLE mailheader (
+	mailItemId VARCHAR(36) NOT NULL,
+	headerIndex INT NOT NULL,
+	headerName VARCHAR(255) NOT NULL,
+	headerKey VARCHAR(255) NOT NULL,
+	headerValue VARCHAR(MAX)
+);
+
+CREATE INDEX idx_mailheader_mailItemId ON mailheader (mailItemId);
+CREATE INDEX idx_mailheader_headerKey ON mailheader (headerKey);
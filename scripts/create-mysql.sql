@@ -4,7 +4,7 @@
 CREATE TABLE mailitem (
 	id VARCHAR(36) NOT NULL PRIMARY KEY,
 	dateSent DATETIME,
-	fromAddress VARCHAR(50) NOT NULL,
+	fromAddress VARCHAR(255) NOT NULL,
 	toAddressList VARCHAR(1024) NOT NULL,
 	subject VARCHAR(255),
 	xmailer VARCHAR(50),
@@ -23,3 +23,72 @@ CREATE TABLE attachment (
 	contentType VARCHAR(50),
 	content TEXT
 ) ENGINE=MyISAM;
+
+/*
+ * Mail Metadata
+ */
+CREATE TABLE mailmetadata (
+	mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+	tlsVersion VARCHAR(20),
+	tlsCipher VARCHAR(100),
+	authUser VARCHAR(255),
+	tag VARCHAR(100)
+) ENGINE=MyISAM;
+
+/*
+ * SMTP Session
+ */
+CREATE TABLE mailsession (
+	mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+	clientIp VARCHAR(45),
+	clientPort INT,
+	helo VARCHAR(255),
+	mailFrom VARCHAR(255),
+	rcptTo TEXT,
+	authUser VARCHAR(255),
+	tlsVersion VARCHAR(20),
+	tlsCipher VARCHAR(100),
+	startedAt VARCHAR(20),
+	transcript TEXT
+) ENGINE=MyISAM;
+
+/*
+ * Raw Message
+ */
+CREATE TABLE mailraw (
+	mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+	content LONGBLOB
+) ENGINE=MyISAM;
+
+/*
+ * Attachment References
+ */
+CREATE TABLE attachmentref (
+	mailItemId VARCHAR(36) NOT NULL,
+	attachmentHash VARCHAR(64) NOT NULL
+) ENGINE=MyISAM;
+
+/*
+ * Full-Text Search
+ */
+CREATE TABLE mailsearch (
+	mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+	subject TEXT,
+	body MEDIUMTEXT,
+	fromAddress VARCHAR(255),
+	toAddresses TEXT,
+	FULLTEXT ft_mailsearch (subject, body, fromAddress, toAddresses)
+) ENGINE=InnoDB;
+
+/*
+ * Headers
+ */
+CREATE TABLE mailheader (
+	mailItemId VARCHAR(36) NOT NULL,
+	headerIndex INT NOT NULL,
+	headerName VARCHAR(255) NOT NULL,
+	headerKey VARCHAR(255) NOT NULL,
+	headerValue TEXT,
+	INDEX idx_mailheader_mailItemId (mailItemId),
+	INDEX idx_mailheader_headerKey (headerKey)
+);
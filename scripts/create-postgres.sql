@@ -0,0 +1,115 @@
+/*
+ * Mail Item
+ */
+CREATE TABLE mailitem (
+	id VARCHAR(36) NOT NULL PRIMARY KEY,
+	dateSent TIMESTAMPTZ NOT NULL,
+	fromAddress TEXT NOT NULL,
+	toAddressList TEXT NOT NULL,
+	subject TEXT,
+	xmailer TEXT,
+	mimeVersion TEXT,
+	body TEXT,
+	contentType TEXT,
+	boundary TEXT
+);
+
+CREATE INDEX idx_mailitem_datesent ON mailitem (dateSent);
+
+/*
+ * Attachment
+ */
+CREATE TABLE attachment (
+	id VARCHAR(36) NOT NULL PRIMARY KEY,
+	mailItemId VARCHAR(36) NOT NULL REFERENCES mailitem (id) ON DELETE CASCADE,
+	fileName TEXT,
+	contentType TEXT,
+	contentTransferEncoding TEXT,
+	content BYTEA
+);
+
+CREATE INDEX idx_attachment_mailitemid ON attachment (mailItemId);
+
+/*
+ * Mail Metadata
+ */
+CREATE TABLE mailmetadata (
+	mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+	tlsVersion VARCHAR(20),
+	tlsCipher VARCHAR(100),
+	authUser VARCHAR(255),
+	tag VARCHAR(100)
+);
+
+/*
+ * SMTP Session
+ */
+CREATE TABLE mailsession (
+	mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+	clientIp VARCHAR(45),
+	clientPort INT,
+	helo VARCHAR(255),
+	mailFrom VARCHAR(255),
+	rcptTo TEXT,
+	authUser VARCHAR(255),
+	tlsVersion VARCHAR(20),
+	tlsCipher VARCHAR(100),
+	startedAt VARCHAR(20),
+	transcript TEXT
+);
+
+/*
+ * Raw Message
+ */
+CREATE TABLE mailraw (
+	mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+	content BYTEA
+);
+
+/*
+ * Attachment References
+ */
+CREATE TABLE attachmentref (
+	mailItemId VARCHAR(36) NOT NULL,
+	attachmentHash VARCHAR(64) NOT NULL
+);
+
+/*
+ * Full-Text Search
+ */
+CREATE TABLE mailsearch (
+	mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+	subject TEXT,
+	body TEXT,
+	fromAddress TEXT,
+	toAddresses TEXT,
+	document TSVECTOR
+);
+
+CREATE INDEX idx_mailsearch_document ON mailsearch USING gin (document);
+
+/*
+ * Search indexes. Subject, body and address searches are substring
+ * matches, which need trigram indexes.
+ */
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX idx_mailitem_subject_trgm ON mailitem USING gin (subject gin_trgm_ops);
+CREATE INDEX idx_mailitem_body_trgm ON mailitem USING gin (body gin_trgm_ops);
+CREATE INDEX idx_mailitem_fromaddress_trgm ON mailitem USING gin (fromAddress gin_trgm_ops);
+CREATE INDEX idx_mailitem_toaddresslist_trgm ON mailitem USING gin (toAddressList gin_trgm_ops);
+CREATE INDEX idx_mailmetadata_tag ON mailmetadata (tag);
+
+/*
+ * Headers
+ */
+CREATE TABLE mailheader (
+	mailItemId VARCHAR(36) NOT NULL,
+	headerIndex INT NOT NULL,
+	headerName VARCHAR(255) NOT NULL,
+	headerKey VARCHAR(255) NOT NULL,
+	headerValue TEXT
+);
+
+CREATE INDEX idx_mailheader_mailItemId ON mailheader (mailItemId);
+CREATE INDEX idx_mailheader_headerKey ON mailheader (headerKey);
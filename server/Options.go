@@ -0,0 +1,47 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package server
+
+import (
+	"github.com/mailslurper/libmailslurper/configuration"
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/services/appconfig"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+)
+
+const (
+	// DEFAULT_ADDRESS is where listeners bind when no configuration is given
+	DEFAULT_ADDRESS string = "127.0.0.1"
+)
+
+/*
+Options describes the server NewServer starts. Config and AppConfig are
+the settings normally read from config.json; when Config is nil every
+listener binds to a free port on DEFAULT_ADDRESS, and when AppConfig is
+nil the defaults from appconfig.NewAppConfiguration are used.
+
+Database and MailStore are the storage to use. When they are nil the
+server keeps mail in memory and disconnects the storage on Close;
+storage passed in is left for the caller to disconnect.
+*/
+type Options struct {
+	Config    *configuration.Configuration
+	AppConfig *appconfig.AppConfiguration
+	Database  storage.IStorage
+	MailStore mailstore.IMailStore
+}
+
+/*
+NewDefaultConfiguration returns core settings for a server that keeps
+mail in memory and listens on free ports on DEFAULT_ADDRESS
+*/
+func NewDefaultConfiguration() *configuration.Configuration {
+	return &configuration.Configuration{
+		WWWAddress:     DEFAULT_ADDRESS,
+		ServiceAddress: DEFAULT_ADDRESS,
+		SMTPAddress:    DEFAULT_ADDRESS,
+		DBEngine:       "memory",
+	}
+}
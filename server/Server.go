@@ -0,0 +1,491 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+/*
+Package server runs a complete MailSlurper server: SMTP listeners,
+storage, the web application and the service tier. The mailslurper
+command uses it, and Go tests can start their own servers in process.
+
+	mailServer, err := server.NewServer(&server.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer mailServer.Close()
+
+	// Send mail to mailServer.SMTPAddress, then read it back
+	mailItems, err := mailServer.Client().WaitForMail(ctx, &client.WaitCriteria{To: "user@example.com"})
+
+Each server has its own storage and listeners, so any number of them
+can run in one process.
+*/
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mailslurper/libmailslurper"
+	"github.com/mailslurper/libmailslurper/configuration"
+	"github.com/mailslurper/libmailslurper/receiver"
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/client"
+	"github.com/mailslurper/mailslurper/services/appconfig"
+	"github.com/mailslurper/mailslurper/services/dispatch"
+	"github.com/mailslurper/mailslurper/services/faultinjection"
+	"github.com/mailslurper/mailslurper/services/imap"
+	"github.com/mailslurper/mailslurper/services/listener"
+	"github.com/mailslurper/mailslurper/services/mailextract"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+	"github.com/mailslurper/mailslurper/services/mailstream"
+	"github.com/mailslurper/mailslurper/services/middleware"
+	"github.com/mailslurper/mailslurper/services/pop3"
+	"github.com/mailslurper/mailslurper/services/retention"
+	"github.com/mailslurper/mailslurper/services/searchindex"
+	"github.com/mailslurper/mailslurper/services/smtp"
+	"github.com/mailslurper/mailslurper/services/webhook"
+)
+
+const (
+	// INTERNAL_SERVICE_ADDRESS is where the libmailslurper service tier listens
+	INTERNAL_SERVICE_ADDRESS string = "127.0.0.1"
+
+	// INTERNAL_SERVICE_ATTEMPTS is how many ports are tried for the libmailslurper service tier
+	INTERNAL_SERVICE_ATTEMPTS int = 3
+
+	// INTERNAL_SERVICE_TIMEOUT is how long the libmailslurper service tier has to start listening
+	INTERNAL_SERVICE_TIMEOUT time.Duration = 5 * time.Second
+)
+
+/*
+Server is a running MailSlurper server. SMTPAddresses holds the address
+of each SMTP listener, in the order of appconfig's GetSMTPListeners, and
+SMTPAddress the first of them. WWWURL is the web application and
+ServiceURL the service tier, which in single port mode is a path on the
+web application. Typical usage is to call NewServer(), then Close when
+done. The mailslurper command calls Wait to run until a listener fails.
+*/
+type Server struct {
+	SMTPAddress   string
+	SMTPAddresses []string
+	WWWURL        string
+	ServiceURL    string
+
+	Database   storage.IStorage
+	MailStore  mailstore.IMailStore
+	Dispatcher *dispatch.MailDispatcher
+	MailStream *mailstream.MailStreamReceiver
+
+	ownsStorage      bool
+	retentionService *retention.RetentionService
+	pop3Server       *pop3.POP3Server
+	imapServer       *imap.IMAPServer
+	smtpServers      []*smtp.SMTPServer
+	httpListener     *listener.HTTPListenerService
+	serviceListener  *listener.HTTPListenerService
+
+	errors    chan error
+	closed    chan bool
+	closeOnce sync.Once
+}
+
+/*
+NewServer starts a MailSlurper server and returns once every listener
+is accepting connections. Ports of zero in options.Config are replaced
+with the ports chosen. When a listener cannot be started everything
+already started is closed and the error is returned.
+*/
+func NewServer(options *Options) (*Server, error) {
+	config := options.Config
+	if config == nil {
+		config = NewDefaultConfiguration()
+	}
+
+	appConfig := options.AppConfig
+	if appConfig == nil {
+		appConfig = appconfig.NewAppConfiguration()
+	}
+
+	result := &Server{
+		Database:  options.Database,
+		MailStore: options.MailStore,
+
+		errors: make(chan error, 3),
+		closed: make(chan bool),
+	}
+
+	if result.Database == nil || result.MailStore == nil {
+		memoryStore := mailstore.NewMemoryStore(appConfig.MemoryMaxMailCount)
+		result.Database = memoryStore
+		result.MailStore = memoryStore
+		result.ownsStorage = true
+	}
+
+	if err := result.start(config, appConfig); err != nil {
+		result.Close()
+		return nil, err
+	}
+
+	return result, nil
+}
+
+/*
+Client returns a client for this server's service tier
+*/
+func (server *Server) Client() *client.Client {
+	return client.NewClient(server.ServiceURL)
+}
+
+/*
+Wait blocks until a listener fails, returning its error, or until
+Close is called
+*/
+func (server *Server) Wait() error {
+	select {
+	case err := <-server.errors:
+		return err
+
+	case <-server.closed:
+		return nil
+	}
+}
+
+/*
+Close stops every listener and background service, and disconnects the
+storage when the server created it. The libmailslurper service tier
+offers no way to stop it, so its internal listener stays open until the
+process exits; nothing reaches it once the service listener is closed.
+*/
+func (server *Server) Close() error {
+	server.closeOnce.Do(func() {
+		close(server.closed)
+
+		for _, smtpServer := range server.smtpServers {
+			smtpServer.Close()
+		}
+
+		if server.httpListener != nil {
+			server.httpListener.Close()
+		}
+
+		if server.serviceListener != nil {
+			server.serviceListener.Close()
+		}
+
+		if server.pop3Server != nil {
+			server.pop3Server.Close()
+		}
+
+		if server.imapServer != nil {
+			server.imapServer.Close()
+		}
+
+		if server.retentionService != nil {
+			server.retentionService.Close()
+		}
+
+		if server.ownsStorage {
+			server.Database.Disconnect()
+			server.MailStore.Disconnect()
+		}
+	})
+
+	return nil
+}
+
+func (server *Server) start(config *configuration.Configuration, appConfig *appconfig.AppConfiguration) error {
+	var err error
+
+	/*
+	 * The mail stream publishes new mail to browsers, IMAP clients in
+	 * IDLE and requests waiting for mail
+	 */
+	server.MailStream = mailstream.NewMailStreamReceiver()
+
+	/*
+	 * Setup receivers (subscribers) to handle new mail items.
+	 */
+	receivers := []receiver.IMailItemReceiver{
+		server.MailStream,
+	}
+
+	if len(appConfig.Webhooks) > 0 {
+		log.Printf("MailSlurper: INFO - Sending new mail to %d webhook(s)\n", len(appConfig.Webhooks))
+		receivers = append(receivers, webhook.NewWebhookReceiver(appConfig.Webhooks))
+	}
+
+	/*
+	 * The dispatcher stores new mail, then passes it on to the receivers
+	 */
+	server.Dispatcher = dispatch.NewMailDispatcher(server.Database, server.MailStore, receivers)
+
+	/*
+	 * Index mail captured before the full-text search index existed
+	 */
+	go func() {
+		indexed, err := searchindex.IndexMissingMail(server.Database, server.MailStore)
+		if err != nil {
+			log.Printf("MailSlurper: ERROR - Unable to build the search index: %s\n", err.Error())
+		}
+
+		if indexed > 0 {
+			log.Printf("MailSlurper: INFO - Added %d mail item(s) to the search index\n", indexed)
+		}
+	}()
+
+	/*
+	 * Apply the retention policy in the background
+	 */
+	if appConfig.IsRetentionEnabled() {
+		server.retentionService = retention.NewRetentionService(appConfig.Retention, server.MailStore)
+		server.retentionService.Start()
+	}
+
+	/*
+	 * Setup the optional POP3 listener
+	 */
+	if appConfig.IsPOP3Enabled() {
+		server.pop3Server = pop3.NewPOP3Server(
+			appConfig.POP3Address,
+			appConfig.POP3Port,
+			appConfig.POP3UserName,
+			appConfig.POP3Password,
+			server.Database,
+			server.MailStore,
+		)
+
+		if err = server.pop3Server.Start(); err != nil {
+			return fmt.Errorf("There was a problem starting the POP3 listener: %s", err.Error())
+		}
+	}
+
+	/*
+	 * Setup the optional IMAP listener
+	 */
+	if appConfig.IsIMAPEnabled() {
+		server.imapServer = imap.NewIMAPServer(
+			appConfig.IMAPAddress,
+			appConfig.IMAPPort,
+			appConfig.IMAPUserName,
+			appConfig.IMAPPassword,
+			server.Database,
+			server.MailStore,
+			server.MailStream,
+		)
+
+		if err = server.imapServer.Start(); err != nil {
+			return fmt.Errorf("There was a problem starting the IMAP listener: %s", err.Error())
+		}
+	}
+
+	/*
+	 * Setup the SMTP listeners. Each one has its own TLS mode and tags the
+	 * mail it receives. AUTH credentials are only checked when
+	 * smtpAuthEnforce is turned on. Fault rules can make the listeners
+	 * reject, defer, delay or drop sessions.
+	 */
+	tlsConfig, err := smtp.LoadTLSConfig(config.CertFile, config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("There was a problem loading the TLS certificate: %s", err.Error())
+	}
+
+	authenticator := smtp.NewSMTPAuthenticator(appConfig.SMTPAuthEnforce, appConfig.SMTPUsers)
+
+	faultRules, err := faultinjection.NewFaultRuleEngine(appConfig.FaultRules)
+	if err != nil {
+		return fmt.Errorf("There was a problem with the fault rules in your configuration file: %s", err.Error())
+	}
+
+	for _, listenerConfig := range appConfig.GetSMTPListeners(config) {
+		smtpServer := smtp.NewSMTPServer(listenerConfig, appConfig.SMTPExtensions, tlsConfig, config.MaxWorkers, authenticator, faultRules, server.Dispatcher)
+
+		if err = smtpServer.Start(); err != nil {
+			return fmt.Errorf("There was a problem starting the SMTP listener on %s:%d: %s", listenerConfig.Address, listenerConfig.Port, err.Error())
+		}
+
+		server.smtpServers = append(server.smtpServers, smtpServer)
+		server.SMTPAddresses = append(server.SMTPAddresses, smtpServer.Addr().String())
+	}
+
+	server.SMTPAddress = server.SMTPAddresses[0]
+	config.SMTPPort = server.smtpServers[0].Addr().(*net.TCPAddr).Port
+
+	/*
+	 * Links and codes such as one-time passwords are found in mail on
+	 * request, using the configured code patterns
+	 */
+	mailExtractor, err := mailextract.NewMailExtractor(appConfig.CodePatterns)
+	if err != nil {
+		return fmt.Errorf("There was a problem with the code patterns in your configuration file: %s", err.Error())
+	}
+
+	/*
+	 * Application context gets passed around all over the place
+	 */
+	appContext := &middleware.AppContext{
+		Config:            config,
+		Database:          server.Database,
+		MailStore:         server.MailStore,
+		MailStream:        server.MailStream,
+		FaultRules:        faultRules,
+		MailExtractor:     mailExtractor,
+		Dispatcher:        server.Dispatcher,
+		ServicePathPrefix: appConfig.GetServicePathPrefix(),
+	}
+
+	server.httpListener = listener.NewHTTPListenerService(config.WWWAddress, config.WWWPort, appContext)
+
+	setupMiddleware(server.httpListener, appContext)
+	setupRoutes(server.httpListener, appContext)
+
+	/*
+	 * Start the services server. The libmailslurper service tier runs on
+	 * an internal port behind MailSlurper's own service listener, which
+	 * handles the endpoints MailSlurper extends and proxies the rest.
+	 */
+	internalServicePort, err := server.startServiceTier()
+	if err != nil {
+		return err
+	}
+
+	server.serviceListener = listener.NewHTTPListenerService(config.ServiceAddress, config.ServicePort, appContext)
+
+	setupMiddleware(server.serviceListener, appContext)
+	setupServiceRoutes(server.serviceListener, appContext)
+
+	server.serviceListener.AddReverseProxy(&url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("%s:%d", INTERNAL_SERVICE_ADDRESS, internalServicePort),
+	}, secureAttachmentDownload)
+
+	scheme := "http"
+	if config.CertFile != "" && config.KeyFile != "" {
+		scheme = "https"
+	}
+
+	/*
+	 * In single port mode the app HTTP listener serves the service tier
+	 * under a path prefix, so the UI and the API share one origin.
+	 * Otherwise the service tier gets its own listener.
+	 */
+	if appConfig.IsSinglePortMode() {
+		log.Printf("MailSlurper: INFO - Serving the service tier under %s on the HTTP listener\n", appConfig.GetServicePathPrefix())
+
+		server.httpListener.AddMount(appConfig.GetServicePathPrefix(), server.serviceListener.Handler())
+		server.serviceListener = nil
+	} else {
+		if err = server.serviceListener.Listen(); err != nil {
+			return fmt.Errorf("Error starting MailSlurper services server: %s", err.Error())
+		}
+
+		config.ServicePort = server.serviceListener.Addr().(*net.TCPAddr).Port
+		server.ServiceURL = fmt.Sprintf("%s://%s", scheme, server.serviceListener.Addr().String())
+		server.serve(server.serviceListener, config)
+	}
+
+	if err = server.httpListener.Listen(); err != nil {
+		return fmt.Errorf("Error starting HTTP listener: %s", err.Error())
+	}
+
+	config.WWWPort = server.httpListener.Addr().(*net.TCPAddr).Port
+	server.WWWURL = fmt.Sprintf("%s://%s", scheme, server.httpListener.Addr().String())
+	server.serve(server.httpListener, config)
+
+	if appConfig.IsSinglePortMode() {
+		server.ServiceURL = server.WWWURL + appConfig.GetServicePathPrefix()
+	}
+
+	return nil
+}
+
+/*
+startServiceTier starts the libmailslurper service tier on a free
+internal port and waits until it accepts connections. The port is only
+known to be free when it is chosen, so when another process takes it
+first the service tier fails to start and another port is tried.
+*/
+func (server *Server) startServiceTier() (int, error) {
+	var err error
+
+	for attempt := 0; attempt < INTERNAL_SERVICE_ATTEMPTS; attempt++ {
+		var port int
+
+		if port, err = listener.GetFreePort(INTERNAL_SERVICE_ADDRESS); err != nil {
+			return 0, fmt.Errorf("Unable to find a port for the internal services server: %s", err.Error())
+		}
+
+		serviceTierConfiguration := &configuration.ServiceTierConfiguration{
+			Address:  INTERNAL_SERVICE_ADDRESS,
+			Port:     port,
+			Database: server.Database,
+		}
+
+		stopped := make(chan error, 1)
+
+		go func() {
+			stopped <- libmailslurper.StartServiceTier(serviceTierConfiguration)
+		}()
+
+		if err = waitForServiceTier(port, stopped); err == nil {
+			go func() {
+				if err := <-stopped; err != nil {
+					server.errors <- fmt.Errorf("Error running MailSlurper services server: %s", err.Error())
+				}
+			}()
+
+			return port, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Error starting MailSlurper services server: %s", err.Error())
+}
+
+/*
+waitForServiceTier returns once the service tier accepts connections on
+port, or with its error when it stops first
+*/
+func waitForServiceTier(port int, stopped chan error) error {
+	address := net.JoinHostPort(INTERNAL_SERVICE_ADDRESS, strconv.Itoa(port))
+	deadline := time.Now().Add(INTERNAL_SERVICE_TIMEOUT)
+
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-stopped:
+			if err == nil {
+				stopped <- nil
+			}
+
+			return err
+
+		default:
+		}
+
+		if connection, err := net.DialTimeout("tcp", address, 100*time.Millisecond); err == nil {
+			connection.Close()
+			return nil
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return fmt.Errorf("timed out waiting for %s", address)
+}
+
+/*
+serve services requests on an HTTP listener in the background. Errors
+other than the listener being closed are handed to Wait.
+*/
+func (server *Server) serve(httpListener *listener.HTTPListenerService, config *configuration.Configuration) {
+	go func() {
+		if err := httpListener.Serve(config); err != nil && err != http.ErrServerClosed {
+			server.errors <- err
+		}
+	}()
+}
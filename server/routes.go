@@ -2,7 +2,7 @@
 // Use of this source code is governed by the MIT license
 // that can be found in the LICENSE file.
 
-package main
+package server
 
 import (
 	"github.com/mailslurper/mailslurper/controllers"
@@ -18,6 +18,13 @@ func setupRoutes(httpListener *listener.HTTPListenerService, appContext *middlew
 		AddStaticRoute("/www/", "./www").
 		AddRoute("/", controllers.Index, "GET").
 		AddRoute("/admin", controllers.Admin, "GET").
+		AddRoute("/ingest", controllers.IngestMail, "POST").
+		AddRoute("/mail/{mailID}/extract", controllers.GetMailExtraction, "GET").
+		AddRoute("/mail/{mailID}/headers", controllers.GetMailHeaders, "GET").
+		AddRoute("/mail/{mailID}/metadata", controllers.GetMailMetadata, "GET").
+		AddRoute("/mail/{mailID}/raw", controllers.GetRawMessage, "GET").
+		AddRoute("/mail/{mailID}/session", controllers.GetMailSession, "GET").
+		AddRoute("/mailstream", controllers.MailStream, "GET").
 		AddRoute("/savedsearches", controllers.ManageSavedSearches, "GET").
 		AddRoute("/servicesettings", controllers.GetServiceSettings, "GET", "OPTIONS").
 		AddRoute("/version", controllers.GetVersion, "GET", "OPTIONS")
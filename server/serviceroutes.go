@@ -0,0 +1,70 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package server
+
+import (
+	"mime"
+	"net/http"
+	"regexp"
+
+	"github.com/mailslurper/mailslurper/controllers"
+	"github.com/mailslurper/mailslurper/services/listener"
+	"github.com/mailslurper/mailslurper/services/middleware"
+)
+
+/*
+attachmentDownloadPath matches the libmailslurper attachment download
+route
+*/
+var attachmentDownloadPath = regexp.MustCompile(`^/mail/[^/]+/attachment/[^/]+$`)
+
+/*
+Add service tier routes here using AddRoute and AddRouteWithMiddleware.
+Requests that match no route here are passed on to the libmailslurper
+service tier.
+*/
+func setupServiceRoutes(serviceListener *listener.HTTPListenerService, appContext *middleware.AppContext) {
+	serviceListener.
+		AddRoute("/faultrules", controllers.GetFaultRules, "GET", "OPTIONS").
+		AddRoute("/faultrules", controllers.SaveFaultRules, "PUT").
+		AddRoute("/faultrules", controllers.AddFaultRule, "POST").
+		AddRoute("/faultrules/matches", controllers.GetFaultRuleMatches, "GET", "OPTIONS").
+		AddRoute("/faultrules/{ruleID}", controllers.DeleteFaultRule, "DELETE", "OPTIONS").
+		AddRoute("/mail", controllers.GetMailCollection, "GET").
+		AddRoute("/mail", controllers.DeleteMail, "DELETE").
+		AddRoute("/mail/export", controllers.ExportMail, "GET", "OPTIONS").
+		AddRoute("/mail/import", controllers.ImportMail, "POST", "OPTIONS").
+		AddRoute("/mail/wait", controllers.WaitForMail, "GET", "OPTIONS").
+		AddRoute("/mail/{mailID}/extract", controllers.GetMailExtraction, "GET", "OPTIONS").
+		AddRoute("/mail/{mailID}/headers", controllers.GetMailHeaders, "GET", "OPTIONS").
+		AddRoute("/mail/{mailID}/raw", controllers.GetRawMessage, "GET", "OPTIONS").
+		AddRoute("/mail/{mailID}/session", controllers.GetMailSession, "GET", "OPTIONS").
+		AddRoute("/mailboxes", controllers.GetMailboxes, "GET", "OPTIONS")
+}
+
+/*
+secureAttachmentDownload makes browsers download attachments served by
+the libmailslurper service tier instead of showing them. The content
+type comes from the captured mail, so an HTML or SVG attachment shown
+inline would run its scripts on the service tier's origin, which in
+single port mode is also the web application's.
+*/
+func secureAttachmentDownload(response *http.Response) error {
+	if !attachmentDownloadPath.MatchString(response.Request.URL.Path) {
+		return nil
+	}
+
+	disposition := "attachment"
+
+	if _, parameters, err := mime.ParseMediaType(response.Header.Get("Content-Disposition")); err == nil && parameters["filename"] != "" {
+		if withFileName := mime.FormatMediaType("attachment", map[string]string{"filename": parameters["filename"]}); withFileName != "" {
+			disposition = withFileName
+		}
+	}
+
+	response.Header.Set("Content-Disposition", disposition)
+	response.Header.Set("X-Content-Type-Options", "nosniff")
+	return nil
+}
@@ -0,0 +1,222 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package appconfig
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/mailslurper/libmailslurper/configuration"
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/mailslurper/mailslurper/services/smtp"
+)
+
+const (
+	// DEFAULT_MEMORY_MAX_MAIL_COUNT is how many mail items the memory engine keeps when not configured
+	DEFAULT_MEMORY_MAX_MAIL_COUNT int = 1000
+
+	// DEFAULT_OTP_PATTERN finds six digit one-time codes
+	DEFAULT_OTP_PATTERN string = `\b\d{6}\b`
+
+	// DEFAULT_TOKEN_PATTERN finds tokens and codes passed in link query strings
+	DEFAULT_TOKEN_PATTERN string = `(?i)[?&](?:token|code|otp|key|verify|verification|confirm|confirmation)[\w-]*=([^&#\s"'<>]+)`
+)
+
+/*
+AppConfiguration holds the settings from config.json that are specific
+to the MailSlurper server application. The core settings (addresses,
+ports, database) are read by libmailslurper's configuration package
+from the same file.
+*/
+type AppConfiguration struct {
+	Webhooks []*model.WebhookConfiguration `json:"webhooks"`
+
+	ServicePathPrefix string `json:"servicePathPrefix"`
+
+	MemoryMaxMailCount  int    `json:"memoryMaxMailCount"`
+	AttachmentDirectory string `json:"attachmentDirectory"`
+
+	Retention *model.RetentionPolicy `json:"retention"`
+
+	SMTPSPort       int                                `json:"smtpsPort"`
+	SMTPAuthEnforce bool                               `json:"smtpAuthEnforce"`
+	SMTPUsers       []*model.SMTPUser                  `json:"smtpUsers"`
+	SMTPListeners   []*model.SMTPListenerConfiguration `json:"smtpListeners"`
+	SMTPExtensions  *model.SMTPExtensionConfiguration  `json:"smtpExtensions"`
+
+	FaultRules []*model.FaultRule `json:"faultRules"`
+
+	CodePatterns []*model.CodePattern `json:"codePatterns"`
+
+	POP3Address  string `json:"pop3Address"`
+	POP3Port     int    `json:"pop3Port"`
+	POP3UserName string `json:"pop3UserName"`
+	POP3Password string `json:"pop3Password"`
+
+	IMAPAddress  string `json:"imapAddress"`
+	IMAPPort     int    `json:"imapPort"`
+	IMAPUserName string `json:"imapUserName"`
+	IMAPPassword string `json:"imapPassword"`
+}
+
+/*
+IsMemoryStorage returns true when mail is kept in memory rather than in
+a database
+*/
+func (config *AppConfiguration) IsMemoryStorage(coreConfig *configuration.Configuration) bool {
+	return strings.ToLower(coreConfig.DBEngine) == "memory"
+}
+
+/*
+IsPostgresStorage returns true when mail is kept in a PostgreSQL
+database, which MailSlurper supports itself rather than through
+libmailslurper
+*/
+func (config *AppConfiguration) IsPostgresStorage(coreConfig *configuration.Configuration) bool {
+	engine := strings.ToLower(coreConfig.DBEngine)
+	return engine == "postgres" || engine == "postgresql"
+}
+
+/*
+IsAttachmentStoreEnabled returns true when attachment contents are kept
+in a directory on disk rather than in the database
+*/
+func (config *AppConfiguration) IsAttachmentStoreEnabled() bool {
+	return config.AttachmentDirectory != ""
+}
+
+/*
+IsRetentionEnabled returns true when a retention policy sets at least
+one limit
+*/
+func (config *AppConfiguration) IsRetentionEnabled() bool {
+	return config.Retention != nil && config.Retention.IsEnabled()
+}
+
+/*
+IsSinglePortMode returns true when the service tier is served by the
+web listener under servicePathPrefix instead of on its own port
+*/
+func (config *AppConfiguration) IsSinglePortMode() bool {
+	return config.GetServicePathPrefix() != ""
+}
+
+/*
+GetServicePathPrefix returns servicePathPrefix starting with a slash
+and without a trailing one, such as "/api". It is empty when single
+port mode is off.
+*/
+func (config *AppConfiguration) GetServicePathPrefix() string {
+	prefix := strings.Trim(strings.TrimSpace(config.ServicePathPrefix), "/")
+	if prefix == "" {
+		return ""
+	}
+
+	return "/" + prefix
+}
+
+/*
+IsIMAPEnabled returns true when an IMAP port has been configured
+*/
+func (config *AppConfiguration) IsIMAPEnabled() bool {
+	return config.IMAPPort > 0
+}
+
+/*
+IsPOP3Enabled returns true when a POP3 port has been configured
+*/
+func (config *AppConfiguration) IsPOP3Enabled() bool {
+	return config.POP3Port > 0
+}
+
+/*
+GetSMTPListeners returns every SMTP listener to start. The listener
+described by smtpAddress and smtpPort comes first, offering STARTTLS
+when a certificate is configured, followed by the optional smtpsPort
+listener and then the entries of smtpListeners.
+*/
+func (config *AppConfiguration) GetSMTPListeners(coreConfig *configuration.Configuration) []*model.SMTPListenerConfiguration {
+	tlsMode := smtp.TLS_MODE_NONE
+	if coreConfig.CertFile != "" && coreConfig.KeyFile != "" {
+		tlsMode = smtp.TLS_MODE_STARTTLS
+	}
+
+	result := []*model.SMTPListenerConfiguration{
+		{Address: coreConfig.SMTPAddress, Port: coreConfig.SMTPPort, TLSMode: tlsMode},
+	}
+
+	if config.IsSMTPSEnabled() {
+		result = append(result, &model.SMTPListenerConfiguration{Address: coreConfig.SMTPAddress, Port: config.SMTPSPort, TLSMode: smtp.TLS_MODE_IMPLICIT})
+	}
+
+	for _, listener := range config.SMTPListeners {
+		if listener.TLSMode == "" {
+			listener.TLSMode = smtp.TLS_MODE_NONE
+		}
+
+		result = append(result, listener)
+	}
+
+	return result
+}
+
+/*
+IsSMTPSEnabled returns true when an implicit-TLS SMTP port has been
+configured
+*/
+func (config *AppConfiguration) IsSMTPSEnabled() bool {
+	return config.SMTPSPort > 0
+}
+
+/*
+NewAppConfiguration returns the application specific settings used when
+config.json does not change them. Every ESMTP extension is enabled,
+and mail is searched for six digit codes and query string tokens.
+*/
+func NewAppConfiguration() *AppConfiguration {
+	return &AppConfiguration{
+		MemoryMaxMailCount: DEFAULT_MEMORY_MAX_MAIL_COUNT,
+		Webhooks:           make([]*model.WebhookConfiguration, 0),
+		SMTPUsers:          make([]*model.SMTPUser, 0),
+		SMTPListeners:      make([]*model.SMTPListenerConfiguration, 0),
+		FaultRules:         make([]*model.FaultRule, 0),
+		Retention:          &model.RetentionPolicy{},
+		SMTPExtensions: &model.SMTPExtensionConfiguration{
+			Size:         true,
+			EightBitMIME: true,
+			Pipelining:   true,
+			SMTPUTF8:     true,
+			Chunking:     true,
+		},
+		CodePatterns: []*model.CodePattern{
+			{Name: "One-time code", Pattern: DEFAULT_OTP_PATTERN},
+			{Name: "Query string token", Pattern: DEFAULT_TOKEN_PATTERN},
+		},
+	}
+}
+
+/*
+LoadAppConfigurationFromFile reads the application specific settings
+from a JSON configuration file. Every ESMTP extension is enabled unless
+the file switches it off.
+*/
+func LoadAppConfigurationFromFile(fileName string) (*AppConfiguration, error) {
+	result := NewAppConfiguration()
+
+	configFileHandle, err := os.Open(fileName)
+	if err != nil {
+		return result, err
+	}
+
+	defer configFileHandle.Close()
+
+	decoder := json.NewDecoder(configFileHandle)
+	if err = decoder.Decode(result); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
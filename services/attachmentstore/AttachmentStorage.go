@@ -0,0 +1,350 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package attachmentstore
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/mailslurper/libmailslurper/model/attachment"
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+	"github.com/mailslurper/libmailslurper/model/search"
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+)
+
+const (
+	// REFERENCE_PREFIX starts the contents of an attachment that were moved to the attachment store
+	REFERENCE_PREFIX string = "attachmentstore:sha256:"
+)
+
+/*
+AttachmentStorage sits in front of a storage engine and mail store and
+moves attachment contents into an AttachmentStore. The database keeps a
+short reference in place of the contents, and the mail store records
+which mail items refer to each file. Reading mail puts the contents
+back, so callers never see the references.
+
+Deleting or evicting a mail item releases its references and removes
+the files nothing refers to any more. Pruning sweeps the whole
+directory once.
+*/
+type AttachmentStorage struct {
+	storage.IStorage
+	mailstore.IMailStore
+
+	blobs *AttachmentStore
+	lock  sync.RWMutex
+
+	evictedLock sync.Mutex
+	evicted     []string
+}
+
+/*
+evictingStorage is a storage engine that drops mail on its own when it
+is full, such as the memory store
+*/
+type evictingStorage interface {
+	SetEvictionHandler(handler func(hashes []string))
+}
+
+/*
+NewAttachmentStorage creates a new AttachmentStorage object
+*/
+func NewAttachmentStorage(database storage.IStorage, mailStore mailstore.IMailStore, blobs *AttachmentStore) *AttachmentStorage {
+	result := &AttachmentStorage{
+		IStorage:   database,
+		IMailStore: mailStore,
+		blobs:      blobs,
+		evicted:    make([]string, 0),
+	}
+
+	if evicting, ok := database.(evictingStorage); ok {
+		evicting.SetEvictionHandler(result.evict)
+	}
+
+	return result
+}
+
+/*
+DeleteMail removes a single mail item, then any attachment files only it
+referred to
+*/
+func (attachmentStorage *AttachmentStorage) DeleteMail(mailID string) error {
+	attachmentStorage.lock.Lock()
+	defer attachmentStorage.lock.Unlock()
+
+	hashes, err := attachmentStorage.IMailStore.GetAttachmentReferences(mailID)
+	if err != nil {
+		return err
+	}
+
+	if err = attachmentStorage.IMailStore.DeleteMail(mailID); err != nil {
+		return err
+	}
+
+	attachmentStorage.release(hashes)
+	return nil
+}
+
+/*
+DeleteMails removes mail items, then sweeps once for the attachment
+files only they referred to
+*/
+func (attachmentStorage *AttachmentStorage) DeleteMails(mailIDs []string) (int, error) {
+	removed, err := attachmentStorage.IMailStore.DeleteMails(mailIDs)
+	if err != nil {
+		return removed, err
+	}
+
+	if removed > 0 {
+		attachmentStorage.sweep()
+	}
+
+	return removed, nil
+}
+
+/*
+DeleteMailsAfterDate prunes mail, then the attachment files only the
+pruned mail referred to
+*/
+func (attachmentStorage *AttachmentStorage) DeleteMailsAfterDate(startDate string) error {
+	if err := attachmentStorage.IStorage.DeleteMailsAfterDate(startDate); err != nil {
+		return err
+	}
+
+	attachmentStorage.sweep()
+	return nil
+}
+
+/*
+Disconnect closes both the storage engine and the mail store
+*/
+func (attachmentStorage *AttachmentStorage) Disconnect() {
+	attachmentStorage.IStorage.Disconnect()
+	attachmentStorage.IMailStore.Disconnect()
+}
+
+/*
+GetAttachment returns a single attachment with its contents
+*/
+func (attachmentStorage *AttachmentStorage) GetAttachment(mailID, attachmentID string) (attachment.Attachment, error) {
+	result, err := attachmentStorage.IStorage.GetAttachment(mailID, attachmentID)
+	if err != nil {
+		return result, err
+	}
+
+	err = attachmentStorage.resolve(&result)
+	return result, err
+}
+
+/*
+GetMailByID returns a single mail item with its attachments
+*/
+func (attachmentStorage *AttachmentStorage) GetMailByID(id string) (mailitem.MailItem, error) {
+	result, err := attachmentStorage.IStorage.GetMailByID(id)
+	if err != nil {
+		return result, err
+	}
+
+	result.Attachments, err = attachmentStorage.resolveAll(result.Attachments)
+	return result, err
+}
+
+/*
+GetMailCollection returns a page of mail items matching the search
+criteria
+*/
+func (attachmentStorage *AttachmentStorage) GetMailCollection(offset, length int, mailSearch *search.MailSearch) ([]mailitem.MailItem, error) {
+	result, err := attachmentStorage.IStorage.GetMailCollection(offset, length, mailSearch)
+	if err != nil {
+		return result, err
+	}
+
+	for index := range result {
+		if result[index].Attachments, err = attachmentStorage.resolveAll(result[index].Attachments); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+/*
+StoreMail writes the contents of each attachment to the attachment store
+and stores the mail item with references in their place. The mail item
+passed in keeps its contents. Files only the mail items evicted to make
+room referred to are removed afterwards.
+*/
+func (attachmentStorage *AttachmentStorage) StoreMail(mailItem *mailitem.MailItem) (string, error) {
+	id, err := attachmentStorage.storeMail(mailItem)
+
+	attachmentStorage.evictedLock.Lock()
+	evicted := attachmentStorage.evicted
+	attachmentStorage.evicted = make([]string, 0)
+	attachmentStorage.evictedLock.Unlock()
+
+	if len(evicted) > 0 {
+		attachmentStorage.lock.Lock()
+		attachmentStorage.release(evicted)
+		attachmentStorage.lock.Unlock()
+	}
+
+	return id, err
+}
+
+/*
+evict remembers the attachment files of mail items the storage engine
+evicted. They are released once the mail item being stored has recorded
+its own references, as it may share some of them.
+*/
+func (attachmentStorage *AttachmentStorage) evict(hashes []string) {
+	attachmentStorage.evictedLock.Lock()
+	defer attachmentStorage.evictedLock.Unlock()
+
+	attachmentStorage.evicted = append(attachmentStorage.evicted, hashes...)
+}
+
+/*
+release removes the attachment files in hashes that no mail item refers
+to any more. The caller must hold the write lock. Failures are logged;
+the files are picked up by the next sweep.
+*/
+func (attachmentStorage *AttachmentStorage) release(hashes []string) {
+	if len(hashes) == 0 {
+		return
+	}
+
+	referenceCounts, err := attachmentStorage.IMailStore.CountAttachmentReferences(hashes)
+	if err != nil {
+		log.Printf("MailSlurper: ERROR - Unable to count attachment references: %s\n", err.Error())
+		return
+	}
+
+	for hash, count := range referenceCounts {
+		if count > 0 {
+			continue
+		}
+
+		if err = attachmentStorage.blobs.Remove(hash); err != nil {
+			log.Printf("MailSlurper: ERROR - Unable to remove attachment file %s: %s\n", hash, err.Error())
+		}
+	}
+}
+
+/*
+storeMail stores a mail item for StoreMail
+*/
+func (attachmentStorage *AttachmentStorage) storeMail(mailItem *mailitem.MailItem) (string, error) {
+	/*
+	 * Hold off sweeps and releases until the new references are recorded,
+	 * or a file written here could be removed before anything refers to it
+	 */
+	attachmentStorage.lock.RLock()
+	defer attachmentStorage.lock.RUnlock()
+
+	stored := *mailItem
+	stored.Attachments = make([]*attachment.Attachment, 0, len(mailItem.Attachments))
+	hashes := make([]string, 0, len(mailItem.Attachments))
+
+	for _, mailAttachment := range mailItem.Attachments {
+		copied := *mailAttachment
+
+		if copied.Contents != "" {
+			hash, err := attachmentStorage.blobs.Put([]byte(copied.Contents))
+			if err != nil {
+				return "", err
+			}
+
+			copied.Contents = REFERENCE_PREFIX + hash
+			hashes = append(hashes, hash)
+		}
+
+		stored.Attachments = append(stored.Attachments, &copied)
+	}
+
+	id, err := attachmentStorage.IStorage.StoreMail(&stored)
+	if err != nil {
+		return "", err
+	}
+
+	for index, mailAttachment := range stored.Attachments {
+		mailItem.Attachments[index].ID = mailAttachment.ID
+		mailItem.Attachments[index].MailID = mailAttachment.MailID
+	}
+
+	if len(hashes) > 0 {
+		if err = attachmentStorage.IMailStore.StoreAttachmentReferences(id, hashes); err != nil {
+			attachmentStorage.IMailStore.DeleteMail(id)
+			return "", err
+		}
+	}
+
+	return id, nil
+}
+
+/*
+resolve replaces a reference to the attachment store with the contents
+it refers to. Attachments stored in the database are left alone.
+*/
+func (attachmentStorage *AttachmentStorage) resolve(mailAttachment *attachment.Attachment) error {
+	if !strings.HasPrefix(mailAttachment.Contents, REFERENCE_PREFIX) {
+		return nil
+	}
+
+	contents, err := attachmentStorage.blobs.Get(strings.TrimPrefix(mailAttachment.Contents, REFERENCE_PREFIX))
+	if err != nil {
+		return err
+	}
+
+	mailAttachment.Contents = string(contents)
+	return nil
+}
+
+/*
+resolveAll returns copies of attachments with their contents resolved.
+The attachments passed in may be shared with the storage engine, so
+they are never changed.
+*/
+func (attachmentStorage *AttachmentStorage) resolveAll(attachments []*attachment.Attachment) ([]*attachment.Attachment, error) {
+	result := make([]*attachment.Attachment, 0, len(attachments))
+
+	for _, mailAttachment := range attachments {
+		copied := *mailAttachment
+
+		if err := attachmentStorage.resolve(&copied); err != nil {
+			return attachments, err
+		}
+
+		result = append(result, &copied)
+	}
+
+	return result, nil
+}
+
+/*
+sweep removes the attachment files no mail item refers to any more.
+Failures are logged; the files are picked up by the next sweep.
+*/
+func (attachmentStorage *AttachmentStorage) sweep() {
+	attachmentStorage.lock.Lock()
+	defer attachmentStorage.lock.Unlock()
+
+	referenceCounts, err := attachmentStorage.IMailStore.PruneAttachmentReferences()
+	if err != nil {
+		log.Printf("MailSlurper: ERROR - Unable to count attachment references: %s\n", err.Error())
+		return
+	}
+
+	removed, err := attachmentStorage.blobs.Sweep(referenceCounts)
+	if err != nil {
+		log.Printf("MailSlurper: ERROR - Unable to remove unused attachment files: %s\n", err.Error())
+	}
+
+	if removed > 0 {
+		log.Printf("MailSlurper: INFO - Removed %d unused attachment file(s)\n", removed)
+	}
+}
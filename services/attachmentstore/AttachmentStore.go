@@ -0,0 +1,152 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package attachmentstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+/*
+AttachmentStore keeps attachment contents as files in a directory, named
+by the SHA-256 hash of their contents. Identical contents are written
+once. Files are spread over subdirectories named by the first two
+characters of the hash.
+*/
+type AttachmentStore struct {
+	directory string
+}
+
+/*
+NewAttachmentStore creates a new AttachmentStore object, creating the
+directory when it does not exist
+*/
+func NewAttachmentStore(directory string) (*AttachmentStore, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+
+	return &AttachmentStore{
+		directory: directory,
+	}, nil
+}
+
+/*
+Get returns the contents stored under a hash
+*/
+func (store *AttachmentStore) Get(hash string) ([]byte, error) {
+	if !isHash(hash) {
+		return nil, fmt.Errorf("Invalid attachment hash '%s'", hash)
+	}
+
+	return ioutil.ReadFile(store.path(hash))
+}
+
+/*
+Put writes contents to the store, unless they are already there, and
+returns their hash. The file is written under a temporary name and then
+renamed so a partially written file is never read.
+*/
+func (store *AttachmentStore) Put(contents []byte) (string, error) {
+	sum := sha256.Sum256(contents)
+	hash := hex.EncodeToString(sum[:])
+	fileName := store.path(hash)
+
+	if _, err := os.Stat(fileName); err == nil {
+		return hash, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return "", err
+	}
+
+	temporaryFile, err := ioutil.TempFile(filepath.Dir(fileName), hash+".tmp")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = temporaryFile.Write(contents); err != nil {
+		temporaryFile.Close()
+		os.Remove(temporaryFile.Name())
+		return "", err
+	}
+
+	if err = temporaryFile.Close(); err != nil {
+		os.Remove(temporaryFile.Name())
+		return "", err
+	}
+
+	if err = os.Rename(temporaryFile.Name(), fileName); err != nil {
+		os.Remove(temporaryFile.Name())
+		return "", err
+	}
+
+	return hash, nil
+}
+
+/*
+Remove deletes the file stored under a hash. A file that is already
+gone is not an error.
+*/
+func (store *AttachmentStore) Remove(hash string) error {
+	if !isHash(hash) {
+		return fmt.Errorf("Invalid attachment hash '%s'", hash)
+	}
+
+	if err := os.Remove(store.path(hash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+/*
+Sweep removes every stored file that has no references left and returns
+how many were removed. referenceCounts maps a hash to the number of
+attachments using it.
+*/
+func (store *AttachmentStore) Sweep(referenceCounts map[string]int) (int, error) {
+	removed := 0
+
+	err := filepath.Walk(store.directory, func(fileName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || !isHash(info.Name()) || referenceCounts[info.Name()] > 0 {
+			return nil
+		}
+
+		if err = os.Remove(fileName); err != nil {
+			return err
+		}
+
+		removed++
+		return nil
+	})
+
+	return removed, err
+}
+
+func (store *AttachmentStore) path(hash string) string {
+	return filepath.Join(store.directory, hash[:2], hash)
+}
+
+/*
+isHash returns true for a hex encoded SHA-256 hash. Anything else is
+not a name the store gave out.
+*/
+func isHash(value string) bool {
+	if len(value) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
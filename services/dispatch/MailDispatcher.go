@@ -0,0 +1,114 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package dispatch
+
+import (
+	"log"
+
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+	"github.com/mailslurper/libmailslurper/receiver"
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/mailslurper/mailslurper/services/mailparser"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+	"github.com/mailslurper/mailslurper/services/searchindex"
+	"github.com/nu7hatch/gouuid"
+)
+
+/*
+MailDispatcher stores newly received mail along with everything kept
+about it, then hands the mail item to each receiver.
+*/
+type MailDispatcher struct {
+	database  storage.IStorage
+	mailStore mailstore.IMailStore
+	receivers []receiver.IMailItemReceiver
+}
+
+/*
+NewMailDispatcher creates a new MailDispatcher object
+*/
+func NewMailDispatcher(database storage.IStorage, mailStore mailstore.IMailStore, receivers []receiver.IMailItemReceiver) *MailDispatcher {
+	return &MailDispatcher{
+		database:  database,
+		mailStore: mailStore,
+		receivers: receivers,
+	}
+}
+
+/*
+Dispatch stores a mail item, the raw message it was parsed from, its
+headers and metadata, indexes it for search, then notifies the
+receivers. Failing to keep the raw message, headers or metadata, to
+index the mail item, or a receiver failing, is logged but does not fail
+the delivery.
+*/
+func (dispatcher *MailDispatcher) Dispatch(mailItem *mailitem.MailItem, raw []byte, metadata *model.MailMetadata) error {
+	var err error
+
+	if mailItem.ID == "" {
+		var id *uuid.UUID
+
+		if id, err = uuid.NewV4(); err != nil {
+			return err
+		}
+
+		mailItem.ID = id.String()
+	}
+
+	if mailItem.ID, err = dispatcher.database.StoreMail(mailItem); err != nil {
+		return err
+	}
+
+	if raw != nil {
+		if err = dispatcher.mailStore.StoreRawMessage(mailItem.ID, raw); err != nil {
+			log.Printf("MailSlurper: ERROR - Unable to store the raw message for mail item %s: %s\n", mailItem.ID, err.Error())
+		}
+
+		if err = dispatcher.storeHeaders(mailItem.ID, raw); err != nil {
+			log.Printf("MailSlurper: ERROR - Unable to store the headers for mail item %s: %s\n", mailItem.ID, err.Error())
+		}
+	}
+
+	if metadata != nil {
+		metadata.MailItemID = mailItem.ID
+
+		if err = dispatcher.mailStore.StoreMailMetadata(metadata); err != nil {
+			log.Printf("MailSlurper: ERROR - Unable to store metadata for mail item %s: %s\n", mailItem.ID, err.Error())
+		}
+	}
+
+	if err = dispatcher.mailStore.IndexMail(searchindex.NewSearchDocument(mailItem)); err != nil {
+		log.Printf("MailSlurper: ERROR - Unable to index mail item %s: %s\n", mailItem.ID, err.Error())
+	}
+
+	for _, mailReceiver := range dispatcher.receivers {
+		if err = mailReceiver.Receive(mailItem); err != nil {
+			log.Printf("MailSlurper: ERROR - Receiver failed for mail item %s: %s\n", mailItem.ID, err.Error())
+		}
+	}
+
+	return nil
+}
+
+/*
+RecordSession stores the SMTP session record of a dispatched mail item.
+It is kept apart from Dispatch so the record can include the reply that
+accepted the mail. Failures are logged.
+*/
+func (dispatcher *MailDispatcher) RecordSession(session *model.SMTPSessionRecord) {
+	if err := dispatcher.mailStore.StoreSMTPSession(session); err != nil {
+		log.Printf("MailSlurper: ERROR - Unable to store the SMTP session for mail item %s: %s\n", session.MailItemID, err.Error())
+	}
+}
+
+func (dispatcher *MailDispatcher) storeHeaders(mailID string, raw []byte) error {
+	headers, err := mailparser.ParseHeaders(raw)
+	if err != nil {
+		return err
+	}
+
+	return dispatcher.mailStore.StoreMailHeaders(mailID, headers)
+}
@@ -0,0 +1,293 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package faultinjection
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/nu7hatch/gouuid"
+)
+
+const (
+	STAGE_CONNECT string = "connect"
+	STAGE_HELO    string = "helo"
+	STAGE_MAIL    string = "mail"
+	STAGE_RCPT    string = "rcpt"
+	STAGE_DATA    string = "data"
+	STAGE_MESSAGE string = "message"
+
+	ACTION_REPLY      string = "reply"
+	ACTION_DELAY      string = "delay"
+	ACTION_DISCONNECT string = "disconnect"
+
+	// MAX_MATCHES is how many recent rule matches are kept
+	MAX_MATCHES int = 100
+)
+
+/*
+FaultContext is what is known about an SMTP session when rules are
+evaluated. Size is -1 until the message size is known.
+*/
+type FaultContext struct {
+	ClientIP    string
+	MailFrom    string
+	HasMailFrom bool
+	Recipients  []string
+	Size        int
+}
+
+/*
+FaultRuleEngine holds the fault rules applied to SMTP sessions and the
+most recent matches. It is safe for concurrent use; rules can be
+replaced at runtime.
+*/
+type FaultRuleEngine struct {
+	lock    sync.RWMutex
+	rules   []*model.FaultRule
+	matches []*model.FaultRuleMatch
+}
+
+/*
+NewFaultRuleEngine creates a new FaultRuleEngine object with an initial
+set of rules
+*/
+func NewFaultRuleEngine(rules []*model.FaultRule) (*FaultRuleEngine, error) {
+	result := &FaultRuleEngine{
+		rules:   make([]*model.FaultRule, 0),
+		matches: make([]*model.FaultRuleMatch, 0),
+	}
+
+	if err := result.SetRules(rules); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+/*
+GetRules returns a copy of the current rules
+*/
+func (engine *FaultRuleEngine) GetRules() []*model.FaultRule {
+	engine.lock.RLock()
+	defer engine.lock.RUnlock()
+
+	result := make([]*model.FaultRule, len(engine.rules))
+	copy(result, engine.rules)
+	return result
+}
+
+/*
+SetRules validates and replaces every rule. Rules without an ID are
+given one.
+*/
+func (engine *FaultRuleEngine) SetRules(rules []*model.FaultRule) error {
+	for _, rule := range rules {
+		if err := prepareRule(rule); err != nil {
+			return err
+		}
+	}
+
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	engine.rules = rules
+	return nil
+}
+
+/*
+AddRule validates a rule and appends it to the list
+*/
+func (engine *FaultRuleEngine) AddRule(rule *model.FaultRule) error {
+	if err := prepareRule(rule); err != nil {
+		return err
+	}
+
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	engine.rules = append(engine.rules, rule)
+	return nil
+}
+
+/*
+DeleteRule removes a rule by ID. It returns false when no rule has that
+ID.
+*/
+func (engine *FaultRuleEngine) DeleteRule(ruleID string) bool {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	for index, rule := range engine.rules {
+		if rule.ID == ruleID {
+			engine.rules = append(engine.rules[:index:index], engine.rules[index+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+GetMatches returns the most recent rule matches, newest first
+*/
+func (engine *FaultRuleEngine) GetMatches() []*model.FaultRuleMatch {
+	engine.lock.RLock()
+	defer engine.lock.RUnlock()
+
+	result := make([]*model.FaultRuleMatch, 0, len(engine.matches))
+
+	for index := len(engine.matches) - 1; index >= 0; index-- {
+		result = append(result, engine.matches[index])
+	}
+
+	return result
+}
+
+/*
+Evaluate returns the first rule matching the session at the given
+stage, or nil. Matches are logged and remembered.
+*/
+func (engine *FaultRuleEngine) Evaluate(stage string, context *FaultContext) *model.FaultRule {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	for _, rule := range engine.rules {
+		if rule.Stage != stage || !ruleMatches(rule, context) {
+			continue
+		}
+
+		match := &model.FaultRuleMatch{
+			MatchedAt:  time.Now().Format("2006-01-02 15:04:05"),
+			RuleID:     rule.ID,
+			RuleName:   rule.Name,
+			Stage:      stage,
+			Action:     rule.Action,
+			ClientIP:   context.ClientIP,
+			MailFrom:   context.MailFrom,
+			Recipients: context.Recipients,
+			Size:       context.Size,
+		}
+
+		engine.matches = append(engine.matches, match)
+		if len(engine.matches) > MAX_MATCHES {
+			engine.matches = engine.matches[len(engine.matches)-MAX_MATCHES:]
+		}
+
+		log.Printf("MailSlurper: INFO - Fault rule '%s' matched at %s stage for client %s (action: %s)\n", rule.Name, stage, context.ClientIP, rule.Action)
+		return rule
+	}
+
+	return nil
+}
+
+func prepareRule(rule *model.FaultRule) error {
+	switch rule.Stage {
+	case STAGE_CONNECT, STAGE_HELO, STAGE_MAIL, STAGE_RCPT, STAGE_DATA, STAGE_MESSAGE:
+	default:
+		return fmt.Errorf("Fault rule '%s' has an unknown stage '%s'", rule.Name, rule.Stage)
+	}
+
+	switch rule.Action {
+	case ACTION_REPLY:
+		if rule.ReplyCode < 200 || rule.ReplyCode > 599 {
+			return fmt.Errorf("Fault rule '%s' needs a reply code between 200 and 599", rule.Name)
+		}
+
+		if rule.ReplyText == "" {
+			rule.ReplyText = "Fault injected by MailSlurper"
+		}
+
+	case ACTION_DELAY:
+		if rule.DelaySeconds <= 0 {
+			return fmt.Errorf("Fault rule '%s' needs a positive delay", rule.Name)
+		}
+
+	case ACTION_DISCONNECT:
+
+	default:
+		return fmt.Errorf("Fault rule '%s' has an unknown action '%s'", rule.Name, rule.Action)
+	}
+
+	if rule.ClientIP != "" && net.ParseIP(rule.ClientIP) == nil {
+		if _, _, err := net.ParseCIDR(rule.ClientIP); err != nil {
+			return fmt.Errorf("Fault rule '%s' has an invalid client IP '%s'", rule.Name, rule.ClientIP)
+		}
+	}
+
+	if rule.ID == "" {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+
+		rule.ID = id.String()
+	}
+
+	return nil
+}
+
+/*
+ruleMatches checks each criteria the rule sets. Criteria that depend on
+information the session does not have yet never match.
+*/
+func ruleMatches(rule *model.FaultRule, context *FaultContext) bool {
+	if rule.ClientIP != "" && !ipMatches(rule.ClientIP, context.ClientIP) {
+		return false
+	}
+
+	if rule.MailFrom != "" && (!context.HasMailFrom || !wildcardMatches(rule.MailFrom, context.MailFrom)) {
+		return false
+	}
+
+	if rule.RcptTo != "" {
+		matched := false
+
+		for _, recipient := range context.Recipients {
+			if wildcardMatches(rule.RcptTo, recipient) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	if rule.MinSize > 0 && (context.Size < 0 || context.Size < rule.MinSize) {
+		return false
+	}
+
+	if rule.MaxSize > 0 && (context.Size < 0 || context.Size > rule.MaxSize) {
+		return false
+	}
+
+	return true
+}
+
+func ipMatches(pattern, clientIP string) bool {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+
+	if _, network, err := net.ParseCIDR(pattern); err == nil {
+		return network.Contains(ip)
+	}
+
+	return ip.Equal(net.ParseIP(pattern))
+}
+
+func wildcardMatches(pattern, value string) bool {
+	matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(value))
+	return err == nil && matched
+}
@@ -0,0 +1,326 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mailslurper/mailslurper/services/messagebuilder"
+)
+
+var sectionPattern = regexp.MustCompile(`(?i)^(BODY|BODY\.PEEK)\[([^\]]*)\](?:<(\d+)(?:\.(\d+))?>)?$`)
+var sectionPartPattern = regexp.MustCompile(`^((?:\d+\.)*\d+)?\.?(.*)$`)
+
+/*
+messageContext lazily loads and parses the contents of one message
+while a FETCH or SEARCH is running.
+*/
+type messageContext struct {
+	session  *imapSession
+	sequence uint32
+	message  *imapMessage
+
+	contents []byte
+	root     *mimePart
+}
+
+func (context *messageContext) load() error {
+	if context.root != nil {
+		return nil
+	}
+
+	contents, err := context.session.server.getMessageContents(context.message.mailItem.ID)
+	if err != nil {
+		return err
+	}
+
+	context.contents = contents
+	context.root = parseMIMEPart(contents, "text/plain")
+	return nil
+}
+
+func (context *messageContext) internalDate() time.Time {
+	parsed, err := time.ParseInLocation(messagebuilder.DATE_FORMAT, context.message.mailItem.DateSent, time.Local)
+	if err != nil {
+		return time.Now()
+	}
+
+	return parsed
+}
+
+/*
+selectMessages returns the messages matched by a sequence set, which
+holds UIDs when useUID is true.
+*/
+func (session *imapSession) selectMessages(set string, useUID bool) ([]*messageContext, error) {
+	max := uint32(len(session.messages))
+
+	if useUID && len(session.messages) > 0 {
+		max = session.messages[len(session.messages)-1].uid
+	}
+
+	sequences, err := parseSequenceSet(set, max)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*messageContext, 0)
+
+	for index, message := range session.messages {
+		number := uint32(index + 1)
+		if useUID {
+			number = message.uid
+		}
+
+		if sequences.contains(number) {
+			result = append(result, &messageContext{session: session, sequence: uint32(index + 1), message: message})
+		}
+	}
+
+	return result, nil
+}
+
+func (session *imapSession) fetch(tag string, arguments imapList, useUID bool) {
+	if len(arguments) != 2 {
+		session.tagged(tag, "BAD Usage: FETCH sequence-set items")
+		return
+	}
+
+	set, _ := arguments[0].(string)
+
+	messages, err := session.selectMessages(set, useUID)
+	if err != nil {
+		session.tagged(tag, "BAD "+err.Error())
+		return
+	}
+
+	items := expandFetchItems(arguments[1])
+
+	if useUID && !containsItem(items, "UID") {
+		items = append([]string{"UID"}, items...)
+	}
+
+	for _, context := range messages {
+		values := make([]string, 0, len(items))
+
+		for _, item := range items {
+			value, err := context.fetchItem(item)
+			if err != nil {
+				log.Printf("MailSlurper: ERROR - IMAP could not fetch %s for mail item %s: %s\n", item, context.message.mailItem.ID, err.Error())
+				session.tagged(tag, "NO "+err.Error())
+				return
+			}
+
+			values = append(values, value)
+		}
+
+		session.untagged(fmt.Sprintf("%d FETCH (%s)", context.sequence, strings.Join(values, " ")))
+	}
+
+	session.tagged(tag, "OK FETCH completed")
+}
+
+/*
+expandFetchItems turns the FETCH data item argument, which may be a
+single item, a macro or a list, into a list of item names.
+*/
+func expandFetchItems(argument interface{}) []string {
+	result := make([]string, 0)
+
+	switch value := argument.(type) {
+	case string:
+		switch strings.ToUpper(value) {
+		case "ALL":
+			return []string{"FLAGS", "INTERNALDATE", "RFC822.SIZE", "ENVELOPE"}
+		case "FAST":
+			return []string{"FLAGS", "INTERNALDATE", "RFC822.SIZE"}
+		case "FULL":
+			return []string{"FLAGS", "INTERNALDATE", "RFC822.SIZE", "ENVELOPE", "BODY"}
+		}
+
+		result = append(result, value)
+
+	case imapList:
+		for _, item := range value {
+			if name, ok := item.(string); ok {
+				result = append(result, name)
+			}
+		}
+	}
+
+	return result
+}
+
+func containsItem(items []string, name string) bool {
+	for _, item := range items {
+		if strings.EqualFold(item, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (context *messageContext) fetchItem(item string) (string, error) {
+	name := strings.ToUpper(item)
+
+	switch name {
+	case "UID":
+		return fmt.Sprintf("UID %d", context.message.uid), nil
+
+	case "FLAGS":
+		return "FLAGS ()", nil
+
+	case "INTERNALDATE":
+		return "INTERNALDATE " + quote(context.internalDate().Format("02-Jan-2006 15:04:05 -0700")), nil
+	}
+
+	if err := context.load(); err != nil {
+		return "", err
+	}
+
+	switch name {
+	case "RFC822.SIZE":
+		return fmt.Sprintf("RFC822.SIZE %d", len(context.contents)), nil
+
+	case "ENVELOPE":
+		return "ENVELOPE " + context.root.envelope(), nil
+
+	case "BODYSTRUCTURE":
+		return "BODYSTRUCTURE " + context.root.bodyStructure(true), nil
+
+	case "BODY":
+		return "BODY " + context.root.bodyStructure(false), nil
+
+	case "RFC822":
+		return "RFC822 " + literal(context.contents), nil
+
+	case "RFC822.HEADER":
+		return "RFC822.HEADER " + literal(context.root.rawHeader), nil
+
+	case "RFC822.TEXT":
+		return "RFC822.TEXT " + literal(context.root.body), nil
+	}
+
+	match := sectionPattern.FindStringSubmatch(item)
+	if match == nil {
+		return "", fmt.Errorf("Unknown fetch item %s", item)
+	}
+
+	section := strings.ToUpper(strings.TrimSpace(match[2]))
+
+	contents, err := context.resolveSection(section)
+	if err != nil {
+		return "", err
+	}
+
+	label := "BODY[" + section + "]"
+
+	if match[3] != "" {
+		origin, _ := strconv.Atoi(match[3])
+		length := len(contents)
+
+		if match[4] != "" {
+			length, _ = strconv.Atoi(match[4])
+		}
+
+		if origin > len(contents) {
+			origin = len(contents)
+		}
+
+		if origin+length > len(contents) {
+			length = len(contents) - origin
+		}
+
+		contents = contents[origin : origin+length]
+		label += "<" + match[3] + ">"
+	}
+
+	return label + " " + literal(contents), nil
+}
+
+/*
+resolveSection returns the bytes for a BODY[section] specification such
+as "", "HEADER", "TEXT", "1.2", "1.MIME" or "HEADER.FIELDS (FROM TO)".
+*/
+func (context *messageContext) resolveSection(section string) ([]byte, error) {
+	if section == "" {
+		return context.contents, nil
+	}
+
+	match := sectionPartPattern.FindStringSubmatch(section)
+	path := match[1]
+	specifier := strings.TrimSpace(match[2])
+
+	current := context.root
+
+	if path != "" {
+		for index, number := range strings.Split(path, ".") {
+			partNumber, _ := strconv.Atoi(number)
+
+			if index > 0 && current.message != nil {
+				current = current.message
+			}
+
+			switch {
+			case current.mediaType == "multipart":
+				if partNumber < 1 || partNumber > len(current.children) {
+					return nil, fmt.Errorf("No such section %s", section)
+				}
+
+				current = current.children[partNumber-1]
+
+			case partNumber != 1:
+				return nil, fmt.Errorf("No such section %s", section)
+			}
+		}
+
+		if specifier == "" {
+			return current.body, nil
+		}
+
+		if specifier == "MIME" {
+			return current.rawHeader, nil
+		}
+
+		if current.message == nil {
+			return nil, fmt.Errorf("Section %s is not a message", section)
+		}
+
+		current = current.message
+	}
+
+	switch {
+	case specifier == "HEADER":
+		return current.rawHeader, nil
+
+	case specifier == "TEXT":
+		return current.body, nil
+
+	case strings.HasPrefix(specifier, "HEADER.FIELDS.NOT"):
+		return current.headerFields(fieldNames(specifier), true), nil
+
+	case strings.HasPrefix(specifier, "HEADER.FIELDS"):
+		return current.headerFields(fieldNames(specifier), false), nil
+	}
+
+	return nil, fmt.Errorf("Unknown section %s", section)
+}
+
+func fieldNames(specifier string) []string {
+	start := strings.Index(specifier, "(")
+	end := strings.LastIndex(specifier, ")")
+
+	if start == -1 || end < start {
+		return []string{}
+	}
+
+	return strings.Fields(specifier[start+1 : end])
+}
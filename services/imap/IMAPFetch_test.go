@@ -0,0 +1,100 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+)
+
+const testMessage = "From: Sender <sender@example.com>\r\n" +
+	"To: user@example.com\r\n" +
+	"Subject: Hello\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"XX\"\r\n" +
+	"\r\n" +
+	"--XX\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Hello there\r\n" +
+	"--XX\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>Hello</p>\r\n" +
+	"--XX--\r\n"
+
+func newTestMessageContext() *messageContext {
+	contents := []byte(testMessage)
+
+	return &messageContext{
+		sequence: 1,
+		message: &imapMessage{
+			uid:      42,
+			mailItem: mailitem.MailItem{ID: "mail-1", DateSent: "2016-03-04 05:06:07"},
+		},
+		contents: contents,
+		root:     parseMIMEPart(contents, "text/plain"),
+	}
+}
+
+func TestExpandFetchItems(t *testing.T) {
+	tests := []struct {
+		name     string
+		argument interface{}
+		expected []string
+	}{
+		{name: "single item", argument: "UID", expected: []string{"UID"}},
+		{name: "ALL macro", argument: "ALL", expected: []string{"FLAGS", "INTERNALDATE", "RFC822.SIZE", "ENVELOPE"}},
+		{name: "FAST macro", argument: "fast", expected: []string{"FLAGS", "INTERNALDATE", "RFC822.SIZE"}},
+		{name: "FULL macro", argument: "Full", expected: []string{"FLAGS", "INTERNALDATE", "RFC822.SIZE", "ENVELOPE", "BODY"}},
+		{name: "list", argument: imapList{"FLAGS", "BODY.PEEK[HEADER]"}, expected: []string{"FLAGS", "BODY.PEEK[HEADER]"}},
+		{name: "nested lists are skipped", argument: imapList{"UID", imapList{"FLAGS"}}, expected: []string{"UID"}},
+	}
+
+	for _, test := range tests {
+		if actual := expandFetchItems(test.argument); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestFetchItem(t *testing.T) {
+	tests := []struct {
+		item     string
+		expected string
+	}{
+		{item: "UID", expected: "UID 42"},
+		{item: "flags", expected: "FLAGS ()"},
+		{item: "RFC822.SIZE", expected: "RFC822.SIZE 242"},
+		{item: "BODY[]<0.4>", expected: "BODY[]<0> {4}\r\nFrom"},
+		{item: "BODY[]<236>", expected: "BODY[]<236> {6}\r\nXX--\r\n"},
+		{item: "BODY[]<300.10>", expected: "BODY[]<300> {0}\r\n"},
+		{item: "BODY.PEEK[HEADER.FIELDS (SUBJECT)]", expected: "BODY[HEADER.FIELDS (SUBJECT)] {18}\r\nSubject: Hello\r\n\r\n"},
+		{item: "BODY[1]", expected: "BODY[1] {11}\r\nHello there"},
+		{item: "BODY[2.MIME]", expected: "BODY[2.MIME] {27}\r\nContent-Type: text/html\r\n\r\n"},
+	}
+
+	for _, test := range tests {
+		actual, err := newTestMessageContext().fetchItem(test.item)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.item, err.Error())
+			continue
+		}
+
+		if actual != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.item, test.expected, actual)
+		}
+	}
+}
+
+func TestFetchItemErrors(t *testing.T) {
+	for _, item := range []string{"BODY[3]", "BODY[1.HEADER]", "BODY[BOGUS]", "X-UNKNOWN"} {
+		if actual, err := newTestMessageContext().fetchItem(item); err == nil {
+			t.Errorf("%s: expected an error, got %q", item, actual)
+		}
+	}
+}
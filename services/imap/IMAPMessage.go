@@ -0,0 +1,376 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+/*
+mimePart is one node of a parsed MIME message. Header and body keep
+the exact bytes of the message so section fetches return what the
+client would see in the original.
+*/
+type mimePart struct {
+	rawHeader []byte
+	body      []byte
+	header    textproto.MIMEHeader
+
+	mediaType string
+	subType   string
+	params    map[string]string
+
+	children []*mimePart
+	message  *mimePart
+}
+
+/*
+parseMIMEPart splits raw bytes into header and body, then recursively
+parses multipart bodies and encapsulated messages.
+*/
+func parseMIMEPart(raw []byte, defaultMediaType string) *mimePart {
+	result := &mimePart{}
+
+	headerEnd := bytes.Index(raw, []byte("\r\n\r\n"))
+
+	switch {
+	case bytes.HasPrefix(raw, []byte("\r\n")):
+		result.rawHeader = raw[:2]
+		result.body = raw[2:]
+	case headerEnd > -1:
+		result.rawHeader = raw[:headerEnd+4]
+		result.body = raw[headerEnd+4:]
+	default:
+		result.rawHeader = raw
+		result.body = []byte{}
+	}
+
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(result.rawHeader)))
+	result.header, _ = reader.ReadMIMEHeader()
+
+	contentType := result.header.Get("Content-Type")
+	if contentType == "" {
+		contentType = defaultMediaType
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+		params = map[string]string{}
+	}
+
+	typeParts := strings.SplitN(mediaType, "/", 2)
+	result.mediaType = typeParts[0]
+	result.subType = ""
+
+	if len(typeParts) > 1 {
+		result.subType = typeParts[1]
+	}
+
+	result.params = params
+
+	if result.mediaType == "multipart" && params["boundary"] != "" {
+		childDefault := "text/plain"
+		if result.subType == "digest" {
+			childDefault = "message/rfc822"
+		}
+
+		for _, childBytes := range splitMultipart(result.body, params["boundary"]) {
+			result.children = append(result.children, parseMIMEPart(childBytes, childDefault))
+		}
+	}
+
+	if result.mediaType == "message" && result.subType == "rfc822" {
+		result.message = parseMIMEPart(result.body, "text/plain")
+	}
+
+	return result
+}
+
+/*
+splitMultipart returns the raw bytes of each body part between the
+boundary delimiters. The CRLF before each delimiter belongs to the
+delimiter, not the part.
+*/
+func splitMultipart(body []byte, boundary string) [][]byte {
+	result := make([][]byte, 0)
+	delimiter := []byte("--" + boundary)
+
+	var partStart = -1
+	position := 0
+
+	for position <= len(body) {
+		lineEnd := bytes.Index(body[position:], []byte("\r\n"))
+		var line []byte
+
+		if lineEnd == -1 {
+			line = body[position:]
+			lineEnd = len(body)
+		} else {
+			line = body[position : position+lineEnd]
+			lineEnd = position + lineEnd
+		}
+
+		trimmed := bytes.TrimRight(line, " \t")
+
+		if bytes.HasPrefix(trimmed, delimiter) {
+			rest := trimmed[len(delimiter):]
+			isClose := bytes.Equal(rest, []byte("--"))
+
+			if len(rest) == 0 || isClose {
+				if partStart > -1 {
+					partEnd := position - 2
+					if partEnd < partStart {
+						partEnd = partStart
+					}
+
+					result = append(result, body[partStart:partEnd])
+				}
+
+				if isClose {
+					return result
+				}
+
+				partStart = lineEnd + 2
+				if partStart > len(body) {
+					partStart = len(body)
+				}
+			}
+		}
+
+		if lineEnd >= len(body) {
+			break
+		}
+
+		position = lineEnd + 2
+	}
+
+	if partStart > -1 && partStart < len(body) {
+		result = append(result, body[partStart:])
+	}
+
+	return result
+}
+
+/*
+headerFields returns the raw header lines whose names are (or, when
+exclude is true, are not) in the list of field names. Folded lines
+stay with their field.
+*/
+func (part *mimePart) headerFields(names []string, exclude bool) []byte {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[strings.ToLower(name)] = true
+	}
+
+	result := &bytes.Buffer{}
+	include := false
+
+	for _, line := range bytes.SplitAfter(part.rawHeader, []byte("\r\n")) {
+		if len(line) == 0 || bytes.Equal(line, []byte("\r\n")) {
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			name := line
+			if colon := bytes.IndexByte(line, ':'); colon > -1 {
+				name = line[:colon]
+			}
+
+			_, found := wanted[strings.ToLower(strings.TrimSpace(string(name)))]
+			include = found != exclude
+		}
+
+		if include {
+			result.Write(line)
+		}
+	}
+
+	result.WriteString("\r\n")
+	return result.Bytes()
+}
+
+func (part *mimePart) lineCount() int {
+	return bytes.Count(part.body, []byte("\r\n"))
+}
+
+/*
+envelope returns the IMAP ENVELOPE structure for a message part
+*/
+func (part *mimePart) envelope() string {
+	from := part.header.Get("From")
+
+	sender := part.header.Get("Sender")
+	if sender == "" {
+		sender = from
+	}
+
+	replyTo := part.header.Get("Reply-To")
+	if replyTo == "" {
+		replyTo = from
+	}
+
+	fields := []string{
+		nstring(part.header.Get("Date")),
+		nstring(part.header.Get("Subject")),
+		addressList(from),
+		addressList(sender),
+		addressList(replyTo),
+		addressList(part.header.Get("To")),
+		addressList(part.header.Get("Cc")),
+		addressList(part.header.Get("Bcc")),
+		nstring(part.header.Get("In-Reply-To")),
+		nstring(part.header.Get("Message-Id")),
+	}
+
+	return "(" + strings.Join(fields, " ") + ")"
+}
+
+/*
+bodyStructure returns the BODYSTRUCTURE (extensible is true) or BODY
+representation of this part.
+*/
+func (part *mimePart) bodyStructure(extensible bool) string {
+	result := &bytes.Buffer{}
+	result.WriteString("(")
+
+	if part.mediaType == "multipart" {
+		for _, child := range part.children {
+			result.WriteString(child.bodyStructure(extensible))
+		}
+
+		if len(part.children) == 0 {
+			result.WriteString(`("TEXT" "PLAIN" NIL NIL NIL "7BIT" 0 0)`)
+		}
+
+		result.WriteString(" " + quote(strings.ToUpper(part.subType)))
+
+		if extensible {
+			result.WriteString(" " + parameterList(part.params))
+			result.WriteString(" " + part.disposition())
+			result.WriteString(" NIL NIL")
+		}
+
+		result.WriteString(")")
+		return result.String()
+	}
+
+	encoding := strings.ToUpper(strings.TrimSpace(part.header.Get("Content-Transfer-Encoding")))
+	if encoding == "" {
+		encoding = "7BIT"
+	}
+
+	fields := []string{
+		quote(strings.ToUpper(part.mediaType)),
+		quote(strings.ToUpper(part.subType)),
+		parameterList(part.params),
+		nstring(part.header.Get("Content-Id")),
+		nstring(part.header.Get("Content-Description")),
+		quote(encoding),
+		itoa(len(part.body)),
+	}
+
+	if part.mediaType == "message" && part.subType == "rfc822" && part.message != nil {
+		fields = append(fields, part.message.envelope(), part.message.bodyStructure(extensible), itoa(part.lineCount()))
+	}
+
+	if part.mediaType == "text" {
+		fields = append(fields, itoa(part.lineCount()))
+	}
+
+	if extensible {
+		fields = append(fields, nstring(part.header.Get("Content-Md5")), part.disposition(), "NIL", "NIL")
+	}
+
+	result.WriteString(strings.Join(fields, " "))
+	result.WriteString(")")
+
+	return result.String()
+}
+
+func (part *mimePart) disposition() string {
+	contentDisposition := part.header.Get("Content-Disposition")
+	if contentDisposition == "" {
+		return "NIL"
+	}
+
+	disposition, params, err := mime.ParseMediaType(contentDisposition)
+	if err != nil {
+		return "NIL"
+	}
+
+	return "(" + quote(strings.ToUpper(disposition)) + " " + parameterList(params) + ")"
+}
+
+func parameterList(params map[string]string) string {
+	if len(params) == 0 {
+		return "NIL"
+	}
+
+	names := sortedKeys(params)
+	values := make([]string, 0, len(params)*2)
+
+	for _, name := range names {
+		values = append(values, quote(strings.ToUpper(name)), quote(params[name]))
+	}
+
+	return "(" + strings.Join(values, " ") + ")"
+}
+
+/*
+addressList converts an address header into an IMAP address list,
+((name adl mailbox host) ...), or NIL when empty.
+*/
+func addressList(value string) string {
+	if strings.TrimSpace(value) == "" {
+		return "NIL"
+	}
+
+	var addresses []*mail.Address
+
+	parsed, err := mail.ParseAddressList(value)
+	if err == nil {
+		addresses = parsed
+	} else {
+		for _, piece := range strings.Split(value, ",") {
+			piece = strings.Trim(strings.TrimSpace(piece), "<>")
+			if piece != "" {
+				addresses = append(addresses, &mail.Address{Address: piece})
+			}
+		}
+	}
+
+	if len(addresses) == 0 {
+		return "NIL"
+	}
+
+	result := &bytes.Buffer{}
+	result.WriteString("(")
+
+	for _, address := range addresses {
+		mailbox := address.Address
+		host := ""
+
+		if at := strings.LastIndex(address.Address, "@"); at > -1 {
+			mailbox = address.Address[:at]
+			host = address.Address[at+1:]
+		}
+
+		name := address.Name
+		if name != "" {
+			name = mime.QEncoding.Encode("utf-8", name)
+		}
+
+		result.WriteString("(" + nstring(name) + " NIL " + nstring(mailbox) + " " + nstring(host) + ")")
+	}
+
+	result.WriteString(")")
+	return result.String()
+}
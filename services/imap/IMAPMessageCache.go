@@ -0,0 +1,81 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"container/list"
+	"sync"
+)
+
+const (
+	// MESSAGE_CACHE_MAX_BYTES is how much message content is kept for clients fetching the same message again
+	MESSAGE_CACHE_MAX_BYTES int = 16 * 1024 * 1024
+)
+
+/*
+messageCache keeps the most recently fetched messages, up to maxBytes
+of content. The least recently used messages are dropped first, and a
+message larger than maxBytes is never kept. It is safe for concurrent
+use.
+*/
+type messageCache struct {
+	lock     sync.Mutex
+	maxBytes int
+	size     int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type messageCacheEntry struct {
+	mailID   string
+	contents []byte
+}
+
+func newMessageCache(maxBytes int) *messageCache {
+	return &messageCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (cache *messageCache) get(mailID string) ([]byte, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	element, ok := cache.entries[mailID]
+	if !ok {
+		return nil, false
+	}
+
+	cache.order.MoveToFront(element)
+	return element.Value.(*messageCacheEntry).contents, true
+}
+
+func (cache *messageCache) put(mailID string, contents []byte) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if len(contents) > cache.maxBytes {
+		return
+	}
+
+	if element, ok := cache.entries[mailID]; ok {
+		cache.remove(element)
+	}
+
+	cache.entries[mailID] = cache.order.PushFront(&messageCacheEntry{mailID: mailID, contents: contents})
+	cache.size += len(contents)
+
+	for cache.size > cache.maxBytes {
+		cache.remove(cache.order.Back())
+	}
+}
+
+func (cache *messageCache) remove(element *list.Element) {
+	entry := cache.order.Remove(element).(*messageCacheEntry)
+	delete(cache.entries, entry.mailID)
+	cache.size -= len(entry.contents)
+}
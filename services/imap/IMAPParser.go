@@ -0,0 +1,262 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+imapList is a parenthesized list in an IMAP command. Its items are
+either strings (atoms, quoted strings and literals) or nested lists.
+*/
+type imapList []interface{}
+
+const (
+	tokenAtom int = iota
+	tokenString
+	tokenOpen
+	tokenClose
+)
+
+type token struct {
+	kind  int
+	value string
+}
+
+/*
+tokenizeLine splits one line of an IMAP command into tokens. Atoms may
+contain a bracketed section such as BODY[HEADER.FIELDS (FROM TO)],
+which is kept as a single token.
+*/
+func tokenizeLine(line string) ([]token, error) {
+	result := make([]token, 0)
+	position := 0
+
+	for position < len(line) {
+		character := line[position]
+
+		switch {
+		case character == ' ':
+			position++
+
+		case character == '(':
+			result = append(result, token{kind: tokenOpen})
+			position++
+
+		case character == ')':
+			result = append(result, token{kind: tokenClose})
+			position++
+
+		case character == '"':
+			value := make([]byte, 0)
+			position++
+
+			for {
+				if position >= len(line) {
+					return nil, fmt.Errorf("Unterminated quoted string")
+				}
+
+				if line[position] == '\\' && position+1 < len(line) {
+					value = append(value, line[position+1])
+					position += 2
+					continue
+				}
+
+				if line[position] == '"' {
+					position++
+					break
+				}
+
+				value = append(value, line[position])
+				position++
+			}
+
+			result = append(result, token{kind: tokenString, value: string(value)})
+
+		default:
+			start := position
+
+			for position < len(line) && line[position] != ' ' && line[position] != '(' && line[position] != ')' {
+				if line[position] == '[' {
+					closing := strings.IndexByte(line[position:], ']')
+					if closing == -1 {
+						return nil, fmt.Errorf("Unterminated section")
+					}
+
+					position += closing
+				}
+
+				position++
+			}
+
+			result = append(result, token{kind: tokenAtom, value: line[start:position]})
+		}
+	}
+
+	return result, nil
+}
+
+/*
+buildList nests a flat token stream into an imapList
+*/
+func buildList(tokens []token) (imapList, error) {
+	stack := []imapList{{}}
+
+	for _, current := range tokens {
+		switch current.kind {
+		case tokenOpen:
+			stack = append(stack, imapList{})
+
+		case tokenClose:
+			if len(stack) < 2 {
+				return nil, fmt.Errorf("Unbalanced parentheses")
+			}
+
+			closed := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			stack[len(stack)-1] = append(stack[len(stack)-1], closed)
+
+		default:
+			stack[len(stack)-1] = append(stack[len(stack)-1], current.value)
+		}
+	}
+
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("Unbalanced parentheses")
+	}
+
+	return stack[0], nil
+}
+
+/*
+sequenceSet is a parsed IMAP sequence set such as 1:4,7,9:*
+*/
+type sequenceSet []sequenceRange
+
+type sequenceRange struct {
+	start uint32
+	end   uint32
+}
+
+/*
+parseSequenceSet parses a sequence set. An asterisk stands for the
+largest number in use, which is passed as max.
+*/
+func parseSequenceSet(value string, max uint32) (sequenceSet, error) {
+	result := make(sequenceSet, 0)
+
+	parseNumber := func(number string) (uint32, error) {
+		if number == "*" {
+			return max, nil
+		}
+
+		parsed, err := strconv.ParseUint(number, 10, 32)
+		if err != nil || parsed == 0 {
+			return 0, fmt.Errorf("Invalid sequence number '%s'", number)
+		}
+
+		return uint32(parsed), nil
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		bounds := strings.SplitN(item, ":", 2)
+
+		start, err := parseNumber(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+
+		end := start
+
+		if len(bounds) == 2 {
+			if end, err = parseNumber(bounds[1]); err != nil {
+				return nil, err
+			}
+		}
+
+		if start > end {
+			start, end = end, start
+		}
+
+		result = append(result, sequenceRange{start: start, end: end})
+	}
+
+	return result, nil
+}
+
+func (set sequenceSet) contains(number uint32) bool {
+	for _, item := range set {
+		if number >= item.start && number <= item.end {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isSequenceSet(value string) bool {
+	if value == "" {
+		return false
+	}
+
+	for _, character := range value {
+		if !strings.ContainsRune("0123456789:,*", character) {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+quote returns a string as an IMAP quoted string, or as a literal when
+it contains characters that cannot be quoted.
+*/
+func quote(value string) string {
+	for index := 0; index < len(value); index++ {
+		if value[index] == '\r' || value[index] == '\n' || value[index] > 127 {
+			return literal([]byte(value))
+		}
+	}
+
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	value = strings.Replace(value, "\"", "\\\"", -1)
+
+	return "\"" + value + "\""
+}
+
+/*
+nstring is quote, except an empty value becomes NIL
+*/
+func nstring(value string) string {
+	if value == "" {
+		return "NIL"
+	}
+
+	return quote(value)
+}
+
+func literal(value []byte) string {
+	return fmt.Sprintf("{%d}\r\n%s", len(value), value)
+}
+
+func itoa(value int) string {
+	return strconv.Itoa(value)
+}
+
+func sortedKeys(values map[string]string) []string {
+	result := make([]string, 0, len(values))
+
+	for key := range values {
+		result = append(result, key)
+	}
+
+	sort.Strings(result)
+	return result
+}
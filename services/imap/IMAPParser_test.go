@@ -0,0 +1,261 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenizeLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected []token
+		hasError bool
+	}{
+		{
+			name: "atoms",
+			line: "a1 SELECT INBOX",
+			expected: []token{
+				{kind: tokenAtom, value: "a1"},
+				{kind: tokenAtom, value: "SELECT"},
+				{kind: tokenAtom, value: "INBOX"},
+			},
+		},
+		{
+			name: "quoted strings with escapes",
+			line: `a2 LOGIN "user name" "pa\"ss\\word"`,
+			expected: []token{
+				{kind: tokenAtom, value: "a2"},
+				{kind: tokenAtom, value: "LOGIN"},
+				{kind: tokenString, value: "user name"},
+				{kind: tokenString, value: `pa"ss\word`},
+			},
+		},
+		{
+			name: "empty quoted string",
+			line: `a3 LOGIN "" ""`,
+			expected: []token{
+				{kind: tokenAtom, value: "a3"},
+				{kind: tokenAtom, value: "LOGIN"},
+				{kind: tokenString, value: ""},
+				{kind: tokenString, value: ""},
+			},
+		},
+		{
+			name: "parenthesized list",
+			line: "a4 FETCH 1:* (FLAGS UID)",
+			expected: []token{
+				{kind: tokenAtom, value: "a4"},
+				{kind: tokenAtom, value: "FETCH"},
+				{kind: tokenAtom, value: "1:*"},
+				{kind: tokenOpen},
+				{kind: tokenAtom, value: "FLAGS"},
+				{kind: tokenAtom, value: "UID"},
+				{kind: tokenClose},
+			},
+		},
+		{
+			name: "section with a field list is one token",
+			line: "a5 FETCH 1 (BODY.PEEK[HEADER.FIELDS (FROM TO)]<0.100>)",
+			expected: []token{
+				{kind: tokenAtom, value: "a5"},
+				{kind: tokenAtom, value: "FETCH"},
+				{kind: tokenAtom, value: "1"},
+				{kind: tokenOpen},
+				{kind: tokenAtom, value: "BODY.PEEK[HEADER.FIELDS (FROM TO)]<0.100>"},
+				{kind: tokenClose},
+			},
+		},
+		{
+			name:     "unterminated quoted string",
+			line:     `a6 LOGIN "user`,
+			hasError: true,
+		},
+		{
+			name:     "unterminated section",
+			line:     "a7 FETCH 1 BODY[HEADER",
+			hasError: true,
+		},
+	}
+
+	for _, test := range tests {
+		actual, err := tokenizeLine(test.line)
+
+		if test.hasError {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", test.name, actual)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+			continue
+		}
+
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestBuildList(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected imapList
+		hasError bool
+	}{
+		{
+			name:     "flat",
+			line:     "a1 NOOP",
+			expected: imapList{"a1", "NOOP"},
+		},
+		{
+			name:     "nested",
+			line:     "a2 SEARCH OR (FROM a) (NOT (TO b))",
+			expected: imapList{"a2", "SEARCH", "OR", imapList{"FROM", "a"}, imapList{"NOT", imapList{"TO", "b"}}},
+		},
+		{
+			name:     "empty list",
+			line:     "a3 FETCH 1 ()",
+			expected: imapList{"a3", "FETCH", "1", imapList{}},
+		},
+		{
+			name:     "unclosed list",
+			line:     "a4 FETCH 1 (FLAGS",
+			hasError: true,
+		},
+		{
+			name:     "unopened list",
+			line:     "a5 FETCH 1 FLAGS)",
+			hasError: true,
+		},
+	}
+
+	for _, test := range tests {
+		tokens, err := tokenizeLine(test.line)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+			continue
+		}
+
+		actual, err := buildList(tokens)
+
+		if test.hasError {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", test.name, actual)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+			continue
+		}
+
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %#v, got %#v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestParseSequenceSet(t *testing.T) {
+	tests := []struct {
+		value    string
+		max      uint32
+		expected sequenceSet
+		hasError bool
+	}{
+		{value: "1", max: 10, expected: sequenceSet{{start: 1, end: 1}}},
+		{value: "2:4", max: 10, expected: sequenceSet{{start: 2, end: 4}}},
+		{value: "1:4,7,9:*", max: 10, expected: sequenceSet{{start: 1, end: 4}, {start: 7, end: 7}, {start: 9, end: 10}}},
+		{value: "*", max: 5, expected: sequenceSet{{start: 5, end: 5}}},
+		{value: "*:3", max: 5, expected: sequenceSet{{start: 3, end: 5}}},
+		{value: "4:2", max: 10, expected: sequenceSet{{start: 2, end: 4}}},
+		{value: "0", max: 10, hasError: true},
+		{value: "1:0", max: 10, hasError: true},
+		{value: "a", max: 10, hasError: true},
+		{value: "1,", max: 10, hasError: true},
+		{value: "1:", max: 10, hasError: true},
+		{value: "99999999999", max: 10, hasError: true},
+	}
+
+	for _, test := range tests {
+		actual, err := parseSequenceSet(test.value, test.max)
+
+		if test.hasError {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", test.value, actual)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.value, err.Error())
+			continue
+		}
+
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.value, test.expected, actual)
+		}
+	}
+}
+
+func TestSequenceSetContains(t *testing.T) {
+	set, err := parseSequenceSet("1:3,7,9:*", 12)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	for number, expected := range map[uint32]bool{1: true, 3: true, 4: false, 7: true, 8: false, 9: true, 12: true, 13: false} {
+		if actual := set.contains(number); actual != expected {
+			t.Errorf("%d: expected %t, got %t", number, expected, actual)
+		}
+	}
+}
+
+func TestIsSequenceSet(t *testing.T) {
+	tests := map[string]bool{
+		"1":         true,
+		"1:*":       true,
+		"1,3:5,9:*": true,
+		"":          false,
+		"ALL":       false,
+		"1 2":       false,
+	}
+
+	for value, expected := range tests {
+		if actual := isSequenceSet(value); actual != expected {
+			t.Errorf("%q: expected %t, got %t", value, expected, actual)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := map[string]string{
+		"plain":        `"plain"`,
+		`say "hi"`:     `"say \"hi\""`,
+		`back\slash`:   `"back\\slash"`,
+		"two\r\nlines": "{10}\r\ntwo\r\nlines",
+		"caf\xc3\xa9":  "{5}\r\ncaf\xc3\xa9",
+		"":             `""`,
+	}
+
+	for value, expected := range tests {
+		if actual := quote(value); actual != expected {
+			t.Errorf("%q: expected %q, got %q", value, expected, actual)
+		}
+	}
+
+	if actual := nstring(""); actual != "NIL" {
+		t.Errorf("nstring of an empty value: expected NIL, got %q", actual)
+	}
+}
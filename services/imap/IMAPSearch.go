@@ -0,0 +1,329 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"fmt"
+	"mime"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type searchPredicate func(context *messageContext) bool
+
+var headerDecoder = &mime.WordDecoder{}
+
+func (session *imapSession) search(tag string, arguments imapList, useUID bool) {
+	if len(arguments) > 1 {
+		if first, ok := arguments[0].(string); ok && strings.EqualFold(first, "CHARSET") {
+			arguments = arguments[2:]
+		}
+	}
+
+	if len(arguments) == 0 {
+		session.tagged(tag, "BAD Missing search criteria")
+		return
+	}
+
+	predicate, err := session.parseSearchKeys(arguments)
+	if err != nil {
+		session.tagged(tag, "BAD "+err.Error())
+		return
+	}
+
+	results := make([]string, 0)
+
+	for index, message := range session.messages {
+		context := &messageContext{session: session, sequence: uint32(index + 1), message: message}
+
+		if predicate(context) {
+			if useUID {
+				results = append(results, fmt.Sprintf("%d", message.uid))
+			} else {
+				results = append(results, fmt.Sprintf("%d", context.sequence))
+			}
+		}
+	}
+
+	if len(results) > 0 {
+		session.untagged("SEARCH " + strings.Join(results, " "))
+	} else {
+		session.untagged("SEARCH")
+	}
+
+	session.tagged(tag, "OK SEARCH completed")
+}
+
+/*
+parseSearchKeys combines a list of search keys into one predicate.
+All keys must match.
+*/
+func (session *imapSession) parseSearchKeys(arguments imapList) (searchPredicate, error) {
+	predicates := make([]searchPredicate, 0)
+	position := 0
+
+	for position < len(arguments) {
+		predicate, next, err := session.parseSearchKey(arguments, position)
+		if err != nil {
+			return nil, err
+		}
+
+		predicates = append(predicates, predicate)
+		position = next
+	}
+
+	return func(context *messageContext) bool {
+		for _, predicate := range predicates {
+			if !predicate(context) {
+				return false
+			}
+		}
+
+		return true
+	}, nil
+}
+
+/*
+parseSearchKey parses the search key at position and returns its
+predicate along with the position of the next key.
+*/
+func (session *imapSession) parseSearchKey(arguments imapList, position int) (searchPredicate, int, error) {
+	if list, ok := arguments[position].(imapList); ok {
+		predicate, err := session.parseSearchKeys(list)
+		return predicate, position + 1, err
+	}
+
+	key, _ := arguments[position].(string)
+	upperKey := strings.ToUpper(key)
+	position++
+
+	argument := func() (string, error) {
+		if position >= len(arguments) {
+			return "", fmt.Errorf("Missing argument for %s", upperKey)
+		}
+
+		value, ok := arguments[position].(string)
+		if !ok {
+			return "", fmt.Errorf("Invalid argument for %s", upperKey)
+		}
+
+		position++
+		return value, nil
+	}
+
+	always := func(result bool) searchPredicate {
+		return func(context *messageContext) bool { return result }
+	}
+
+	switch upperKey {
+	case "ALL", "OLD", "UNANSWERED", "UNDELETED", "UNDRAFT", "UNFLAGGED", "UNSEEN":
+		return always(true), position, nil
+
+	case "ANSWERED", "DELETED", "DRAFT", "FLAGGED", "NEW", "RECENT", "SEEN":
+		return always(false), position, nil
+
+	case "KEYWORD", "UNKEYWORD":
+		if _, err := argument(); err != nil {
+			return nil, position, err
+		}
+
+		return always(upperKey == "UNKEYWORD"), position, nil
+
+	case "FROM", "TO", "CC", "BCC", "SUBJECT":
+		value, err := argument()
+		if err != nil {
+			return nil, position, err
+		}
+
+		return func(context *messageContext) bool {
+			return context.headerContains(upperKey, value)
+		}, position, nil
+
+	case "HEADER":
+		fieldName, err := argument()
+		if err != nil {
+			return nil, position, err
+		}
+
+		value, err := argument()
+		if err != nil {
+			return nil, position, err
+		}
+
+		return func(context *messageContext) bool {
+			return context.headerContains(fieldName, value)
+		}, position, nil
+
+	case "BODY", "TEXT":
+		value, err := argument()
+		if err != nil {
+			return nil, position, err
+		}
+
+		return func(context *messageContext) bool {
+			if containsFold(context.message.mailItem.Body, value) {
+				return true
+			}
+
+			if upperKey == "TEXT" && context.load() == nil {
+				return containsFold(string(context.root.rawHeader), value)
+			}
+
+			return false
+		}, position, nil
+
+	case "BEFORE", "ON", "SINCE", "SENTBEFORE", "SENTON", "SENTSINCE":
+		value, err := argument()
+		if err != nil {
+			return nil, position, err
+		}
+
+		date, err := time.ParseInLocation("2-Jan-2006", value, time.Local)
+		if err != nil {
+			return nil, position, fmt.Errorf("Invalid date %s", value)
+		}
+
+		return func(context *messageContext) bool {
+			internalDate := context.internalDate()
+			day := time.Date(internalDate.Year(), internalDate.Month(), internalDate.Day(), 0, 0, 0, 0, time.Local)
+
+			switch strings.TrimPrefix(upperKey, "SENT") {
+			case "BEFORE":
+				return day.Before(date)
+			case "ON":
+				return day.Equal(date)
+			default:
+				return !day.Before(date)
+			}
+		}, position, nil
+
+	case "LARGER", "SMALLER":
+		value, err := argument()
+		if err != nil {
+			return nil, position, err
+		}
+
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, position, fmt.Errorf("Invalid size %s", value)
+		}
+
+		return func(context *messageContext) bool {
+			if context.load() != nil {
+				return false
+			}
+
+			if upperKey == "LARGER" {
+				return len(context.contents) > size
+			}
+
+			return len(context.contents) < size
+		}, position, nil
+
+	case "UID":
+		value, err := argument()
+		if err != nil {
+			return nil, position, err
+		}
+
+		predicate, err := session.sequencePredicate(value, true)
+		return predicate, position, err
+
+	case "NOT":
+		if position >= len(arguments) {
+			return nil, position, fmt.Errorf("Missing search key for NOT")
+		}
+
+		predicate, next, err := session.parseSearchKey(arguments, position)
+		if err != nil {
+			return nil, next, err
+		}
+
+		return func(context *messageContext) bool { return !predicate(context) }, next, nil
+
+	case "OR":
+		if position+1 >= len(arguments) {
+			return nil, position, fmt.Errorf("OR requires two search keys")
+		}
+
+		left, next, err := session.parseSearchKey(arguments, position)
+		if err != nil {
+			return nil, next, err
+		}
+
+		if next >= len(arguments) {
+			return nil, next, fmt.Errorf("OR requires two search keys")
+		}
+
+		right, next, err := session.parseSearchKey(arguments, next)
+		if err != nil {
+			return nil, next, err
+		}
+
+		return func(context *messageContext) bool { return left(context) || right(context) }, next, nil
+	}
+
+	if isSequenceSet(key) {
+		predicate, err := session.sequencePredicate(key, false)
+		return predicate, position, err
+	}
+
+	return nil, position, fmt.Errorf("Unknown search key %s", key)
+}
+
+func (session *imapSession) sequencePredicate(value string, useUID bool) (searchPredicate, error) {
+	max := uint32(len(session.messages))
+
+	if useUID && len(session.messages) > 0 {
+		max = session.messages[len(session.messages)-1].uid
+	}
+
+	sequences, err := parseSequenceSet(value, max)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(context *messageContext) bool {
+		if useUID {
+			return sequences.contains(context.message.uid)
+		}
+
+		return sequences.contains(context.sequence)
+	}, nil
+}
+
+/*
+headerContains matches a header field against a search string, looking
+at both the raw and the decoded value. An empty search string matches
+any message that has the field.
+*/
+func (context *messageContext) headerContains(fieldName, value string) bool {
+	if context.load() != nil {
+		return false
+	}
+
+	values, ok := context.root.header[textproto.CanonicalMIMEHeaderKey(fieldName)]
+	if !ok {
+		return false
+	}
+
+	for _, headerValue := range values {
+		if containsFold(headerValue, value) {
+			return true
+		}
+
+		if decoded, err := headerDecoder.DecodeHeader(headerValue); err == nil && containsFold(decoded, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsFold(value, search string) bool {
+	return strings.Contains(strings.ToLower(value), strings.ToLower(search))
+}
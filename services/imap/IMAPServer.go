@@ -0,0 +1,298 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+	"github.com/mailslurper/libmailslurper/model/search"
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+	"github.com/mailslurper/mailslurper/services/mailstream"
+	"github.com/mailslurper/mailslurper/services/messagebuilder"
+)
+
+const (
+	// INBOX holds every captured mail item
+	INBOX string = "INBOX"
+
+	// RECIPIENTS_FOLDER is the parent of the per-recipient virtual folders
+	RECIPIENTS_FOLDER string = "Recipients"
+
+	HIERARCHY_DELIMITER string = "/"
+)
+
+/*
+IMAPServer is a read-only IMAP4rev1 listener exposing captured mail as
+an INBOX, plus one virtual folder per recipient address. Typical usage
+is to call NewIMAPServer(), then Start, and Close when shutting down.
+*/
+type IMAPServer struct {
+	Address  string
+	Port     int
+	UserName string
+	Password string
+
+	database   storage.IStorage
+	mailStore  mailstore.IMailStore
+	mailStream *mailstream.MailStreamReceiver
+	listener   net.Listener
+
+	lock         sync.Mutex
+	uidValidity  uint32
+	nextUID      uint32
+	uids         map[string]uint32
+	messageCache *messageCache
+}
+
+/*
+imapMessage is a mail item as seen in a selected mailbox
+*/
+type imapMessage struct {
+	uid      uint32
+	mailItem mailitem.MailItem
+}
+
+/*
+NewIMAPServer creates a new IMAPServer object. When userName is empty
+any credentials are accepted. New mail published on mailStream is
+pushed to clients waiting in IDLE.
+*/
+func NewIMAPServer(
+	address string,
+	port int,
+	userName string,
+	password string,
+	database storage.IStorage,
+	mailStore mailstore.IMailStore,
+	mailStream *mailstream.MailStreamReceiver,
+) *IMAPServer {
+	return &IMAPServer{
+		Address:  address,
+		Port:     port,
+		UserName: userName,
+		Password: password,
+
+		database:   database,
+		mailStore:  mailStore,
+		mailStream: mailStream,
+
+		uidValidity:  uint32(time.Now().Unix()),
+		nextUID:      1,
+		uids:         make(map[string]uint32),
+		messageCache: newMessageCache(MESSAGE_CACHE_MAX_BYTES),
+	}
+}
+
+/*
+Start opens the listener and begins accepting connections in the
+background.
+*/
+func (server *IMAPServer) Start() error {
+	var err error
+
+	if server.listener, err = net.Listen("tcp", fmt.Sprintf("%s:%d", server.Address, server.Port)); err != nil {
+		return err
+	}
+
+	log.Printf("MailSlurper: INFO - IMAP listener started on %s:%d\n", server.Address, server.Port)
+
+	go server.acceptConnections()
+	return nil
+}
+
+/*
+Close stops accepting new connections
+*/
+func (server *IMAPServer) Close() error {
+	if server.listener == nil {
+		return nil
+	}
+
+	return server.listener.Close()
+}
+
+func (server *IMAPServer) acceptConnections() {
+	for {
+		connection, err := server.listener.Accept()
+		if err != nil {
+			log.Printf("MailSlurper: INFO - IMAP listener stopped: %s\n", err.Error())
+			return
+		}
+
+		session := newIMAPSession(server, connection)
+		go session.run()
+	}
+}
+
+func (server *IMAPServer) authenticate(userName, password string) bool {
+	if server.UserName == "" {
+		return true
+	}
+
+	return userName == server.UserName && password == server.Password
+}
+
+/*
+getAllMail returns every stored mail item in UID order, assigning UIDs
+to mail items seen for the first time.
+*/
+func (server *IMAPServer) getAllMail() ([]*imapMessage, error) {
+	mailSearch := &search.MailSearch{
+		OrderByField:     "date",
+		OrderByDirection: "asc",
+	}
+
+	mailCount, err := server.database.GetMailCount(mailSearch)
+	if err != nil {
+		return nil, err
+	}
+
+	mailItems, err := server.database.GetMailCollection(0, mailCount, mailSearch)
+	if err != nil {
+		return nil, err
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	result := make([]*imapMessage, 0, len(mailItems))
+
+	for _, mailItem := range mailItems {
+		uid, ok := server.uids[mailItem.ID]
+
+		if !ok {
+			uid = server.nextUID
+			server.uids[mailItem.ID] = uid
+			server.nextUID++
+		}
+
+		result = append(result, &imapMessage{uid: uid, mailItem: mailItem})
+	}
+
+	sort.Sort(byUID(result))
+	return result, nil
+}
+
+/*
+getMailbox returns the messages in a mailbox. ok is false when the
+mailbox does not exist.
+*/
+func (server *IMAPServer) getMailbox(name string) ([]*imapMessage, bool, error) {
+	allMail, err := server.getAllMail()
+	if err != nil {
+		return nil, false, err
+	}
+
+	if strings.EqualFold(name, INBOX) {
+		return allMail, true, nil
+	}
+
+	prefix := RECIPIENTS_FOLDER + HIERARCHY_DELIMITER
+	if !strings.HasPrefix(name, prefix) {
+		return nil, false, nil
+	}
+
+	recipient := strings.ToLower(strings.TrimPrefix(name, prefix))
+	result := make([]*imapMessage, 0)
+
+	for _, message := range allMail {
+		for _, toAddress := range message.mailItem.ToAddresses {
+			if addressOnly(toAddress) == recipient {
+				result = append(result, message)
+				break
+			}
+		}
+	}
+
+	return result, len(result) > 0, nil
+}
+
+/*
+getMailboxNames lists INBOX followed by a folder for each distinct
+recipient address.
+*/
+func (server *IMAPServer) getMailboxNames() ([]string, error) {
+	allMail, err := server.getAllMail()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	recipients := make([]string, 0)
+
+	for _, message := range allMail {
+		for _, toAddress := range message.mailItem.ToAddresses {
+			recipient := addressOnly(toAddress)
+
+			if recipient != "" && !seen[recipient] {
+				seen[recipient] = true
+				recipients = append(recipients, recipient)
+			}
+		}
+	}
+
+	sort.Strings(recipients)
+
+	result := []string{INBOX}
+	for _, recipient := range recipients {
+		result = append(result, RECIPIENTS_FOLDER+HIERARCHY_DELIMITER+recipient)
+	}
+
+	return result, nil
+}
+
+/*
+getMessageContents returns the RFC 5322 form of a mail item. Built
+messages are cached since clients tend to fetch the same message
+several times; the cache is bounded by MESSAGE_CACHE_MAX_BYTES.
+*/
+func (server *IMAPServer) getMessageContents(mailID string) ([]byte, error) {
+	if contents, ok := server.messageCache.get(mailID); ok {
+		return contents, nil
+	}
+
+	contents, err := messagebuilder.GetMessageContents(server.database, server.mailStore, mailID)
+	if err != nil {
+		return nil, err
+	}
+
+	server.messageCache.put(mailID, contents)
+
+	return contents, nil
+}
+
+func (server *IMAPServer) getUIDNext() uint32 {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	return server.nextUID
+}
+
+type byUID []*imapMessage
+
+func (messages byUID) Len() int           { return len(messages) }
+func (messages byUID) Swap(i, j int)      { messages[i], messages[j] = messages[j], messages[i] }
+func (messages byUID) Less(i, j int) bool { return messages[i].uid < messages[j].uid }
+
+/*
+addressOnly strips a display name and angle brackets and lower cases
+the address, turning "Bob <Bob@Example.com>" into "bob@example.com"
+*/
+func addressOnly(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		return strings.ToLower(parsed.Address)
+	}
+
+	return strings.ToLower(strings.Trim(strings.TrimSpace(address), "<>"))
+}
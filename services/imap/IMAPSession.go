@@ -0,0 +1,614 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CAPABILITIES string = "IMAP4rev1 LITERAL+ IDLE UNSELECT AUTH=PLAIN"
+
+	SESSION_TIMEOUT time.Duration = 30 * time.Minute
+	IDLE_REFRESH    time.Duration = 30 * time.Second
+
+	// MAX_LITERAL_SIZE is the largest literal accepted once logged in, the same as the default SMTP message size limit
+	MAX_LITERAL_SIZE int = 26214400
+
+	// MAX_UNAUTHENTICATED_LITERAL_SIZE is the largest literal accepted before logging in, enough for credentials
+	MAX_UNAUTHENTICATED_LITERAL_SIZE int = 8192
+)
+
+var literalPattern = regexp.MustCompile(`\{(\d+)(\+?)\}$`)
+
+/*
+commandError is a command that cannot be read. It is answered with a
+BAD response, tagged when the tag is known. Fatal errors leave the
+rest of the stream unreadable, so the connection is closed.
+*/
+type commandError struct {
+	tag     string
+	message string
+	fatal   bool
+}
+
+func (err *commandError) Error() string {
+	return err.message
+}
+
+type imapSession struct {
+	server     *IMAPServer
+	connection net.Conn
+	reader     *bufio.Reader
+	writer     *bufio.Writer
+
+	authenticated   bool
+	selectedMailbox string
+	messages        []*imapMessage
+}
+
+func newIMAPSession(server *IMAPServer, connection net.Conn) *imapSession {
+	return &imapSession{
+		server:     server,
+		connection: connection,
+		reader:     bufio.NewReader(connection),
+		writer:     bufio.NewWriter(connection),
+	}
+}
+
+func (session *imapSession) run() {
+	defer session.connection.Close()
+
+	session.untagged("OK [CAPABILITY " + CAPABILITIES + "] MailSlurper IMAP4rev1 server ready")
+	session.writer.Flush()
+
+	for {
+		session.connection.SetReadDeadline(time.Now().Add(SESSION_TIMEOUT))
+
+		arguments, err := session.readCommand()
+		if commandErr, ok := err.(*commandError); ok {
+			if commandErr.tag != "" {
+				session.tagged(commandErr.tag, "BAD "+commandErr.message)
+			} else {
+				session.untagged("BAD " + commandErr.message)
+			}
+
+			session.writer.Flush()
+
+			if commandErr.fatal {
+				return
+			}
+
+			continue
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				if _, ok := err.(net.Error); !ok {
+					session.untagged("BAD " + err.Error())
+					session.writer.Flush()
+					continue
+				}
+			}
+
+			return
+		}
+
+		if len(arguments) < 2 {
+			session.untagged("BAD Missing tag or command")
+			session.writer.Flush()
+			continue
+		}
+
+		tag, tagOK := arguments[0].(string)
+		command, commandOK := arguments[1].(string)
+
+		if !tagOK || !commandOK {
+			session.untagged("BAD Invalid command")
+			session.writer.Flush()
+			continue
+		}
+
+		keepGoing := session.dispatch(tag, strings.ToUpper(command), arguments[2:])
+		session.writer.Flush()
+
+		if !keepGoing {
+			return
+		}
+	}
+}
+
+/*
+readCommand reads a full command, including any literals, and returns
+its arguments.
+*/
+func (session *imapSession) readCommand() (imapList, error) {
+	tokens := make([]token, 0)
+
+	for {
+		line, err := session.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		match := literalPattern.FindStringSubmatchIndex(line)
+
+		lineTokens, err := tokenizeLine(line[:len(line)-lengthOfMatch(match, line)])
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, lineTokens...)
+
+		if match == nil {
+			break
+		}
+
+		synchronizing := match[4] == match[5]
+
+		/*
+		 * A literal that is too large is refused before anything is
+		 * allocated. The client only sends a synchronizing literal
+		 * after "+", so the connection can carry on; a
+		 * non-synchronizing one is already on its way and cannot be
+		 * skipped safely.
+		 */
+		size, err := strconv.Atoi(line[match[2]:match[3]])
+		if err != nil || size > session.maxLiteralSize() {
+			return nil, &commandError{
+				tag:     commandTag(tokens),
+				message: fmt.Sprintf("Literal too large, the limit is %d bytes", session.maxLiteralSize()),
+				fatal:   !synchronizing,
+			}
+		}
+
+		if synchronizing {
+			session.writer.WriteString("+ Ready for literal data\r\n")
+			session.writer.Flush()
+		}
+
+		literalValue := make([]byte, size)
+		if _, err = io.ReadFull(session.reader, literalValue); err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token{kind: tokenString, value: string(literalValue)})
+	}
+
+	return buildList(tokens)
+}
+
+/*
+maxLiteralSize returns the largest literal the session accepts. Large
+literals are only allowed after logging in.
+*/
+func (session *imapSession) maxLiteralSize() int {
+	if session.authenticated {
+		return MAX_LITERAL_SIZE
+	}
+
+	return MAX_UNAUTHENTICATED_LITERAL_SIZE
+}
+
+/*
+commandTag returns the tag of a command being read, or an empty string
+when it has not been read yet
+*/
+func commandTag(tokens []token) string {
+	if len(tokens) == 0 || tokens[0].kind != tokenAtom {
+		return ""
+	}
+
+	return tokens[0].value
+}
+
+func lengthOfMatch(match []int, line string) int {
+	if match == nil {
+		return 0
+	}
+
+	return len(line) - match[0]
+}
+
+/*
+dispatch runs a single command. It returns false when the connection
+should be closed.
+*/
+func (session *imapSession) dispatch(tag, command string, arguments imapList) bool {
+	switch command {
+	case "CAPABILITY":
+		session.untagged("CAPABILITY " + CAPABILITIES)
+		session.tagged(tag, "OK CAPABILITY completed")
+		return true
+
+	case "NOOP", "CHECK":
+		if session.selectedMailbox != "" {
+			session.refreshMailbox()
+		}
+
+		session.tagged(tag, "OK "+command+" completed")
+		return true
+
+	case "LOGOUT":
+		session.untagged("BYE MailSlurper IMAP4rev1 server logging out")
+		session.tagged(tag, "OK LOGOUT completed")
+		return false
+	}
+
+	if !session.authenticated {
+		switch command {
+		case "LOGIN":
+			session.login(tag, arguments)
+		case "AUTHENTICATE":
+			session.authenticatePlain(tag, arguments)
+		default:
+			session.tagged(tag, "BAD Please log in first")
+		}
+
+		return true
+	}
+
+	switch command {
+	case "LIST", "LSUB":
+		session.list(tag, command, arguments)
+	case "STATUS":
+		session.status(tag, arguments)
+	case "SELECT", "EXAMINE":
+		session.selectMailbox(tag, command, arguments)
+	case "CREATE", "DELETE", "RENAME", "SUBSCRIBE", "UNSUBSCRIBE", "APPEND":
+		session.tagged(tag, "NO MailSlurper mailboxes are read-only")
+	case "LOGIN", "AUTHENTICATE":
+		session.tagged(tag, "BAD Already authenticated")
+	default:
+		if session.selectedMailbox == "" {
+			session.tagged(tag, "BAD Unknown command or no mailbox selected")
+			return true
+		}
+
+		session.dispatchSelected(tag, command, arguments)
+	}
+
+	return true
+}
+
+func (session *imapSession) dispatchSelected(tag, command string, arguments imapList) {
+	switch command {
+	case "CLOSE", "UNSELECT":
+		session.selectedMailbox = ""
+		session.messages = nil
+		session.tagged(tag, "OK "+command+" completed")
+	case "FETCH":
+		session.fetch(tag, arguments, false)
+	case "SEARCH":
+		session.search(tag, arguments, false)
+	case "UID":
+		session.uid(tag, arguments)
+	case "IDLE":
+		session.idle(tag)
+	case "STORE", "COPY", "EXPUNGE":
+		session.tagged(tag, "NO MailSlurper mailboxes are read-only")
+	default:
+		session.tagged(tag, "BAD Unknown command")
+	}
+}
+
+func (session *imapSession) login(tag string, arguments imapList) {
+	if len(arguments) != 2 {
+		session.tagged(tag, "BAD Usage: LOGIN user password")
+		return
+	}
+
+	userName, _ := arguments[0].(string)
+	password, _ := arguments[1].(string)
+
+	if !session.server.authenticate(userName, password) {
+		session.tagged(tag, "NO [AUTHENTICATIONFAILED] Invalid user name or password")
+		return
+	}
+
+	session.authenticated = true
+	session.tagged(tag, "OK [CAPABILITY "+CAPABILITIES+"] LOGIN completed")
+}
+
+/*
+authenticatePlain implements AUTHENTICATE PLAIN, with or without an
+initial response.
+*/
+func (session *imapSession) authenticatePlain(tag string, arguments imapList) {
+	if len(arguments) < 1 || !strings.EqualFold(fmt.Sprintf("%v", arguments[0]), "PLAIN") {
+		session.tagged(tag, "NO Unsupported authentication mechanism")
+		return
+	}
+
+	var response string
+
+	if len(arguments) > 1 {
+		response, _ = arguments[1].(string)
+	} else {
+		session.writer.WriteString("+ \r\n")
+		session.writer.Flush()
+
+		line, err := session.reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		response = strings.TrimRight(line, "\r\n")
+	}
+
+	if response == "*" {
+		session.tagged(tag, "BAD Authentication cancelled")
+		return
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(response)
+	if err != nil {
+		session.tagged(tag, "BAD Invalid base64 response")
+		return
+	}
+
+	parts := bytes.Split(decoded, []byte{0})
+	if len(parts) != 3 || !session.server.authenticate(string(parts[1]), string(parts[2])) {
+		session.tagged(tag, "NO [AUTHENTICATIONFAILED] Invalid user name or password")
+		return
+	}
+
+	session.authenticated = true
+	session.tagged(tag, "OK [CAPABILITY "+CAPABILITIES+"] AUTHENTICATE completed")
+}
+
+func (session *imapSession) list(tag, command string, arguments imapList) {
+	if len(arguments) != 2 {
+		session.tagged(tag, "BAD Usage: "+command+" reference mailbox")
+		return
+	}
+
+	reference, _ := arguments[0].(string)
+	pattern, _ := arguments[1].(string)
+
+	if pattern == "" {
+		session.untagged(command + ` (\Noselect) "` + HIERARCHY_DELIMITER + `" ""`)
+		session.tagged(tag, "OK "+command+" completed")
+		return
+	}
+
+	names, err := session.server.getMailboxNames()
+	if err != nil {
+		log.Printf("MailSlurper: ERROR - IMAP could not list mailboxes: %s\n", err.Error())
+		session.tagged(tag, "NO Unable to read mailboxes")
+		return
+	}
+
+	matcher := mailboxPattern(reference + pattern)
+
+	if len(names) > 1 && matcher.MatchString(RECIPIENTS_FOLDER) {
+		session.untagged(command + ` (\Noselect \HasChildren) "` + HIERARCHY_DELIMITER + `" ` + quote(RECIPIENTS_FOLDER))
+	}
+
+	for _, name := range names {
+		if matcher.MatchString(name) {
+			session.untagged(command + ` (\HasNoChildren) "` + HIERARCHY_DELIMITER + `" ` + quote(name))
+		}
+	}
+
+	session.tagged(tag, "OK "+command+" completed")
+}
+
+func (session *imapSession) status(tag string, arguments imapList) {
+	if len(arguments) != 2 {
+		session.tagged(tag, "BAD Usage: STATUS mailbox (items)")
+		return
+	}
+
+	name, _ := arguments[0].(string)
+	items, ok := arguments[1].(imapList)
+	if !ok {
+		session.tagged(tag, "BAD Status items must be a list")
+		return
+	}
+
+	messages, exists, err := session.server.getMailbox(name)
+	if err != nil {
+		session.tagged(tag, "NO Unable to read mailbox")
+		return
+	}
+
+	if !exists && !strings.EqualFold(name, INBOX) {
+		session.tagged(tag, "NO No such mailbox")
+		return
+	}
+
+	values := make([]string, 0)
+
+	for _, item := range items {
+		itemName := strings.ToUpper(fmt.Sprintf("%v", item))
+
+		switch itemName {
+		case "MESSAGES", "UNSEEN":
+			values = append(values, itemName+" "+itoa(len(messages)))
+		case "RECENT":
+			values = append(values, "RECENT 0")
+		case "UIDNEXT":
+			values = append(values, fmt.Sprintf("UIDNEXT %d", session.server.getUIDNext()))
+		case "UIDVALIDITY":
+			values = append(values, fmt.Sprintf("UIDVALIDITY %d", session.server.uidValidity))
+		default:
+			session.tagged(tag, "BAD Unknown status item "+itemName)
+			return
+		}
+	}
+
+	session.untagged("STATUS " + quote(name) + " (" + strings.Join(values, " ") + ")")
+	session.tagged(tag, "OK STATUS completed")
+}
+
+func (session *imapSession) selectMailbox(tag, command string, arguments imapList) {
+	if len(arguments) != 1 {
+		session.tagged(tag, "BAD Usage: "+command+" mailbox")
+		return
+	}
+
+	name, _ := arguments[0].(string)
+	session.selectedMailbox = ""
+	session.messages = nil
+
+	messages, exists, err := session.server.getMailbox(name)
+	if err != nil {
+		log.Printf("MailSlurper: ERROR - IMAP could not read mailbox %s: %s\n", name, err.Error())
+		session.tagged(tag, "NO Unable to read mailbox")
+		return
+	}
+
+	if !exists && !strings.EqualFold(name, INBOX) {
+		session.tagged(tag, "NO No such mailbox")
+		return
+	}
+
+	session.selectedMailbox = name
+	session.messages = messages
+
+	session.untagged(`FLAGS (\Answered \Flagged \Deleted \Seen \Draft)`)
+	session.untagged("OK [PERMANENTFLAGS ()] Read-only mailbox")
+	session.untagged(fmt.Sprintf("%d EXISTS", len(messages)))
+	session.untagged("0 RECENT")
+
+	if len(messages) > 0 {
+		session.untagged("OK [UNSEEN 1] First unseen message")
+	}
+
+	session.untagged(fmt.Sprintf("OK [UIDVALIDITY %d] UIDs valid", session.server.uidValidity))
+	session.untagged(fmt.Sprintf("OK [UIDNEXT %d] Predicted next UID", session.server.getUIDNext()))
+	session.tagged(tag, "OK [READ-ONLY] "+command+" completed")
+}
+
+func (session *imapSession) uid(tag string, arguments imapList) {
+	if len(arguments) < 1 {
+		session.tagged(tag, "BAD Usage: UID command arguments")
+		return
+	}
+
+	subCommand, _ := arguments[0].(string)
+
+	switch strings.ToUpper(subCommand) {
+	case "FETCH":
+		session.fetch(tag, arguments[1:], true)
+	case "SEARCH":
+		session.search(tag, arguments[1:], true)
+	case "STORE", "COPY", "EXPUNGE":
+		session.tagged(tag, "NO MailSlurper mailboxes are read-only")
+	default:
+		session.tagged(tag, "BAD Unknown UID command")
+	}
+}
+
+/*
+idle waits for new mail and reports it until the client sends DONE
+*/
+func (session *imapSession) idle(tag string) {
+	subscriber := session.server.mailStream.Subscribe()
+	defer session.server.mailStream.Unsubscribe(subscriber)
+
+	done := make(chan bool, 1)
+
+	go func() {
+		for {
+			line, err := session.reader.ReadString('\n')
+			if err != nil || strings.EqualFold(strings.TrimSpace(line), "DONE") {
+				done <- err == nil
+				return
+			}
+		}
+	}()
+
+	session.writer.WriteString("+ idling\r\n")
+	session.writer.Flush()
+
+	refresh := time.NewTicker(IDLE_REFRESH)
+	defer refresh.Stop()
+
+	for {
+		session.connection.SetReadDeadline(time.Now().Add(SESSION_TIMEOUT))
+
+		select {
+		case ok := <-done:
+			if ok {
+				session.tagged(tag, "OK IDLE terminated")
+			}
+
+			return
+
+		case <-subscriber:
+			session.refreshMailbox()
+			session.writer.Flush()
+
+		case <-refresh.C:
+			session.refreshMailbox()
+			session.writer.Flush()
+		}
+	}
+}
+
+/*
+refreshMailbox re-reads the selected mailbox and reports removed
+messages with EXPUNGE and new ones with EXISTS.
+*/
+func (session *imapSession) refreshMailbox() {
+	messages, _, err := session.server.getMailbox(session.selectedMailbox)
+	if err != nil {
+		log.Printf("MailSlurper: ERROR - IMAP could not refresh mailbox %s: %s\n", session.selectedMailbox, err.Error())
+		return
+	}
+
+	current := make(map[uint32]bool)
+	for _, message := range messages {
+		current[message.uid] = true
+	}
+
+	remaining := len(session.messages)
+
+	for index := len(session.messages) - 1; index >= 0; index-- {
+		if !current[session.messages[index].uid] {
+			session.untagged(fmt.Sprintf("%d EXPUNGE", index+1))
+			remaining--
+		}
+	}
+
+	if len(messages) != remaining {
+		session.untagged(fmt.Sprintf("%d EXISTS", len(messages)))
+	}
+
+	session.messages = messages
+}
+
+func (session *imapSession) untagged(response string) {
+	session.writer.WriteString("* " + response + "\r\n")
+}
+
+func (session *imapSession) tagged(tag, response string) {
+	session.writer.WriteString(tag + " " + response + "\r\n")
+}
+
+/*
+mailboxPattern converts a LIST pattern into a regular expression.
+"*" matches anything, "%" matches anything but the hierarchy delimiter.
+*/
+func mailboxPattern(pattern string) *regexp.Regexp {
+	expression := regexp.QuoteMeta(pattern)
+	expression = strings.Replace(expression, `\*`, ".*", -1)
+	expression = strings.Replace(expression, "%", "[^"+regexp.QuoteMeta(HIERARCHY_DELIMITER)+"]*", -1)
+
+	return regexp.MustCompile("(?i)^" + expression + "$")
+}
@@ -0,0 +1,150 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestSession(input string, authenticated bool) (*imapSession, *bytes.Buffer) {
+	output := &bytes.Buffer{}
+
+	session := &imapSession{
+		reader:        bufio.NewReader(strings.NewReader(input)),
+		writer:        bufio.NewWriter(output),
+		authenticated: authenticated,
+	}
+
+	return session, output
+}
+
+func TestReadCommand(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		authenticated bool
+		expected      imapList
+		continuations int
+	}{
+		{
+			name:     "single line",
+			input:    "a1 LOGIN user secret\r\n",
+			expected: imapList{"a1", "LOGIN", "user", "secret"},
+		},
+		{
+			name:     "bare line feed",
+			input:    "a2 NOOP\n",
+			expected: imapList{"a2", "NOOP"},
+		},
+		{
+			name:          "synchronizing literal",
+			input:         "a3 LOGIN {4}\r\nuser secret\r\n",
+			expected:      imapList{"a3", "LOGIN", "user", "secret"},
+			continuations: 1,
+		},
+		{
+			name:     "non-synchronizing literal",
+			input:    "a4 LOGIN {4+}\r\nuser {6+}\r\nsecret\r\n",
+			expected: imapList{"a4", "LOGIN", "user", "secret"},
+		},
+		{
+			name:          "literal with line breaks inside a list",
+			input:         "a5 SEARCH (SUBJECT {5}\r\na\r\nbc)\r\n",
+			expected:      imapList{"a5", "SEARCH", imapList{"SUBJECT", "a\r\nbc"}},
+			continuations: 1,
+		},
+		{
+			name:          "large literal once logged in",
+			input:         fmt.Sprintf("a6 SEARCH BODY {%d}\r\n%s\r\n", MAX_UNAUTHENTICATED_LITERAL_SIZE+1, strings.Repeat("x", MAX_UNAUTHENTICATED_LITERAL_SIZE+1)),
+			authenticated: true,
+			expected:      imapList{"a6", "SEARCH", "BODY", strings.Repeat("x", MAX_UNAUTHENTICATED_LITERAL_SIZE+1)},
+			continuations: 1,
+		},
+	}
+
+	for _, test := range tests {
+		session, output := newTestSession(test.input, test.authenticated)
+
+		actual, err := session.readCommand()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+			continue
+		}
+
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %#v, got %#v", test.name, test.expected, actual)
+		}
+
+		if continuations := strings.Count(output.String(), "+ Ready for literal data\r\n"); continuations != test.continuations {
+			t.Errorf("%s: expected %d continuation(s), got %d", test.name, test.continuations, continuations)
+		}
+	}
+}
+
+func TestReadCommandRejectsLiterals(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		authenticated bool
+		tag           string
+		fatal         bool
+	}{
+		{
+			name:  "synchronizing literal too large before login",
+			input: fmt.Sprintf("a1 LOGIN {%d}\r\n", MAX_UNAUTHENTICATED_LITERAL_SIZE+1),
+			tag:   "a1",
+		},
+		{
+			name:  "non-synchronizing literal too large before login",
+			input: fmt.Sprintf("a2 LOGIN {%d+}\r\n", MAX_UNAUTHENTICATED_LITERAL_SIZE+1),
+			tag:   "a2",
+			fatal: true,
+		},
+		{
+			name:          "literal too large once logged in",
+			input:         fmt.Sprintf("a3 SEARCH BODY {%d}\r\n", MAX_LITERAL_SIZE+1),
+			authenticated: true,
+			tag:           "a3",
+		},
+		{
+			name:  "literal size overflows",
+			input: "a4 LOGIN {99999999999999999999999}\r\n",
+			tag:   "a4",
+		},
+		{
+			name:  "literal without a tag",
+			input: "{9999999}\r\n",
+		},
+	}
+
+	for _, test := range tests {
+		session, output := newTestSession(test.input, test.authenticated)
+
+		_, err := session.readCommand()
+
+		commandErr, ok := err.(*commandError)
+		if !ok {
+			t.Errorf("%s: expected a command error, got %v", test.name, err)
+			continue
+		}
+
+		if commandErr.tag != test.tag {
+			t.Errorf("%s: expected tag %q, got %q", test.name, test.tag, commandErr.tag)
+		}
+
+		if commandErr.fatal != test.fatal {
+			t.Errorf("%s: expected fatal to be %t", test.name, test.fatal)
+		}
+
+		if output.Len() != 0 {
+			t.Errorf("%s: expected no continuation, got %q", test.name, output.String())
+		}
+	}
+}
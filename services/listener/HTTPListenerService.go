@@ -10,7 +10,10 @@ import (
 	"io/ioutil"
 	"log"
 	"mime"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"path"
 	"path/filepath"
 	"strings"
@@ -28,7 +31,9 @@ import (
 HTTPListenerService is a structure which provides an HTTP listener to service
 requests. This structure offers methods to add routes and middlewares. Typical
 usage would first call NewHTTPListenerService(), add routes, then call
-StartHTTPListener.
+StartHTTPListener. Listen and Serve do the same in two steps, so the
+port chosen for a Port of zero can be read from Addr before requests
+are served, and Close stops the listener.
 */
 type HTTPListenerService struct {
 	Address string
@@ -37,6 +42,9 @@ type HTTPListenerService struct {
 
 	Router                 *mux.Router
 	BaseMiddlewareHandlers alice.Chain
+
+	server   *http.Server
+	listener net.Listener
 }
 
 /*
@@ -93,6 +101,29 @@ func (service *HTTPListenerService) AddRouteWithMiddleware(
 	return service
 }
 
+/*
+AddMount serves every request under pathPrefix with another handler,
+which sees the path with the prefix removed
+*/
+func (service *HTTPListenerService) AddMount(pathPrefix string, handler http.Handler) *HTTPListenerService {
+	service.Router.PathPrefix(pathPrefix + "/").Handler(http.StripPrefix(pathPrefix, handler))
+	return service
+}
+
+/*
+AddReverseProxy sends every request that does not match a route to
+another HTTP server. modifyResponse, when not nil, can change each
+response before it is sent on.
+*/
+func (service *HTTPListenerService) AddReverseProxy(target *url.URL, modifyResponse func(*http.Response) error) *HTTPListenerService {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ModifyResponse = modifyResponse
+
+	service.Router.NotFoundHandler = proxy
+	service.Router.MethodNotAllowedHandler = proxy
+	return service
+}
+
 /*
 AddStaticRoute adds a HTTP handler route for static assets.
 */
@@ -134,20 +165,96 @@ func (service *HTTPListenerService) gzipFileServer(dir http.FileSystem) http.Han
 	})
 }
 
+/*
+GetFreePort asks the operating system for a TCP port that is not in use
+on the given address.
+*/
+func GetFreePort(address string) (int, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:0", address))
+	if err != nil {
+		return 0, err
+	}
+
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+/*
+Handler returns the handler serving this listener's routes, so they can
+be mounted on another listener
+*/
+func (service *HTTPListenerService) Handler() http.Handler {
+	return alice.New().Then(service.Router)
+}
+
 /*
 StartHTTPListener starts the HTTP listener and servicing requests.
 */
 func (service *HTTPListenerService) StartHTTPListener(config *configuration.Configuration) error {
-	listener := &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", service.Address, service.Port),
-		Handler: alice.New().Then(service.Router),
+	if err := service.Listen(); err != nil {
+		return err
+	}
+
+	return service.Serve(config)
+}
+
+/*
+Listen opens the listener's port without serving requests yet
+*/
+func (service *HTTPListenerService) Listen() error {
+	var err error
+
+	if service.listener, err = net.Listen("tcp", fmt.Sprintf("%s:%d", service.Address, service.Port)); err != nil {
+		return err
+	}
+
+	service.server = &http.Server{
+		Handler: service.Handler(),
 	}
 
+	return nil
+}
+
+/*
+Serve services requests on the port opened by Listen, using HTTPS when
+a certificate is configured. It returns http.ErrServerClosed once Close
+is called.
+*/
+func (service *HTTPListenerService) Serve(config *configuration.Configuration) error {
 	if config.CertFile != "" && config.KeyFile != "" {
-		log.Printf("MailSlurper: INFO - HTTPS listener started on %s:%d\n", service.Address, service.Port)
-		return listener.ListenAndServeTLS(config.CertFile, config.KeyFile)
+		log.Printf("MailSlurper: INFO - HTTPS listener started on %s\n", service.listener.Addr().String())
+		return service.server.ServeTLS(service.listener, config.CertFile, config.KeyFile)
+	}
+
+	log.Printf("MailSlurper: INFO - HTTP listener started on %s\n", service.listener.Addr().String())
+	return service.server.Serve(service.listener)
+}
+
+/*
+Addr returns the address opened by Listen, which tells the port chosen
+when Port is zero. It is nil until Listen succeeds.
+*/
+func (service *HTTPListenerService) Addr() net.Addr {
+	if service.listener == nil {
+		return nil
+	}
+
+	return service.listener.Addr()
+}
+
+/*
+Close stops the listener and closes any open connections
+*/
+func (service *HTTPListenerService) Close() error {
+	if service.server == nil {
+		return nil
 	}
 
-	log.Printf("MailSlurper: INFO - HTTP listener started on %s:%d\n", service.Address, service.Port)
-	return listener.ListenAndServe()
+	err := service.server.Close()
+
+	/*
+	 * The server only closes the listener once Serve has been called
+	 */
+	service.listener.Close()
+	return err
 }
@@ -0,0 +1,256 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package mailarchive
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/mailslurper/mailslurper/services/mailparser"
+)
+
+const (
+	// FORMAT_MBOX is a single mbox file in the mboxrd variant
+	FORMAT_MBOX string = "mbox"
+
+	// FORMAT_ZIP is a zip of .eml files with a manifest.json
+	FORMAT_ZIP string = "zip"
+
+	// MANIFEST_FILE_NAME is the name of the manifest in a zip export
+	MANIFEST_FILE_NAME string = "manifest.json"
+
+	// MANIFEST_VERSION is the version of the manifest written by exports
+	MANIFEST_VERSION int = 1
+
+	// MBOX_DATE_FORMAT is the date on the From line starting each message in an mbox
+	MBOX_DATE_FORMAT string = time.ANSIC
+
+	// MBOX_DEFAULT_SENDER is put on the From line of a message without an envelope sender
+	MBOX_DEFAULT_SENDER string = "MAILER-DAEMON"
+)
+
+var fromLinePattern = regexp.MustCompile(`^>*From `)
+
+/*
+IArchiveWriter writes messages to an export archive as they are read.
+Close finishes the archive but does not close the underlying writer.
+*/
+type IArchiveWriter interface {
+	Close() error
+	ContentType() string
+	FileExtension() string
+	WriteMessage(mailItem *mailitem.MailItem, tag string, contents []byte) error
+}
+
+/*
+NewArchiveWriter returns the writer for an export format
+*/
+func NewArchiveWriter(format string, writer io.Writer) (IArchiveWriter, error) {
+	switch format {
+	case FORMAT_MBOX:
+		return NewMboxWriter(writer), nil
+	case FORMAT_ZIP:
+		return NewZipWriter(writer), nil
+	}
+
+	return nil, fmt.Errorf("Unsupported export format '%s'", format)
+}
+
+/*
+MboxWriter writes messages as an mboxrd file. Each message starts with
+a From line holding the envelope sender and date sent, lines of the
+message starting with "From " (after any number of ">") gain another
+">", and a blank line follows each message.
+*/
+type MboxWriter struct {
+	writer io.Writer
+}
+
+/*
+NewMboxWriter creates a new MboxWriter object
+*/
+func NewMboxWriter(writer io.Writer) *MboxWriter {
+	return &MboxWriter{
+		writer: writer,
+	}
+}
+
+/*
+Close has nothing to finish for an mbox
+*/
+func (mboxWriter *MboxWriter) Close() error {
+	return nil
+}
+
+/*
+ContentType returns the MIME type of an mbox file
+*/
+func (mboxWriter *MboxWriter) ContentType() string {
+	return "application/mbox"
+}
+
+/*
+FileExtension returns the file extension of an mbox file
+*/
+func (mboxWriter *MboxWriter) FileExtension() string {
+	return "mbox"
+}
+
+/*
+WriteMessage appends a message to the mbox. mbox files use LF line
+endings, so CRLF is converted.
+*/
+func (mboxWriter *MboxWriter) WriteMessage(mailItem *mailitem.MailItem, tag string, contents []byte) error {
+	result := &bytes.Buffer{}
+
+	sender := mboxSender(mailItem.FromAddress)
+	fmt.Fprintf(result, "From %s %s\n", sender, parseDateSent(mailItem.DateSent).Format(MBOX_DATE_FORMAT))
+
+	contents = bytes.Replace(contents, []byte("\r\n"), []byte("\n"), -1)
+	lines := strings.SplitAfter(string(contents), "\n")
+
+	for _, line := range lines {
+		if fromLinePattern.MatchString(line) {
+			result.WriteString(">")
+		}
+
+		result.WriteString(line)
+	}
+
+	if !bytes.HasSuffix(contents, []byte("\n")) {
+		result.WriteString("\n")
+	}
+
+	result.WriteString("\n")
+
+	_, err := mboxWriter.writer.Write(result.Bytes())
+	return err
+}
+
+/*
+ZipWriter writes each message to a zip as <mailID>.eml, followed by
+manifest.json listing them
+*/
+type ZipWriter struct {
+	writer   *zip.Writer
+	manifest *model.ArchiveManifest
+}
+
+/*
+NewZipWriter creates a new ZipWriter object
+*/
+func NewZipWriter(writer io.Writer) *ZipWriter {
+	return &ZipWriter{
+		writer: zip.NewWriter(writer),
+		manifest: &model.ArchiveManifest{
+			Version:    MANIFEST_VERSION,
+			ExportedAt: time.Now().Format(mailparser.DATE_FORMAT),
+			MailItems:  make([]*model.ArchiveEntry, 0),
+		},
+	}
+}
+
+/*
+Close writes the manifest and finishes the zip
+*/
+func (zipWriter *ZipWriter) Close() error {
+	file, err := zipWriter.writer.Create(MANIFEST_FILE_NAME)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "\t")
+
+	if err = encoder.Encode(zipWriter.manifest); err != nil {
+		return err
+	}
+
+	return zipWriter.writer.Close()
+}
+
+/*
+ContentType returns the MIME type of a zip file
+*/
+func (zipWriter *ZipWriter) ContentType() string {
+	return "application/zip"
+}
+
+/*
+FileExtension returns the file extension of a zip file
+*/
+func (zipWriter *ZipWriter) FileExtension() string {
+	return "zip"
+}
+
+/*
+WriteMessage adds a message to the zip and records it in the manifest
+*/
+func (zipWriter *ZipWriter) WriteMessage(mailItem *mailitem.MailItem, tag string, contents []byte) error {
+	entry := &model.ArchiveEntry{
+		FileName:    mailItem.ID + ".eml",
+		MailItemID:  mailItem.ID,
+		DateSent:    mailItem.DateSent,
+		FromAddress: mailItem.FromAddress,
+		ToAddresses: mailItem.ToAddresses,
+		Subject:     mailItem.Subject,
+		Tag:         tag,
+	}
+
+	header := &zip.FileHeader{
+		Name:   entry.FileName,
+		Method: zip.Deflate,
+	}
+
+	header.SetModTime(parseDateSent(mailItem.DateSent))
+
+	file, err := zipWriter.writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(contents); err != nil {
+		return err
+	}
+
+	zipWriter.manifest.MailItems = append(zipWriter.manifest.MailItems, entry)
+	return nil
+}
+
+/*
+mboxSender returns the bare address of a sender for an mbox From line,
+which cannot contain spaces
+*/
+func mboxSender(fromAddress string) string {
+	if start := strings.LastIndex(fromAddress, "<"); start > -1 {
+		if end := strings.Index(fromAddress[start:], ">"); end > -1 {
+			fromAddress = fromAddress[start+1 : start+end]
+		}
+	}
+
+	fromAddress = strings.TrimSpace(fromAddress)
+
+	if fromAddress == "" || strings.ContainsAny(fromAddress, " \t") {
+		return MBOX_DEFAULT_SENDER
+	}
+
+	return fromAddress
+}
+
+func parseDateSent(dateSent string) time.Time {
+	if result, err := time.ParseInLocation(mailparser.DATE_FORMAT, dateSent, time.Local); err == nil {
+		return result
+	}
+
+	return time.Now()
+}
@@ -0,0 +1,57 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package mailarchive
+
+import (
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/services/mailquery"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+	"github.com/mailslurper/mailslurper/services/messagebuilder"
+)
+
+const (
+	// EXPORT_PAGE_SIZE is how many mail items are read at a time while exporting
+	EXPORT_PAGE_SIZE int = 100
+)
+
+/*
+Export writes every mail item matching a query to an archive, in the
+order the query returns them, and returns how many were written. Each
+message is written as it was received, or rebuilt when the raw message
+was not kept. Mail is read a page at a time so large mailboxes are
+streamed rather than held in memory. The archive is not closed.
+*/
+func Export(archiveWriter IArchiveWriter, query *mailquery.MailQuery, database storage.IStorage, mailStore mailstore.IMailStore) (int, error) {
+	exported := 0
+
+	for offset := 0; ; offset += EXPORT_PAGE_SIZE {
+		mailItems, _, err := query.Execute(database, mailStore, offset, EXPORT_PAGE_SIZE)
+		if err != nil {
+			return exported, err
+		}
+
+		for index := range mailItems {
+			contents, err := messagebuilder.GetMessageContents(database, mailStore, mailItems[index].ID)
+			if err != nil {
+				return exported, err
+			}
+
+			metadata, err := mailStore.GetMailMetadata(mailItems[index].ID)
+			if err != nil {
+				return exported, err
+			}
+
+			if err = archiveWriter.WriteMessage(&mailItems[index], metadata.Tag, contents); err != nil {
+				return exported, err
+			}
+
+			exported++
+		}
+
+		if len(mailItems) < EXPORT_PAGE_SIZE {
+			return exported, nil
+		}
+	}
+}
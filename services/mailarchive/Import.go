@@ -0,0 +1,237 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package mailarchive
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/mailslurper/mailslurper/services/dispatch"
+	"github.com/mailslurper/mailslurper/services/mailparser"
+)
+
+var zipSignature = []byte("PK\x03\x04")
+
+/*
+Import reads an mbox or zip export, telling them apart by their
+contents, and hands every message to the dispatcher. It returns the IDs
+of the mail items created. A zip is read into memory; use ImportZip
+to import a zip file from disk.
+*/
+func Import(reader io.Reader, dispatcher *dispatch.MailDispatcher) ([]string, error) {
+	buffered := bufio.NewReader(reader)
+
+	signature, err := buffered.Peek(len(zipSignature))
+	if err != nil && err != io.EOF {
+		return []string{}, err
+	}
+
+	if !bytes.Equal(signature, zipSignature) {
+		return ImportMbox(buffered, dispatcher)
+	}
+
+	contents, err := ioutil.ReadAll(buffered)
+	if err != nil {
+		return []string{}, err
+	}
+
+	return ImportZip(bytes.NewReader(contents), int64(len(contents)), dispatcher)
+}
+
+/*
+ImportMbox hands every message in an mbox to the dispatcher, restoring
+the envelope sender and date sent from each From line. Both the mboxrd
+and mboxo quoting of "From " lines are undone.
+*/
+func ImportMbox(reader io.Reader, dispatcher *dispatch.MailDispatcher) ([]string, error) {
+	var fromLine string
+	var message *bytes.Buffer
+
+	result := make([]string, 0)
+	buffered := bufio.NewReader(reader)
+
+	flush := func() error {
+		if message == nil {
+			return nil
+		}
+
+		/*
+		 * The blank line before the next From line separates messages
+		 * and is not part of the message
+		 */
+		contents := message.Bytes()
+		if bytes.HasSuffix(contents, []byte("\r\n\r\n")) {
+			contents = contents[:len(contents)-2]
+		} else if bytes.HasSuffix(contents, []byte("\n\n")) {
+			contents = contents[:len(contents)-1]
+		}
+
+		sender, dateSent := parseFromLine(fromLine)
+
+		mailID, err := importMessage(dispatcher, contents, sender, nil, dateSent, "")
+		if err != nil {
+			return err
+		}
+
+		result = append(result, mailID)
+		return nil
+	}
+
+	for {
+		line, err := buffered.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return result, err
+		}
+
+		if strings.HasPrefix(line, "From ") {
+			if err := flush(); err != nil {
+				return result, err
+			}
+
+			fromLine = strings.TrimRight(line, "\r\n")
+			message = &bytes.Buffer{}
+		} else if message != nil {
+			if fromLinePattern.MatchString(line) {
+				line = line[1:]
+			}
+
+			message.WriteString(line)
+		} else if strings.TrimSpace(line) != "" {
+			return result, fmt.Errorf("Not an mbox file: the first line must start with \"From \"")
+		}
+
+		if err == io.EOF {
+			return result, flush()
+		}
+	}
+}
+
+/*
+ImportZip hands every .eml file in a zip to the dispatcher. When the
+zip has a manifest.json its entries restore the envelope, date sent and
+mailbox of each message, and give the order they are imported in.
+*/
+func ImportZip(reader io.ReaderAt, size int64, dispatcher *dispatch.MailDispatcher) ([]string, error) {
+	result := make([]string, 0)
+
+	archive, err := zip.NewReader(reader, size)
+	if err != nil {
+		return result, err
+	}
+
+	files := make(map[string]*zip.File)
+	entries := make([]*model.ArchiveEntry, 0)
+
+	for _, file := range archive.File {
+		if strings.HasSuffix(strings.ToLower(file.Name), ".eml") {
+			files[file.Name] = file
+			entries = append(entries, &model.ArchiveEntry{FileName: file.Name})
+		}
+	}
+
+	for _, file := range archive.File {
+		if path.Base(file.Name) != MANIFEST_FILE_NAME {
+			continue
+		}
+
+		manifest := &model.ArchiveManifest{}
+		if err = readZipJSON(file, manifest); err != nil {
+			return result, fmt.Errorf("Unable to read %s: %s", MANIFEST_FILE_NAME, err.Error())
+		}
+
+		entries = manifest.MailItems
+		break
+	}
+
+	for _, entry := range entries {
+		file, ok := files[entry.FileName]
+		if !ok {
+			return result, fmt.Errorf("%s lists %s, which is not in the archive", MANIFEST_FILE_NAME, entry.FileName)
+		}
+
+		contents, err := readZipFile(file)
+		if err != nil {
+			return result, err
+		}
+
+		mailID, err := importMessage(dispatcher, contents, entry.FromAddress, entry.ToAddresses, parseDateSent(entry.DateSent), entry.Tag)
+		if err != nil {
+			return result, fmt.Errorf("Unable to import %s: %s", entry.FileName, err.Error())
+		}
+
+		result = append(result, mailID)
+	}
+
+	return result, nil
+}
+
+/*
+importMessage parses a message and dispatches it like mail received
+over SMTP, keeping the date it was originally sent
+*/
+func importMessage(dispatcher *dispatch.MailDispatcher, contents []byte, fromAddress string, toAddresses []string, dateSent time.Time, tag string) (string, error) {
+	mailItem, err := mailparser.Parse(contents, fromAddress, toAddresses)
+	if err != nil {
+		return "", err
+	}
+
+	mailItem.DateSent = dateSent.Format(mailparser.DATE_FORMAT)
+
+	if err = dispatcher.Dispatch(mailItem, contents, &model.MailMetadata{Tag: tag}); err != nil {
+		return "", err
+	}
+
+	return mailItem.ID, nil
+}
+
+/*
+parseFromLine reads the envelope sender and date from an mbox From
+line. The sender is empty when it is the placeholder written for mail
+without one, and the date is now when it cannot be read.
+*/
+func parseFromLine(fromLine string) (string, time.Time) {
+	fields := strings.SplitN(strings.TrimPrefix(fromLine, "From "), " ", 2)
+	sender := fields[0]
+
+	if sender == MBOX_DEFAULT_SENDER {
+		sender = ""
+	}
+
+	if len(fields) > 1 {
+		if dateSent, err := time.ParseInLocation(MBOX_DATE_FORMAT, strings.TrimSpace(fields[1]), time.Local); err == nil {
+			return sender, dateSent
+		}
+	}
+
+	return sender, time.Now()
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+func readZipJSON(file *zip.File, value interface{}) error {
+	contents, err := readZipFile(file)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(contents, value)
+}
@@ -0,0 +1,176 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package mailextract
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/mailslurper/mailslurper/services/searchindex"
+)
+
+const (
+	// SOURCE_HTML marks a link found in an anchor of the HTML body
+	SOURCE_HTML string = "html"
+
+	// SOURCE_TEXT marks a link found in the plain text body
+	SOURCE_TEXT string = "text"
+
+	// TRAILING_PUNCTUATION is trimmed from the end of addresses found in plain text
+	TRAILING_PUNCTUATION string = ".,;:!?)]}>'\""
+)
+
+var anchorPattern = regexp.MustCompile(`(?is)<a\b([^>]*)>(.*?)</a\s*>`)
+var hrefPattern = regexp.MustCompile(`(?is)\bhref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+var altPattern = regexp.MustCompile(`(?is)\balt\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+var urlPattern = regexp.MustCompile(`(?i)\b(?:https?|ftp)://[^\s<>"]+`)
+
+/*
+MailExtractor finds the links in a mail item and the codes matching a
+set of code patterns. It is safe for concurrent use.
+*/
+type MailExtractor struct {
+	codePatterns []*compiledCodePattern
+}
+
+type compiledCodePattern struct {
+	name       string
+	expression *regexp.Regexp
+}
+
+/*
+NewMailExtractor creates a new MailExtractor object, returning an error
+when a code pattern is not a valid regular expression
+*/
+func NewMailExtractor(codePatterns []*model.CodePattern) (*MailExtractor, error) {
+	result := &MailExtractor{
+		codePatterns: make([]*compiledCodePattern, 0, len(codePatterns)),
+	}
+
+	for _, codePattern := range codePatterns {
+		expression, err := regexp.Compile(codePattern.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid code pattern '%s': %s", codePattern.Name, err.Error())
+		}
+
+		result.codePatterns = append(result.codePatterns, &compiledCodePattern{name: codePattern.Name, expression: expression})
+	}
+
+	return result, nil
+}
+
+/*
+Extract returns the links in a mail item's HTML and plain text bodies,
+and the codes found in its visible text and link addresses. Each link
+and code is listed once, in the order it first appears.
+*/
+func (extractor *MailExtractor) Extract(htmlBody, textBody string) *model.MailExtraction {
+	result := &model.MailExtraction{
+		Links: ExtractLinks(htmlBody, textBody),
+		Codes: make([]*model.MailCode, 0),
+	}
+
+	sources := []string{visibleText(htmlBody), textBody}
+	for _, link := range result.Links {
+		sources = append(sources, link.URL)
+	}
+
+	seen := make(map[string]bool)
+
+	for _, codePattern := range extractor.codePatterns {
+		for _, source := range sources {
+			for _, match := range codePattern.expression.FindAllStringSubmatch(source, -1) {
+				value := match[0]
+				if len(match) > 1 {
+					value = match[1]
+				}
+
+				key := codePattern.name + "\x00" + value
+				if value == "" || seen[key] {
+					continue
+				}
+
+				seen[key] = true
+				result.Codes = append(result.Codes, &model.MailCode{Name: codePattern.name, Value: value})
+			}
+		}
+	}
+
+	return result
+}
+
+/*
+ExtractLinks returns the anchors of an HTML body, with their visible
+text, followed by the web addresses written out in a plain text body.
+Page fragments and javascript:, vbscript: and data: links are skipped.
+*/
+func ExtractLinks(htmlBody, textBody string) []*model.MailLink {
+	result := make([]*model.MailLink, 0)
+	seen := make(map[string]bool)
+
+	add := func(link *model.MailLink) {
+		key := link.Source + "\x00" + link.URL + "\x00" + link.Text
+		if link.URL == "" || seen[key] {
+			return
+		}
+
+		seen[key] = true
+		result = append(result, link)
+	}
+
+	for _, anchor := range anchorPattern.FindAllStringSubmatch(htmlBody, -1) {
+		address := strings.TrimSpace(html.UnescapeString(firstGroup(hrefPattern.FindStringSubmatch(anchor[1]))))
+		if strings.HasPrefix(address, "#") || isScriptLink(address) {
+			continue
+		}
+
+		text := visibleText(anchor[2])
+		if text == "" {
+			text = strings.TrimSpace(html.UnescapeString(firstGroup(altPattern.FindStringSubmatch(anchor[2]))))
+		}
+
+		add(&model.MailLink{URL: address, Text: text, Source: SOURCE_HTML})
+	}
+
+	for _, address := range urlPattern.FindAllString(textBody, -1) {
+		add(&model.MailLink{URL: strings.TrimRight(address, TRAILING_PUNCTUATION), Source: SOURCE_TEXT})
+	}
+
+	return result
+}
+
+/*
+firstGroup returns the first capture group of a match which matched
+anything
+*/
+func firstGroup(match []string) string {
+	for index := 1; index < len(match); index++ {
+		if match[index] != "" {
+			return match[index]
+		}
+	}
+
+	return ""
+}
+
+func isScriptLink(address string) bool {
+	scheme := strings.ToLower(strings.Join(strings.Fields(address), ""))
+
+	for _, prefix := range []string{"javascript:", "vbscript:", "data:"} {
+		if strings.HasPrefix(scheme, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func visibleText(htmlBody string) string {
+	return searchindex.BodyText(&mailitem.MailItem{Body: htmlBody, ContentType: "text/html"})
+}
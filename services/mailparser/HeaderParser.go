@@ -0,0 +1,65 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package mailparser
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/mailslurper/mailslurper/model"
+)
+
+/*
+ParseHeaders returns every header of a raw RFC 5322 message in the
+order they appear, including repeated headers. Folded values are
+unfolded and encoded words are decoded. Lines in the header block that
+are not headers are skipped.
+*/
+func ParseHeaders(raw []byte) ([]*model.MailHeader, error) {
+	var current *model.MailHeader
+
+	result := make([]*model.MailHeader, 0)
+	reader := bufio.NewReader(bytes.NewReader(raw))
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return result, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" {
+			break
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if current != nil {
+				current.Value += line
+			}
+		} else if colon := strings.Index(line, ":"); colon > 0 && !strings.ContainsAny(line[:colon], " \t") {
+			current = &model.MailHeader{
+				Name:  line[:colon],
+				Value: line[colon+1:],
+			}
+
+			result = append(result, current)
+		} else {
+			current = nil
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	for _, header := range result {
+		header.Value = decodeHeader(strings.TrimSpace(header.Value))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,276 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package mailparser
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/mailslurper/libmailslurper/model/attachment"
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+)
+
+const (
+	// DATE_FORMAT is the format libmailslurper uses for the dateSent column
+	DATE_FORMAT string = "2006-01-02 15:04:05"
+)
+
+var headerDecoder = &mime.WordDecoder{}
+
+/*
+Parse turns a raw RFC 5322 message into a mail item. The envelope
+sender and recipients are used for the from and to addresses; when they
+are empty the From, To and Cc headers are used instead.
+*/
+func Parse(raw []byte, fromAddress string, toAddresses []string) (*mailitem.MailItem, error) {
+	var err error
+	var message *mail.Message
+
+	if message, err = mail.ReadMessage(bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+
+	result := &mailitem.MailItem{
+		DateSent:    time.Now().Format(DATE_FORMAT),
+		FromAddress: fromAddress,
+		ToAddresses: toAddresses,
+		Subject:     decodeHeader(message.Header.Get("Subject")),
+		XMailer:     message.Header.Get("X-Mailer"),
+		MIMEVersion: message.Header.Get("MIME-Version"),
+		ContentType: message.Header.Get("Content-Type"),
+		Attachments: make([]*attachment.Attachment, 0),
+	}
+
+	if result.FromAddress == "" {
+		result.FromAddress = decodeHeader(message.Header.Get("From"))
+	}
+
+	if len(result.ToAddresses) == 0 {
+		result.ToAddresses = headerAddresses(message.Header, "To", "Cc")
+	}
+
+	if result.ContentType == "" {
+		result.ContentType = "text/plain; charset=UTF-8"
+	}
+
+	htmlBody, textBody, err := parseBodies(message, result)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Body = htmlBody
+	if result.Body == "" {
+		result.Body = textBody
+	}
+
+	return result, nil
+}
+
+/*
+ParseBodies returns the HTML and plain text bodies of a raw RFC 5322
+message. Either is empty when the message does not have one.
+*/
+func ParseBodies(raw []byte) (string, string, error) {
+	message, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return "", "", err
+	}
+
+	mailItem := &mailitem.MailItem{
+		ContentType: message.Header.Get("Content-Type"),
+		Attachments: make([]*attachment.Attachment, 0),
+	}
+
+	if mailItem.ContentType == "" {
+		mailItem.ContentType = "text/plain; charset=UTF-8"
+	}
+
+	return parseBodies(message, mailItem)
+}
+
+/*
+parseBodies reads the HTML and text bodies of a message whose content
+type is mailItem.ContentType, setting the boundary and collecting any
+attachments on mailItem.
+*/
+func parseBodies(message *mail.Message, mailItem *mailitem.MailItem) (string, string, error) {
+	var htmlBody, textBody string
+
+	mediaType, params, err := mime.ParseMediaType(mailItem.ContentType)
+	if err != nil {
+		mediaType = "text/plain"
+		params = map[string]string{}
+	}
+
+	mailItem.Boundary = params["boundary"]
+
+	if strings.HasPrefix(mediaType, "multipart/") && mailItem.Boundary != "" {
+		err = parseMultipart(message.Body, mailItem.Boundary, &htmlBody, &textBody, mailItem)
+		return htmlBody, textBody, err
+	}
+
+	body, err := decodeBody(message.Body, message.Header.Get("Content-Transfer-Encoding"))
+	if err != nil {
+		return "", "", err
+	}
+
+	if mediaType == "text/html" {
+		return string(body), "", nil
+	}
+
+	return "", string(body), nil
+}
+
+/*
+parseMultipart walks a multipart body, keeping the first HTML and text
+parts as the body and collecting everything else as attachments.
+*/
+func parseMultipart(body io.Reader, boundary string, htmlBody, textBody *string, mailItem *mailitem.MailItem) error {
+	reader := multipart.NewReader(body, boundary)
+
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		contentType := part.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "text/plain"
+		}
+
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			mediaType = "application/octet-stream"
+			params = map[string]string{}
+		}
+
+		contentDisposition := part.Header.Get("Content-Disposition")
+		disposition, dispositionParams, _ := mime.ParseMediaType(contentDisposition)
+
+		fileName := decodeHeader(dispositionParams["filename"])
+		if fileName == "" {
+			fileName = decodeHeader(params["name"])
+		}
+
+		if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+			if err = parseMultipart(part, params["boundary"], htmlBody, textBody, mailItem); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		transferEncoding := part.Header.Get("Content-Transfer-Encoding")
+		isBodyPart := disposition != "attachment" && fileName == "" && strings.HasPrefix(mediaType, "text/")
+
+		if isBodyPart && ((mediaType == "text/html" && *htmlBody == "") || (mediaType != "text/html" && *textBody == "")) {
+			decoded, err := decodeBody(part, transferEncoding)
+			if err != nil {
+				return err
+			}
+
+			if mediaType == "text/html" {
+				*htmlBody = string(decoded)
+			} else {
+				*textBody = string(decoded)
+			}
+
+			continue
+		}
+
+		contents, err := ioutil.ReadAll(part)
+		if err != nil {
+			return err
+		}
+
+		if fileName == "" {
+			fileName = "attachment"
+		}
+
+		mailItem.Attachments = append(mailItem.Attachments, &attachment.Attachment{
+			Headers: &attachment.AttachmentHeader{
+				ContentType:             contentType,
+				MIMEVersion:             part.Header.Get("MIME-Version"),
+				ContentTransferEncoding: transferEncoding,
+				ContentDisposition:      contentDisposition,
+				FileName:                fileName,
+			},
+			Contents: string(contents),
+		})
+	}
+}
+
+func decodeBody(body io.Reader, transferEncoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(transferEncoding)) {
+	case "base64":
+		return ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, &newlineStripper{reader: body}))
+
+	case "quoted-printable":
+		return ioutil.ReadAll(quotedprintable.NewReader(body))
+	}
+
+	return ioutil.ReadAll(body)
+}
+
+func decodeHeader(value string) string {
+	decoded, err := headerDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+
+	return decoded
+}
+
+func headerAddresses(header mail.Header, names ...string) []string {
+	result := make([]string, 0)
+
+	for _, name := range names {
+		addresses, err := header.AddressList(name)
+		if err != nil {
+			continue
+		}
+
+		for _, address := range addresses {
+			result = append(result, address.Address)
+		}
+	}
+
+	return result
+}
+
+/*
+newlineStripper removes line breaks so base64 content split across
+lines can be decoded.
+*/
+type newlineStripper struct {
+	reader io.Reader
+}
+
+func (stripper *newlineStripper) Read(buffer []byte) (int, error) {
+	count, err := stripper.reader.Read(buffer)
+	kept := 0
+
+	for _, character := range buffer[:count] {
+		if character != '\r' && character != '\n' && character != ' ' && character != '\t' {
+			buffer[kept] = character
+			kept++
+		}
+	}
+
+	return kept, err
+}
@@ -0,0 +1,236 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package mailparser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/*
+message joins lines with CRLF
+*/
+func message(lines ...string) []byte {
+	return []byte(strings.Join(lines, "\r\n"))
+}
+
+func TestParse(t *testing.T) {
+	type expectedAttachment struct {
+		fileName         string
+		contentType      string
+		transferEncoding string
+		contents         string
+	}
+
+	tests := []struct {
+		name        string
+		raw         []byte
+		from        string
+		to          []string
+		subject     string
+		fromAddress string
+		toAddresses []string
+		body        string
+		boundary    string
+		attachments []expectedAttachment
+	}{
+		{
+			name: "plain text",
+			raw: message(
+				"From: Sender <sender@example.com>",
+				"To: user@example.com",
+				"Subject: Hello",
+				"",
+				"Hello there",
+			),
+			from:        "envelope@example.com",
+			to:          []string{"rcpt@example.com"},
+			subject:     "Hello",
+			fromAddress: "envelope@example.com",
+			toAddresses: []string{"rcpt@example.com"},
+			body:        "Hello there",
+		},
+		{
+			name: "addresses from the headers without an envelope",
+			raw: message(
+				"From: Sender <sender@example.com>",
+				"To: One <one@example.com>, two@example.com",
+				"Cc: three@example.com",
+				"Subject: =?UTF-8?B?Q2Fmw6k=?=",
+				"",
+				"Body",
+			),
+			subject:     "Café",
+			fromAddress: "Sender <sender@example.com>",
+			toAddresses: []string{"one@example.com", "two@example.com", "three@example.com"},
+			body:        "Body",
+		},
+		{
+			name: "quoted-printable",
+			raw: message(
+				"Subject: QP",
+				"Content-Type: text/plain; charset=UTF-8",
+				"Content-Transfer-Encoding: quoted-printable",
+				"",
+				"Caf=C3=A9 au lait, a very long line that is wrapped by a soft =",
+				"line break",
+			),
+			subject:     "QP",
+			toAddresses: []string{},
+			body:        "Café au lait, a very long line that is wrapped by a soft line break",
+		},
+		{
+			name: "base64 HTML split across lines",
+			raw: message(
+				"Subject: Base64",
+				"Content-Type: text/html; charset=UTF-8",
+				"Content-Transfer-Encoding: base64",
+				"",
+				"PHA+SGVsbG8g",
+				"PGI+d29ybGQ8L2I+PC9wPg==",
+			),
+			subject:     "Base64",
+			toAddresses: []string{},
+			body:        "<p>Hello <b>world</b></p>",
+		},
+		{
+			name: "multipart alternative prefers HTML",
+			raw: message(
+				"Subject: Alternative",
+				"MIME-Version: 1.0",
+				"Content-Type: multipart/alternative; boundary=\"alt\"",
+				"",
+				"--alt",
+				"Content-Type: text/plain",
+				"",
+				"Plain",
+				"--alt",
+				"Content-Type: text/html",
+				"Content-Transfer-Encoding: quoted-printable",
+				"",
+				"<p>HTML =3D rich</p>",
+				"--alt--",
+				"",
+			),
+			subject:     "Alternative",
+			toAddresses: []string{},
+			body:        "<p>HTML = rich</p>",
+			boundary:    "alt",
+		},
+		{
+			name: "nested multipart with attachments",
+			raw: message(
+				"Subject: Mixed",
+				"MIME-Version: 1.0",
+				"Content-Type: multipart/mixed; boundary=\"mixed\"",
+				"",
+				"--mixed",
+				"Content-Type: multipart/alternative; boundary=\"alt\"",
+				"",
+				"--alt",
+				"Content-Type: text/plain",
+				"",
+				"Only text",
+				"--alt--",
+				"--mixed",
+				"Content-Type: application/pdf; name=\"report.pdf\"",
+				"Content-Disposition: attachment; filename=\"report.pdf\"",
+				"Content-Transfer-Encoding: base64",
+				"",
+				"JVBERi0xLjQ=",
+				"--mixed",
+				"Content-Type: text/plain",
+				"Content-Disposition: attachment",
+				"",
+				"notes",
+				"--mixed--",
+				"",
+			),
+			subject:     "Mixed",
+			toAddresses: []string{},
+			body:        "Only text",
+			boundary:    "mixed",
+			attachments: []expectedAttachment{
+				{fileName: "report.pdf", contentType: "application/pdf; name=\"report.pdf\"", transferEncoding: "base64", contents: "JVBERi0xLjQ="},
+				{fileName: "attachment", contentType: "text/plain", contents: "notes"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		mailItem, err := Parse(test.raw, test.from, test.to)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+			continue
+		}
+
+		if mailItem.Subject != test.subject {
+			t.Errorf("%s: expected subject %q, got %q", test.name, test.subject, mailItem.Subject)
+		}
+
+		if mailItem.FromAddress != test.fromAddress {
+			t.Errorf("%s: expected from address %q, got %q", test.name, test.fromAddress, mailItem.FromAddress)
+		}
+
+		if !reflect.DeepEqual([]string(mailItem.ToAddresses), test.toAddresses) {
+			t.Errorf("%s: expected to addresses %v, got %v", test.name, test.toAddresses, mailItem.ToAddresses)
+		}
+
+		if mailItem.Body != test.body {
+			t.Errorf("%s: expected body %q, got %q", test.name, test.body, mailItem.Body)
+		}
+
+		if mailItem.Boundary != test.boundary {
+			t.Errorf("%s: expected boundary %q, got %q", test.name, test.boundary, mailItem.Boundary)
+		}
+
+		if len(mailItem.Attachments) != len(test.attachments) {
+			t.Errorf("%s: expected %d attachment(s), got %d", test.name, len(test.attachments), len(mailItem.Attachments))
+			continue
+		}
+
+		for index, expected := range test.attachments {
+			actual := mailItem.Attachments[index]
+
+			if actual.Headers.FileName != expected.fileName || actual.Headers.ContentType != expected.contentType || actual.Headers.ContentTransferEncoding != expected.transferEncoding || actual.Contents != expected.contents {
+				t.Errorf("%s: attachment %d: expected %+v, got %+v with contents %q", test.name, index, expected, actual.Headers, actual.Contents)
+			}
+		}
+	}
+}
+
+func TestParseInvalidMessage(t *testing.T) {
+	if _, err := Parse([]byte("not a header\r\n\r\nbody"), "", nil); err == nil {
+		t.Errorf("expected an error for a malformed header")
+	}
+}
+
+func TestParseBodies(t *testing.T) {
+	raw := message(
+		"Content-Type: multipart/alternative; boundary=\"alt\"",
+		"",
+		"--alt",
+		"Content-Type: text/plain",
+		"Content-Transfer-Encoding: base64",
+		"",
+		"UGxhaW4=",
+		"--alt",
+		"Content-Type: text/html",
+		"",
+		"<p>HTML</p>",
+		"--alt--",
+		"",
+	)
+
+	htmlBody, textBody, err := ParseBodies(raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if htmlBody != "<p>HTML</p>" || textBody != "Plain" {
+		t.Errorf("expected both bodies, got %q and %q", htmlBody, textBody)
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package mailquery
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+	"github.com/mailslurper/libmailslurper/model/search"
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+	"github.com/mailslurper/mailslurper/services/searchindex"
+)
+
+const (
+	// ORDER_BY_RELEVANCE sorts full-text search results best match first
+	ORDER_BY_RELEVANCE string = "relevance"
+
+	// HEADER_PARAMETER_PREFIX starts the name of a query string parameter matching a header
+	HEADER_PARAMETER_PREFIX string = "header."
+)
+
+/*
+MailQuery describes a search for mail items. Search holds the criteria
+libmailslurper storage understands; the remaining fields are applied
+by MailSlurper on top of those results.
+
+Text is matched against the full-text search index. Its results are
+ordered by relevance unless another order is asked for. Each of Headers
+must be present on a mail item; a header with an empty value matches
+any value.
+*/
+type MailQuery struct {
+	Search      *search.MailSearch
+	Tag         string
+	Text        string
+	ByRelevance bool
+	Headers     []*model.MailHeader
+
+	ranks map[string]float64
+}
+
+/*
+NewMailQueryFromValues reads a MailQuery from query string values. The
+names match those accepted by the GET /mail service endpoint. "message"
+matches part of the subject or body as libmailslurper does, "text" is a
+full-text search, and "orderby" accepts "relevance" on top of the
+fields libmailslurper sorts by. Parameters named header.<name>, such as
+header.X-Request-Id=abc, match the value of a header exactly.
+*/
+func NewMailQueryFromValues(values url.Values) *MailQuery {
+	result := &MailQuery{
+		Search: &search.MailSearch{
+			Message:          values.Get("message"),
+			Start:            values.Get("start"),
+			End:              values.Get("end"),
+			From:             values.Get("from"),
+			To:               values.Get("to"),
+			OrderByField:     values.Get("orderby"),
+			OrderByDirection: values.Get("dir"),
+		},
+		Tag:     values.Get("tag"),
+		Text:    values.Get("text"),
+		Headers: make([]*model.MailHeader, 0),
+	}
+
+	for name, headerValues := range values {
+		if !strings.HasPrefix(strings.ToLower(name), HEADER_PARAMETER_PREFIX) || len(name) == len(HEADER_PARAMETER_PREFIX) {
+			continue
+		}
+
+		for _, value := range headerValues {
+			result.Headers = append(result.Headers, &model.MailHeader{Name: name[len(HEADER_PARAMETER_PREFIX):], Value: value})
+		}
+	}
+
+	if result.Search.OrderByField == ORDER_BY_RELEVANCE || (result.Text != "" && result.Search.OrderByField == "") {
+		result.ByRelevance = result.Text != ""
+		result.Search.OrderByField = "date"
+	}
+
+	return result
+}
+
+/*
+Execute returns up to length mail items starting at offset, along with
+the total number of mail items matching the query.
+*/
+func (query *MailQuery) Execute(database storage.IStorage, mailStore mailstore.IMailStore, offset, length int) ([]mailitem.MailItem, int, error) {
+	if !query.needsFiltering() {
+		totalRecordCount, err := database.GetMailCount(query.Search)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		mailItems, err := database.GetMailCollection(offset, length, query.Search)
+		return mailItems, totalRecordCount, err
+	}
+
+	return query.filter(database, mailStore, offset, length)
+}
+
+/*
+Highlights returns a snippet and rank for each mail item, keyed by mail
+item ID, explaining why it matched the full-text search. It is empty
+when the query has no search text.
+*/
+func (query *MailQuery) Highlights(mailStore mailstore.IMailStore, mailItems []mailitem.MailItem) (map[string]*model.SearchHighlight, error) {
+	result := make(map[string]*model.SearchHighlight)
+
+	if query.Text == "" {
+		return result, nil
+	}
+
+	terms := mailstore.SearchTerms(query.Text)
+
+	for _, mailItem := range mailItems {
+		document, err := mailStore.GetSearchDocument(mailItem.ID)
+		if err != nil {
+			return result, err
+		}
+
+		if document == nil {
+			continue
+		}
+
+		result[mailItem.ID] = &model.SearchHighlight{
+			Rank:    query.ranks[mailItem.ID],
+			Snippet: searchindex.Highlight(document, terms),
+		}
+	}
+
+	return result, nil
+}
+
+func (query *MailQuery) needsFiltering() bool {
+	return query.Tag != "" || query.Text != "" || len(query.Headers) > 0
+}
+
+/*
+filter narrows the IDs of the mail items matching the storage criteria
+down to those that also match the MailSlurper criteria, preserving
+order unless ordering by relevance. Only the requested page of mail
+items is read.
+*/
+func (query *MailQuery) filter(database storage.IStorage, mailStore mailstore.IMailStore, offset, length int) ([]mailitem.MailItem, int, error) {
+	mailIDs, err := mailStore.GetMailIDs(query.Search)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var taggedIDs map[string]bool
+
+	if query.Tag != "" {
+		if taggedIDs, err = mailStore.GetMailIDsByTag(query.Tag); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	headerIDs := make([]map[string]bool, 0, len(query.Headers))
+
+	for _, header := range query.Headers {
+		ids, err := mailStore.GetMailIDsByHeader(header.Name, header.Value)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		headerIDs = append(headerIDs, ids)
+	}
+
+	if query.Text != "" {
+		matches, err := mailStore.SearchMail(query.Text)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		query.ranks = make(map[string]float64, len(matches))
+
+		for _, match := range matches {
+			query.ranks[match.MailItemID] = match.Rank
+		}
+	}
+
+	matchingIDs := make([]string, 0)
+
+	for _, mailID := range mailIDs {
+		if query.Tag != "" && !taggedIDs[mailID] {
+			continue
+		}
+
+		if _, ok := query.ranks[mailID]; query.Text != "" && !ok {
+			continue
+		}
+
+		if !hasEveryHeader(mailID, headerIDs) {
+			continue
+		}
+
+		matchingIDs = append(matchingIDs, mailID)
+	}
+
+	if query.ByRelevance {
+		sort.SliceStable(matchingIDs, func(i, j int) bool { return query.ranks[matchingIDs[i]] > query.ranks[matchingIDs[j]] })
+	}
+
+	if offset > len(matchingIDs) {
+		offset = len(matchingIDs)
+	}
+
+	end := offset + length
+	if end > len(matchingIDs) {
+		end = len(matchingIDs)
+	}
+
+	result := make([]mailitem.MailItem, 0, end-offset)
+
+	for _, mailID := range matchingIDs[offset:end] {
+		mailItem, err := database.GetMailByID(mailID)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		result = append(result, mailItem)
+	}
+
+	return result, len(matchingIDs), nil
+}
+
+func hasEveryHeader(mailID string, headerIDs []map[string]bool) bool {
+	for _, mailIDs := range headerIDs {
+		if !mailIDs[mailID] {
+			return false
+		}
+	}
+
+	return true
+}
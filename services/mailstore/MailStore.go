@@ -0,0 +1,843 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package mailstore
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mailslurper/libmailslurper/configuration"
+	"github.com/mailslurper/libmailslurper/model/search"
+	"github.com/mailslurper/mailslurper/model"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// LIKE_ESCAPE is the escape clause for patterns made by likePattern
+	LIKE_ESCAPE string = " ESCAPE '!'"
+
+	// DELETE_BATCH_SIZE is how many mail item IDs DeleteMails puts in one statement
+	DELETE_BATCH_SIZE int = 500
+)
+
+/*
+mailDataTables are the tables MailSlurper keeps alongside each mail item,
+apart from the search index
+*/
+var mailDataTables = []string{"mailmetadata", "mailsession", "mailraw", "attachmentref", "mailheader"}
+
+/*
+IMailStore describes operations on captured mail that the libmailslurper
+storage engines do not provide.
+*/
+type IMailStore interface {
+	CountAttachmentReferences(hashes []string) (map[string]int, error)
+	DeleteMail(mailID string) error
+	DeleteMailDataAfterDate(startDate string) error
+	DeleteMails(mailIDs []string) (int, error)
+	Disconnect()
+	GetAttachmentReferences(mailID string) ([]string, error)
+	GetMailboxes() ([]*model.Mailbox, error)
+	GetMailHeaders(mailID string) ([]*model.MailHeader, error)
+	GetMailIDs(mailSearch *search.MailSearch) ([]string, error)
+	GetMailIDsByHeader(name, value string) (map[string]bool, error)
+	GetMailIDsByTag(tag string) (map[string]bool, error)
+	GetMailMetadata(mailID string) (*model.MailMetadata, error)
+	GetMailSizes() ([]*model.MailSize, error)
+	GetRawMessage(mailID string) ([]byte, error)
+	GetSearchDocument(mailID string) (*model.SearchDocument, error)
+	GetSMTPSession(mailID string) (*model.SMTPSessionRecord, error)
+	GetUnindexedMailIDs() ([]string, error)
+	IndexMail(document *model.SearchDocument) error
+	PruneAttachmentReferences() (map[string]int, error)
+	SearchMail(text string) ([]*model.SearchMatch, error)
+	StoreAttachmentReferences(mailID string, hashes []string) error
+	StoreMailHeaders(mailID string, headers []*model.MailHeader) error
+	StoreMailMetadata(metadata *model.MailMetadata) error
+	StoreRawMessage(mailID string, contents []byte) error
+	StoreSMTPSession(session *model.SMTPSessionRecord) error
+}
+
+/*
+SQLMailStore implements IMailStore directly against the SQLite, MySQL,
+MSSQL or PostgreSQL database holding the mail items.
+*/
+type SQLMailStore struct {
+	db     *sql.DB
+	engine string
+}
+
+/*
+NewMailStore opens a connection to the database described in the
+configuration. The schema is expected to be up to date; see the
+migration package.
+*/
+func NewMailStore(config *configuration.Configuration) (IMailStore, error) {
+	db, engine, err := OpenDatabase(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQLMailStore{
+		db:     db,
+		engine: engine,
+	}, nil
+}
+
+/*
+OpenDatabase connects to the database described in the configuration.
+It returns the connection and the name of the engine: "sqlite",
+"mysql", "mssql" or "postgres".
+*/
+func OpenDatabase(config *configuration.Configuration) (*sql.DB, string, error) {
+	var driverName string
+	var dataSourceName string
+
+	engine := strings.ToLower(config.DBEngine)
+
+	switch engine {
+	case "sqlite":
+		driverName = "sqlite3"
+		dataSourceName = config.DBDatabase
+
+	case "mysql":
+		driverName = "mysql"
+		dataSourceName = fmt.Sprintf("%s:%s@tcp(%s:%d)/%s", config.DBUserName, config.DBPassword, config.DBHost, config.DBPort, config.DBDatabase)
+
+	case "mssql":
+		driverName = "mssql"
+		dataSourceName = fmt.Sprintf("server=%s;user id=%s;password=%s;port=%d;database=%s", config.DBHost, config.DBUserName, config.DBPassword, config.DBPort, config.DBDatabase)
+
+	case "postgres", "postgresql":
+		engine = "postgres"
+		driverName = "postgres"
+		dataSourceName = postgresDataSourceName(config)
+
+	default:
+		return nil, "", fmt.Errorf("Unsupported database engine '%s'", config.DBEngine)
+	}
+
+	db, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, "", err
+	}
+
+	return db, engine, nil
+}
+
+/*
+CountAttachmentReferences returns how many references are left to each
+of the given attachment blobs
+*/
+func (store *SQLMailStore) CountAttachmentReferences(hashes []string) (map[string]int, error) {
+	result := make(map[string]int)
+
+	for _, hash := range hashes {
+		var count int
+
+		if err := store.db.QueryRow(store.rebind("SELECT COUNT(*) FROM attachmentref WHERE attachmentHash=?"), hash).Scan(&count); err != nil {
+			return result, err
+		}
+
+		result[hash] = count
+	}
+
+	return result, nil
+}
+
+/*
+DeleteMail removes a single mail item and its attachments
+*/
+func (store *SQLMailStore) DeleteMail(mailID string) error {
+	_, err := store.DeleteMails([]string{mailID})
+	return err
+}
+
+/*
+DeleteMailDataAfterDate removes what MailSlurper recorded alongside the
+mail sent on or before startDate, leaving the mail items themselves to
+the storage engine. Like the engines, an empty date means all mail. It
+has to be called before the storage engine prunes the same mail items.
+*/
+func (store *SQLMailStore) DeleteMailDataAfterDate(startDate string) error {
+	var err error
+	var transaction *sql.Tx
+
+	mailItems := "SELECT id FROM mailitem"
+	parameters := make([]interface{}, 0, 1)
+
+	if startDate != "" {
+		cutoff, err := store.searchDate(startDate)
+		if err != nil {
+			return err
+		}
+
+		mailItems += " WHERE dateSent <= ?"
+		parameters = append(parameters, cutoff)
+	}
+
+	if transaction, err = store.db.Begin(); err != nil {
+		return err
+	}
+
+	if err = store.deleteMailData(transaction, mailItems, parameters); err != nil {
+		transaction.Rollback()
+		return err
+	}
+
+	return transaction.Commit()
+}
+
+/*
+DeleteMails removes mail items, their attachments and everything
+recorded with them in one transaction, and returns how many mail items
+were removed. IDs of mail items that do not exist are ignored.
+*/
+func (store *SQLMailStore) DeleteMails(mailIDs []string) (int, error) {
+	var err error
+	var transaction *sql.Tx
+
+	removed := 0
+
+	if transaction, err = store.db.Begin(); err != nil {
+		return 0, err
+	}
+
+	for start := 0; start < len(mailIDs); start += DELETE_BATCH_SIZE {
+		end := start + DELETE_BATCH_SIZE
+		if end > len(mailIDs) {
+			end = len(mailIDs)
+		}
+
+		mailItems := strings.TrimSuffix(strings.Repeat("?, ", end-start), ", ")
+		parameters := make([]interface{}, 0, end-start)
+
+		for _, mailID := range mailIDs[start:end] {
+			parameters = append(parameters, mailID)
+		}
+
+		if _, err = transaction.Exec(store.rebind("DELETE FROM attachment WHERE mailItemId IN ("+mailItems+")"), parameters...); err != nil {
+			transaction.Rollback()
+			return 0, err
+		}
+
+		if err = store.deleteMailData(transaction, mailItems, parameters); err != nil {
+			transaction.Rollback()
+			return 0, err
+		}
+
+		result, err := transaction.Exec(store.rebind("DELETE FROM mailitem WHERE id IN ("+mailItems+")"), parameters...)
+		if err != nil {
+			transaction.Rollback()
+			return 0, err
+		}
+
+		count, err := result.RowsAffected()
+		if err != nil {
+			transaction.Rollback()
+			return 0, err
+		}
+
+		removed += int(count)
+	}
+
+	return removed, transaction.Commit()
+}
+
+/*
+GetAttachmentReferences returns the attachment blobs a mail item refers
+to, each listed once
+*/
+func (store *SQLMailStore) GetAttachmentReferences(mailID string) ([]string, error) {
+	result := make([]string, 0)
+
+	rows, err := store.db.Query(store.rebind("SELECT DISTINCT attachmentHash FROM attachmentref WHERE mailItemId=?"), mailID)
+	if err != nil {
+		return result, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+
+		if err = rows.Scan(&hash); err != nil {
+			return result, err
+		}
+
+		result = append(result, hash)
+	}
+
+	return result, rows.Err()
+}
+
+/*
+GetMailboxes returns each distinct mail item tag with the number of
+mail items carrying it. Untagged mail is not a mailbox.
+*/
+func (store *SQLMailStore) GetMailboxes() ([]*model.Mailbox, error) {
+	result := make([]*model.Mailbox, 0)
+
+	rows, err := store.db.Query(store.rebind("SELECT tag, COUNT(*) FROM mailmetadata WHERE tag IS NOT NULL AND tag <> '' GROUP BY tag ORDER BY tag"))
+	if err != nil {
+		return result, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		mailbox := &model.Mailbox{}
+
+		if err = rows.Scan(&mailbox.Tag, &mailbox.MailCount); err != nil {
+			return result, err
+		}
+
+		result = append(result, mailbox)
+	}
+
+	return result, rows.Err()
+}
+
+/*
+GetMailHeaders returns the headers of a mail item in the order they
+appeared. Mail captured before headers were stored has none.
+*/
+func (store *SQLMailStore) GetMailHeaders(mailID string) ([]*model.MailHeader, error) {
+	result := make([]*model.MailHeader, 0)
+
+	rows, err := store.db.Query(store.rebind("SELECT headerName, headerValue FROM mailheader WHERE mailItemId=? ORDER BY headerIndex"), mailID)
+	if err != nil {
+		return result, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		header := &model.MailHeader{}
+
+		if err = rows.Scan(&header.Name, &header.Value); err != nil {
+			return result, err
+		}
+
+		result = append(result, header)
+	}
+
+	return result, rows.Err()
+}
+
+/*
+GetMailIDs returns the IDs of the mail items matching the search
+criteria, in the order the storage engine would return them. Only the
+IDs are read, so callers can narrow the list down further before
+reading any mail items.
+*/
+func (store *SQLMailStore) GetMailIDs(mailSearch *search.MailSearch) ([]string, error) {
+	result := make([]string, 0)
+
+	where, parameters, err := store.searchCriteria(mailSearch)
+	if err != nil {
+		return result, err
+	}
+
+	rows, err := store.db.Query(store.rebind("SELECT id FROM mailitem"+where+" ORDER BY "+searchOrderBy(mailSearch)), parameters...)
+	if err != nil {
+		return result, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var mailID string
+
+		if err = rows.Scan(&mailID); err != nil {
+			return result, err
+		}
+
+		result = append(result, mailID)
+	}
+
+	return result, rows.Err()
+}
+
+/*
+GetMailIDsByHeader returns the set of mail item IDs with a header whose
+value is exactly value. Header names are not case sensitive. An empty
+value matches every mail item carrying the header.
+*/
+func (store *SQLMailStore) GetMailIDsByHeader(name, value string) (map[string]bool, error) {
+	result := make(map[string]bool)
+
+	query := "SELECT mailItemId FROM mailheader WHERE headerKey=?"
+	parameters := []interface{}{strings.ToLower(name)}
+
+	if value != "" {
+		query += " AND headerValue=?"
+		parameters = append(parameters, value)
+	}
+
+	rows, err := store.db.Query(store.rebind(query), parameters...)
+	if err != nil {
+		return result, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var mailID string
+
+		if err = rows.Scan(&mailID); err != nil {
+			return result, err
+		}
+
+		result[mailID] = true
+	}
+
+	return result, rows.Err()
+}
+
+/*
+GetMailIDsByTag returns the set of mail item IDs carrying a tag
+*/
+func (store *SQLMailStore) GetMailIDsByTag(tag string) (map[string]bool, error) {
+	result := make(map[string]bool)
+
+	rows, err := store.db.Query(store.rebind("SELECT mailItemId FROM mailmetadata WHERE tag=?"), tag)
+	if err != nil {
+		return result, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var mailID string
+
+		if err = rows.Scan(&mailID); err != nil {
+			return result, err
+		}
+
+		result[mailID] = true
+	}
+
+	return result, rows.Err()
+}
+
+/*
+GetMailMetadata returns the receive details for a mail item. Mail
+captured before metadata was recorded gets an empty record.
+*/
+func (store *SQLMailStore) GetMailMetadata(mailID string) (*model.MailMetadata, error) {
+	result := &model.MailMetadata{
+		MailItemID: mailID,
+	}
+
+	err := store.db.QueryRow(store.rebind("SELECT tlsVersion, tlsCipher, authUser, tag FROM mailmetadata WHERE mailItemId=?"), mailID).Scan(&result.TLSVersion, &result.TLSCipher, &result.AuthUser, &result.Tag)
+	if err == sql.ErrNoRows {
+		return result, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+/*
+GetMailSizes returns the storage used by every mail item, oldest first
+*/
+func (store *SQLMailStore) GetMailSizes() ([]*model.MailSize, error) {
+	result := make([]*model.MailSize, 0)
+	length := store.lengthFunction()
+
+	query := fmt.Sprintf(`
+		SELECT
+			mailitem.id,
+			COALESCE(
+				(SELECT %[1]s(content) FROM mailraw WHERE mailraw.mailItemId=mailitem.id),
+				COALESCE(%[1]s(mailitem.body), 0) + COALESCE((SELECT SUM(%[1]s(content)) FROM attachment WHERE attachment.mailItemId=mailitem.id), 0)
+			)
+		FROM mailitem
+		ORDER BY mailitem.dateSent
+	`, length)
+
+	rows, err := store.db.Query(query)
+	if err != nil {
+		return result, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		mailSize := &model.MailSize{}
+
+		if err = rows.Scan(&mailSize.MailItemID, &mailSize.Size); err != nil {
+			return result, err
+		}
+
+		result = append(result, mailSize)
+	}
+
+	return result, rows.Err()
+}
+
+/*
+GetRawMessage returns the message exactly as it was received, or nil
+when it was not kept
+*/
+func (store *SQLMailStore) GetRawMessage(mailID string) ([]byte, error) {
+	var result []byte
+
+	err := store.db.QueryRow(store.rebind("SELECT content FROM mailraw WHERE mailItemId=?"), mailID).Scan(&result)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return result, err
+}
+
+/*
+GetSMTPSession returns the SMTP session record for a mail item, or nil
+when the mail item did not arrive over SMTP
+*/
+func (store *SQLMailStore) GetSMTPSession(mailID string) (*model.SMTPSessionRecord, error) {
+	var rcptTo string
+	var transcript string
+
+	result := &model.SMTPSessionRecord{
+		MailItemID: mailID,
+	}
+
+	err := store.db.QueryRow(
+		store.rebind("SELECT clientIp, clientPort, helo, mailFrom, rcptTo, authUser, tlsVersion, tlsCipher, startedAt, transcript FROM mailsession WHERE mailItemId=?"),
+		mailID,
+	).Scan(
+		&result.ClientIP,
+		&result.ClientPort,
+		&result.Helo,
+		&result.MailFrom,
+		&rcptTo,
+		&result.AuthUser,
+		&result.TLSVersion,
+		&result.TLSCipher,
+		&result.StartedAt,
+		&transcript,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal([]byte(rcptTo), &result.RcptTo); err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal([]byte(transcript), &result.Transcript); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+/*
+PruneAttachmentReferences drops the attachment references of mail items
+that no longer exist, then returns how many references are left to each
+attachment blob.
+*/
+func (store *SQLMailStore) PruneAttachmentReferences() (map[string]int, error) {
+	result := make(map[string]int)
+
+	if _, err := store.db.Exec("DELETE FROM attachmentref WHERE mailItemId NOT IN (SELECT id FROM mailitem)"); err != nil {
+		return result, err
+	}
+
+	rows, err := store.db.Query("SELECT attachmentHash, COUNT(*) FROM attachmentref GROUP BY attachmentHash")
+	if err != nil {
+		return result, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		var count int
+
+		if err = rows.Scan(&hash, &count); err != nil {
+			return result, err
+		}
+
+		result[hash] = count
+	}
+
+	return result, rows.Err()
+}
+
+/*
+StoreAttachmentReferences records that a mail item refers to attachment
+blobs. A hash is listed once for each attachment using it.
+*/
+func (store *SQLMailStore) StoreAttachmentReferences(mailID string, hashes []string) error {
+	var err error
+	var transaction *sql.Tx
+
+	if transaction, err = store.db.Begin(); err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		if _, err = transaction.Exec(store.rebind("INSERT INTO attachmentref (mailItemId, attachmentHash) VALUES (?, ?)"), mailID, hash); err != nil {
+			transaction.Rollback()
+			return err
+		}
+	}
+
+	return transaction.Commit()
+}
+
+/*
+StoreMailHeaders records the headers of a mail item, keeping their
+order
+*/
+func (store *SQLMailStore) StoreMailHeaders(mailID string, headers []*model.MailHeader) error {
+	var err error
+	var transaction *sql.Tx
+
+	if transaction, err = store.db.Begin(); err != nil {
+		return err
+	}
+
+	for index, header := range headers {
+		if _, err = transaction.Exec(store.rebind("INSERT INTO mailheader (mailItemId, headerIndex, headerName, headerKey, headerValue) VALUES (?, ?, ?, ?, ?)"), mailID, index, header.Name, strings.ToLower(header.Name), header.Value); err != nil {
+			transaction.Rollback()
+			return err
+		}
+	}
+
+	return transaction.Commit()
+}
+
+/*
+StoreMailMetadata records the receive details for a mail item
+*/
+func (store *SQLMailStore) StoreMailMetadata(metadata *model.MailMetadata) error {
+	_, err := store.db.Exec(
+		store.rebind("INSERT INTO mailmetadata (mailItemId, tlsVersion, tlsCipher, authUser, tag) VALUES (?, ?, ?, ?, ?)"),
+		metadata.MailItemID,
+		metadata.TLSVersion,
+		metadata.TLSCipher,
+		metadata.AuthUser,
+		metadata.Tag,
+	)
+
+	return err
+}
+
+/*
+StoreRawMessage keeps the message exactly as it was received
+*/
+func (store *SQLMailStore) StoreRawMessage(mailID string, contents []byte) error {
+	_, err := store.db.Exec(store.rebind("INSERT INTO mailraw (mailItemId, content) VALUES (?, ?)"), mailID, contents)
+	return err
+}
+
+/*
+StoreSMTPSession records the SMTP session a mail item arrived in. The
+recipient list and transcript are stored as JSON.
+*/
+func (store *SQLMailStore) StoreSMTPSession(session *model.SMTPSessionRecord) error {
+	rcptTo, err := json.Marshal(session.RcptTo)
+	if err != nil {
+		return err
+	}
+
+	transcript, err := json.Marshal(session.Transcript)
+	if err != nil {
+		return err
+	}
+
+	_, err = store.db.Exec(
+		store.rebind("INSERT INTO mailsession (mailItemId, clientIp, clientPort, helo, mailFrom, rcptTo, authUser, tlsVersion, tlsCipher, startedAt, transcript) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		session.MailItemID,
+		session.ClientIP,
+		session.ClientPort,
+		session.Helo,
+		session.MailFrom,
+		string(rcptTo),
+		session.AuthUser,
+		session.TLSVersion,
+		session.TLSCipher,
+		session.StartedAt,
+		string(transcript),
+	)
+
+	return err
+}
+
+/*
+deleteMailData removes what MailSlurper recorded alongside the mail
+items listed by mailItems, which is a list of placeholders or a query
+returning mail item IDs
+*/
+func (store *SQLMailStore) deleteMailData(transaction *sql.Tx, mailItems string, parameters []interface{}) error {
+	for _, table := range mailDataTables {
+		if _, err := transaction.Exec(store.rebind("DELETE FROM "+table+" WHERE mailItemId IN ("+mailItems+")"), parameters...); err != nil {
+			return err
+		}
+	}
+
+	if store.engine == "sqlite" {
+		if _, err := transaction.Exec("INSERT INTO mailsearchindex (mailsearchindex, docid, subject, body, fromAddress, toAddresses) SELECT 'delete', searchId, subject, body, fromAddress, toAddresses FROM mailsearch WHERE mailItemId IN ("+mailItems+")", parameters...); err != nil {
+			return err
+		}
+	}
+
+	_, err := transaction.Exec(store.rebind("DELETE FROM mailsearch WHERE mailItemId IN ("+mailItems+")"), parameters...)
+	return err
+}
+
+/*
+lengthFunction returns the SQL function giving the size of a value in
+bytes
+*/
+func (store *SQLMailStore) lengthFunction() string {
+	switch store.engine {
+	case "mssql":
+		return "DATALENGTH"
+	case "postgres":
+		return "OCTET_LENGTH"
+	}
+
+	return "LENGTH"
+}
+
+/*
+likePattern returns a LIKE pattern matching value anywhere in a column.
+Wildcards in value, including MSSQL character classes, are escaped with
+'!' rather than a backslash, which MySQL string literals treat
+specially.
+*/
+func likePattern(value string) string {
+	escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_", "[", "![").Replace(value)
+	return "%" + escaped + "%"
+}
+
+/*
+rebind rewrites the ? placeholders in a query to the $1, $2... form
+PostgreSQL expects
+*/
+func (store *SQLMailStore) rebind(query string) string {
+	if store.engine != "postgres" {
+		return query
+	}
+
+	var result bytes.Buffer
+	count := 0
+
+	for _, character := range query {
+		if character == '?' {
+			count++
+			result.WriteString(fmt.Sprintf("$%d", count))
+			continue
+		}
+
+		result.WriteRune(character)
+	}
+
+	return result.String()
+}
+
+/*
+searchCriteria builds the WHERE clause for a search the way the storage
+engines do: text criteria are case-insensitive substring matches and
+the dates are compared against the date sent.
+*/
+func (store *SQLMailStore) searchCriteria(mailSearch *search.MailSearch) (string, []interface{}, error) {
+	conditions := make([]string, 0)
+	parameters := make([]interface{}, 0)
+
+	if mailSearch == nil {
+		return "", parameters, nil
+	}
+
+	like := "LIKE"
+	if store.engine == "postgres" {
+		like = "ILIKE"
+	}
+
+	if message := strings.TrimSpace(mailSearch.Message); message != "" {
+		conditions = append(conditions, fmt.Sprintf("(body %[1]s ?%[2]s OR subject %[1]s ?%[2]s)", like, LIKE_ESCAPE))
+		parameters = append(parameters, likePattern(message), likePattern(message))
+	}
+
+	if from := strings.TrimSpace(mailSearch.From); from != "" {
+		conditions = append(conditions, "fromAddress "+like+" ?"+LIKE_ESCAPE)
+		parameters = append(parameters, likePattern(from))
+	}
+
+	if to := strings.TrimSpace(mailSearch.To); to != "" {
+		conditions = append(conditions, "toAddressList "+like+" ?"+LIKE_ESCAPE)
+		parameters = append(parameters, likePattern(to))
+	}
+
+	if mailSearch.Start != "" {
+		start, err := store.searchDate(mailSearch.Start)
+		if err != nil {
+			return "", nil, err
+		}
+
+		conditions = append(conditions, "dateSent >= ?")
+		parameters = append(parameters, start)
+	}
+
+	if mailSearch.End != "" {
+		end, err := store.searchDate(mailSearch.End)
+		if err != nil {
+			return "", nil, err
+		}
+
+		conditions = append(conditions, "dateSent <= ?")
+		parameters = append(parameters, end)
+	}
+
+	if len(conditions) == 0 {
+		return "", parameters, nil
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND "), parameters, nil
+}
+
+/*
+searchDate returns a search or cutoff date as a query parameter.
+PostgreSQL stores dates as timestamps; the other engines compare them
+as given.
+*/
+func (store *SQLMailStore) searchDate(value string) (interface{}, error) {
+	if store.engine == "postgres" {
+		return parseSearchDate(value)
+	}
+
+	return value, nil
+}
+
+/*
+Disconnect closes the database connection
+*/
+func (store *SQLMailStore) Disconnect() {
+	store.db.Close()
+}
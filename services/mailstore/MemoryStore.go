@@ -0,0 +1,781 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package mailstore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mailslurper/libmailslurper/model/attachment"
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+	"github.com/mailslurper/libmailslurper/model/search"
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/nu7hatch/gouuid"
+)
+
+/*
+MemoryStore keeps captured mail in memory. It implements both the
+libmailslurper storage.IStorage interface and IMailStore, so a single
+MemoryStore replaces the database for dbEngine "memory". Nothing is
+written to disk and everything is gone when the server stops.
+
+When maxMailCount is reached the oldest mail item is evicted to make
+room for each new one. A maxMailCount of zero means no limit.
+*/
+type MemoryStore struct {
+	lock           sync.RWMutex
+	maxMailCount   int
+	onEvictedBlobs func(hashes []string)
+
+	mailItems map[string]*mailitem.MailItem
+	order     []string
+	metadata  map[string]*model.MailMetadata
+	sessions  map[string]*model.SMTPSessionRecord
+	raw       map[string][]byte
+	blobRefs  map[string][]string
+	documents map[string]*model.SearchDocument
+	headers   map[string][]*model.MailHeader
+}
+
+/*
+NewMemoryStore creates a new, empty MemoryStore object
+*/
+func NewMemoryStore(maxMailCount int) *MemoryStore {
+	return &MemoryStore{
+		maxMailCount: maxMailCount,
+		mailItems:    make(map[string]*mailitem.MailItem),
+		order:        make([]string, 0),
+		metadata:     make(map[string]*model.MailMetadata),
+		sessions:     make(map[string]*model.SMTPSessionRecord),
+		raw:          make(map[string][]byte),
+		blobRefs:     make(map[string][]string),
+		documents:    make(map[string]*model.SearchDocument),
+		headers:      make(map[string][]*model.MailHeader),
+	}
+}
+
+/*
+Connect does nothing; there is no connection to open
+*/
+func (store *MemoryStore) Connect() error {
+	return nil
+}
+
+/*
+Create does nothing; there are no tables to create
+*/
+func (store *MemoryStore) Create() error {
+	return nil
+}
+
+/*
+Disconnect does nothing; mail is kept until the process exits
+*/
+func (store *MemoryStore) Disconnect() {
+}
+
+/*
+SetEvictionHandler registers a function called with the attachment
+blobs mail items referred to when StoreMail evicts or replaces them.
+It is called while the store is locked, so it must not call back into
+the store.
+*/
+func (store *MemoryStore) SetEvictionHandler(handler func(hashes []string)) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.onEvictedBlobs = handler
+}
+
+/*
+GetAttachment returns a single attachment with its contents
+*/
+func (store *MemoryStore) GetAttachment(mailID, attachmentID string) (attachment.Attachment, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	if mailItem, ok := store.mailItems[mailID]; ok {
+		for _, mailAttachment := range mailItem.Attachments {
+			if mailAttachment.ID == attachmentID {
+				return *mailAttachment, nil
+			}
+		}
+	}
+
+	return attachment.Attachment{}, fmt.Errorf("Attachment %s not found", attachmentID)
+}
+
+/*
+GetMailByID returns a single mail item with its attachments
+*/
+func (store *MemoryStore) GetMailByID(id string) (mailitem.MailItem, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	mailItem, ok := store.mailItems[id]
+	if !ok {
+		return mailitem.MailItem{}, fmt.Errorf("Mail item %s not found", id)
+	}
+
+	result := *mailItem
+	result.Attachments = make([]*attachment.Attachment, 0, len(mailItem.Attachments))
+
+	for _, mailAttachment := range mailItem.Attachments {
+		copied := *mailAttachment
+		result.Attachments = append(result.Attachments, &copied)
+	}
+
+	return result, nil
+}
+
+/*
+GetMailCollection returns a page of mail items matching the search
+criteria. Like the database engines, attachments are listed without
+their contents.
+*/
+func (store *MemoryStore) GetMailCollection(offset, length int, mailSearch *search.MailSearch) ([]mailitem.MailItem, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	matches := store.search(mailSearch)
+	result := make([]mailitem.MailItem, 0, length)
+
+	for index := offset; index < len(matches) && index < offset+length; index++ {
+		mailItem := *matches[index]
+		mailItem.Attachments = make([]*attachment.Attachment, 0, len(matches[index].Attachments))
+
+		for _, mailAttachment := range matches[index].Attachments {
+			listed := *mailAttachment
+			listed.Contents = ""
+			mailItem.Attachments = append(mailItem.Attachments, &listed)
+		}
+
+		result = append(result, mailItem)
+	}
+
+	return result, nil
+}
+
+/*
+GetMailCount returns the number of mail items matching the search
+criteria
+*/
+func (store *MemoryStore) GetMailCount(mailSearch *search.MailSearch) (int, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	return len(store.search(mailSearch)), nil
+}
+
+/*
+DeleteMailsAfterDate removes mail sent on or before startDate, which is
+what the database engines do despite the name. An empty date removes
+everything.
+*/
+func (store *MemoryStore) DeleteMailsAfterDate(startDate string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	for _, mailID := range append([]string{}, store.order...) {
+		if startDate == "" || store.mailItems[mailID].DateSent <= startDate {
+			store.remove(mailID)
+		}
+	}
+
+	return nil
+}
+
+/*
+StoreMail keeps a mail item and its attachments, evicting the oldest
+mail item when the store is full. The mail item's ID is kept when it
+has one.
+*/
+func (store *MemoryStore) StoreMail(mailItem *mailitem.MailItem) (string, error) {
+	if mailItem.ID == "" {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return "", err
+		}
+
+		mailItem.ID = id.String()
+	}
+
+	stored := *mailItem
+	stored.Attachments = make([]*attachment.Attachment, 0, len(mailItem.Attachments))
+
+	for _, mailAttachment := range mailItem.Attachments {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return "", err
+		}
+
+		copied := *mailAttachment
+		copied.ID = id.String()
+		copied.MailID = mailItem.ID
+		stored.Attachments = append(stored.Attachments, &copied)
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	evictedBlobs := make([]string, 0)
+
+	if _, exists := store.mailItems[stored.ID]; exists {
+		evictedBlobs = append(evictedBlobs, store.blobRefs[stored.ID]...)
+		store.remove(stored.ID)
+	}
+
+	for store.maxMailCount > 0 && len(store.order) >= store.maxMailCount {
+		evictedBlobs = append(evictedBlobs, store.blobRefs[store.order[0]]...)
+		store.remove(store.order[0])
+	}
+
+	if len(evictedBlobs) > 0 && store.onEvictedBlobs != nil {
+		store.onEvictedBlobs(evictedBlobs)
+	}
+
+	store.mailItems[stored.ID] = &stored
+	store.order = append(store.order, stored.ID)
+
+	return stored.ID, nil
+}
+
+/*
+CountAttachmentReferences returns how many references are left to each
+of the given attachment blobs
+*/
+func (store *MemoryStore) CountAttachmentReferences(hashes []string) (map[string]int, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	result := make(map[string]int)
+
+	for _, hash := range hashes {
+		result[hash] = 0
+	}
+
+	for _, referenced := range store.blobRefs {
+		for _, hash := range referenced {
+			if _, ok := result[hash]; ok {
+				result[hash]++
+			}
+		}
+	}
+
+	return result, nil
+}
+
+/*
+DeleteMail removes a single mail item and everything recorded with it
+*/
+func (store *MemoryStore) DeleteMail(mailID string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.remove(mailID)
+	return nil
+}
+
+/*
+DeleteMails removes mail items and everything recorded with them, and
+returns how many mail items were removed
+*/
+func (store *MemoryStore) DeleteMails(mailIDs []string) (int, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	removed := 0
+
+	for _, mailID := range mailIDs {
+		if _, ok := store.mailItems[mailID]; ok {
+			store.remove(mailID)
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+/*
+DeleteMailDataAfterDate removes the records kept alongside the mail sent
+on or before startDate, leaving the mail items for DeleteMailsAfterDate
+*/
+func (store *MemoryStore) DeleteMailDataAfterDate(startDate string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	for _, mailID := range store.order {
+		if startDate == "" || store.mailItems[mailID].DateSent <= startDate {
+			store.removeData(mailID)
+		}
+	}
+
+	return nil
+}
+
+/*
+GetAttachmentReferences returns the attachment blobs a mail item refers
+to, each listed once
+*/
+func (store *MemoryStore) GetAttachmentReferences(mailID string) ([]string, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	result := make([]string, 0, len(store.blobRefs[mailID]))
+	seen := make(map[string]bool)
+
+	for _, hash := range store.blobRefs[mailID] {
+		if !seen[hash] {
+			seen[hash] = true
+			result = append(result, hash)
+		}
+	}
+
+	return result, nil
+}
+
+/*
+GetMailboxes returns each distinct mail item tag with the number of
+mail items carrying it
+*/
+func (store *MemoryStore) GetMailboxes() ([]*model.Mailbox, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	counts := make(map[string]int)
+
+	for _, metadata := range store.metadata {
+		if metadata.Tag != "" {
+			counts[metadata.Tag]++
+		}
+	}
+
+	result := make([]*model.Mailbox, 0, len(counts))
+
+	for tag, count := range counts {
+		result = append(result, &model.Mailbox{Tag: tag, MailCount: count})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Tag < result[j].Tag })
+	return result, nil
+}
+
+/*
+GetMailHeaders returns the headers of a mail item in the order they
+appeared
+*/
+func (store *MemoryStore) GetMailHeaders(mailID string) ([]*model.MailHeader, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	result := make([]*model.MailHeader, 0, len(store.headers[mailID]))
+
+	for _, header := range store.headers[mailID] {
+		copied := *header
+		result = append(result, &copied)
+	}
+
+	return result, nil
+}
+
+/*
+GetMailIDs returns the IDs of the mail items matching the search
+criteria, in the order GetMailCollection would return them
+*/
+func (store *MemoryStore) GetMailIDs(mailSearch *search.MailSearch) ([]string, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	mailItems := store.search(mailSearch)
+	result := make([]string, 0, len(mailItems))
+
+	for _, mailItem := range mailItems {
+		result = append(result, mailItem.ID)
+	}
+
+	return result, nil
+}
+
+/*
+GetMailIDsByHeader returns the set of mail item IDs with a header whose
+value is exactly value. Header names are not case sensitive. An empty
+value matches every mail item carrying the header.
+*/
+func (store *MemoryStore) GetMailIDsByHeader(name, value string) (map[string]bool, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	result := make(map[string]bool)
+
+	for mailID, headers := range store.headers {
+		for _, header := range headers {
+			if strings.EqualFold(header.Name, name) && (value == "" || header.Value == value) {
+				result[mailID] = true
+				break
+			}
+		}
+	}
+
+	return result, nil
+}
+
+/*
+GetMailIDsByTag returns the set of mail item IDs carrying a tag
+*/
+func (store *MemoryStore) GetMailIDsByTag(tag string) (map[string]bool, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	result := make(map[string]bool)
+
+	for mailID, metadata := range store.metadata {
+		if metadata.Tag == tag {
+			result[mailID] = true
+		}
+	}
+
+	return result, nil
+}
+
+/*
+GetMailMetadata returns the receive details for a mail item
+*/
+func (store *MemoryStore) GetMailMetadata(mailID string) (*model.MailMetadata, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	if metadata, ok := store.metadata[mailID]; ok {
+		result := *metadata
+		return &result, nil
+	}
+
+	return &model.MailMetadata{MailItemID: mailID}, nil
+}
+
+/*
+GetMailSizes returns the storage used by every mail item, oldest first
+*/
+func (store *MemoryStore) GetMailSizes() ([]*model.MailSize, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	result := make([]*model.MailSize, 0, len(store.order))
+
+	for _, mailItem := range store.search(&search.MailSearch{OrderByDirection: "asc"}) {
+		size := int64(len(store.raw[mailItem.ID]))
+
+		if size == 0 {
+			size = int64(len(mailItem.Body))
+
+			for _, mailAttachment := range mailItem.Attachments {
+				size += int64(len(mailAttachment.Contents))
+			}
+		}
+
+		result = append(result, &model.MailSize{MailItemID: mailItem.ID, Size: size})
+	}
+
+	return result, nil
+}
+
+/*
+GetRawMessage returns the message exactly as it was received, or nil
+when it was not kept
+*/
+func (store *MemoryStore) GetRawMessage(mailID string) ([]byte, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	return store.raw[mailID], nil
+}
+
+/*
+GetSearchDocument returns what the full-text search index holds for a
+mail item, or nil when it has not been indexed
+*/
+func (store *MemoryStore) GetSearchDocument(mailID string) (*model.SearchDocument, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	return store.documents[mailID], nil
+}
+
+/*
+GetSMTPSession returns the SMTP session record for a mail item, or nil
+when there is none
+*/
+func (store *MemoryStore) GetSMTPSession(mailID string) (*model.SMTPSessionRecord, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	return store.sessions[mailID], nil
+}
+
+/*
+GetUnindexedMailIDs returns the IDs of the mail items that are not in
+the full-text search index, oldest first
+*/
+func (store *MemoryStore) GetUnindexedMailIDs() ([]string, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	result := make([]string, 0)
+
+	for _, mailItem := range store.search(&search.MailSearch{OrderByDirection: "asc"}) {
+		if _, ok := store.documents[mailItem.ID]; !ok {
+			result = append(result, mailItem.ID)
+		}
+	}
+
+	return result, nil
+}
+
+/*
+IndexMail adds a mail item to the full-text search index, replacing
+what was indexed for it before
+*/
+func (store *MemoryStore) IndexMail(document *model.SearchDocument) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if _, ok := store.mailItems[document.MailItemID]; !ok {
+		return fmt.Errorf("Mail item %s not found", document.MailItemID)
+	}
+
+	stored := *document
+	store.documents[document.MailItemID] = &stored
+	return nil
+}
+
+/*
+PruneAttachmentReferences returns how many references are left to each
+attachment blob. References go with their mail item, so there is
+nothing to drop.
+*/
+func (store *MemoryStore) PruneAttachmentReferences() (map[string]int, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	result := make(map[string]int)
+
+	for _, hashes := range store.blobRefs {
+		for _, hash := range hashes {
+			result[hash]++
+		}
+	}
+
+	return result, nil
+}
+
+/*
+SearchMail returns the mail items containing every word of the search
+text, best match first. Documents are ranked the same way as on
+database engines without a full-text index.
+*/
+func (store *MemoryStore) SearchMail(text string) ([]*model.SearchMatch, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	result := make([]*model.SearchMatch, 0)
+	terms := SearchTerms(text)
+
+	if len(terms) == 0 {
+		return result, nil
+	}
+
+	for _, mailID := range store.order {
+		if document, ok := store.documents[mailID]; ok {
+			if rank := rankDocument(document, terms); rank > 0 {
+				result = append(result, &model.SearchMatch{MailItemID: mailID, Rank: rank})
+			}
+		}
+	}
+
+	sortMatches(result)
+	return result, nil
+}
+
+/*
+StoreAttachmentReferences records that a mail item refers to attachment
+blobs
+*/
+func (store *MemoryStore) StoreAttachmentReferences(mailID string, hashes []string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if _, ok := store.mailItems[mailID]; !ok {
+		return fmt.Errorf("Mail item %s not found", mailID)
+	}
+
+	store.blobRefs[mailID] = append(store.blobRefs[mailID], hashes...)
+	return nil
+}
+
+/*
+StoreMailHeaders records the headers of a stored mail item, keeping
+their order
+*/
+func (store *MemoryStore) StoreMailHeaders(mailID string, headers []*model.MailHeader) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if _, ok := store.mailItems[mailID]; !ok {
+		return fmt.Errorf("Mail item %s not found", mailID)
+	}
+
+	stored := make([]*model.MailHeader, 0, len(headers))
+
+	for _, header := range headers {
+		copied := *header
+		stored = append(stored, &copied)
+	}
+
+	store.headers[mailID] = append(store.headers[mailID], stored...)
+	return nil
+}
+
+/*
+StoreMailMetadata records the receive details for a stored mail item
+*/
+func (store *MemoryStore) StoreMailMetadata(metadata *model.MailMetadata) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if _, ok := store.mailItems[metadata.MailItemID]; !ok {
+		return fmt.Errorf("Mail item %s not found", metadata.MailItemID)
+	}
+
+	stored := *metadata
+	store.metadata[metadata.MailItemID] = &stored
+	return nil
+}
+
+/*
+StoreRawMessage keeps the message exactly as it was received
+*/
+func (store *MemoryStore) StoreRawMessage(mailID string, contents []byte) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if _, ok := store.mailItems[mailID]; !ok {
+		return fmt.Errorf("Mail item %s not found", mailID)
+	}
+
+	store.raw[mailID] = contents
+	return nil
+}
+
+/*
+StoreSMTPSession records the SMTP session a stored mail item arrived in
+*/
+func (store *MemoryStore) StoreSMTPSession(session *model.SMTPSessionRecord) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if _, ok := store.mailItems[session.MailItemID]; !ok {
+		return fmt.Errorf("Mail item %s not found", session.MailItemID)
+	}
+
+	store.sessions[session.MailItemID] = session
+	return nil
+}
+
+/*
+remove deletes a mail item and its records. The caller must hold the
+write lock.
+*/
+func (store *MemoryStore) remove(mailID string) {
+	if _, ok := store.mailItems[mailID]; !ok {
+		return
+	}
+
+	delete(store.mailItems, mailID)
+	store.removeData(mailID)
+
+	for index, id := range store.order {
+		if id == mailID {
+			store.order = append(store.order[:index], store.order[index+1:]...)
+			break
+		}
+	}
+}
+
+/*
+removeData deletes the records kept alongside a mail item. The caller
+must hold the write lock.
+*/
+func (store *MemoryStore) removeData(mailID string) {
+	delete(store.metadata, mailID)
+	delete(store.sessions, mailID)
+	delete(store.raw, mailID)
+	delete(store.blobRefs, mailID)
+	delete(store.documents, mailID)
+	delete(store.headers, mailID)
+}
+
+/*
+search returns the mail items matching the search criteria in the
+requested order. The criteria behave like the database engines': text
+matches are case-insensitive substring matches, and the dates are
+compared against the date sent.
+*/
+func (store *MemoryStore) search(mailSearch *search.MailSearch) []*mailitem.MailItem {
+	if mailSearch == nil {
+		mailSearch = &search.MailSearch{}
+	}
+
+	message := strings.ToLower(strings.TrimSpace(mailSearch.Message))
+	from := strings.ToLower(strings.TrimSpace(mailSearch.From))
+	to := strings.ToLower(strings.TrimSpace(mailSearch.To))
+
+	result := make([]*mailitem.MailItem, 0, len(store.order))
+
+	for _, mailID := range store.order {
+		mailItem := store.mailItems[mailID]
+
+		if message != "" && !strings.Contains(strings.ToLower(mailItem.Subject), message) && !strings.Contains(strings.ToLower(mailItem.Body), message) {
+			continue
+		}
+
+		if from != "" && !strings.Contains(strings.ToLower(mailItem.FromAddress), from) {
+			continue
+		}
+
+		if to != "" && !strings.Contains(strings.ToLower(strings.Join(mailItem.ToAddresses, ",")), to) {
+			continue
+		}
+
+		if mailSearch.Start != "" && mailItem.DateSent < mailSearch.Start {
+			continue
+		}
+
+		if mailSearch.End != "" && mailItem.DateSent > mailSearch.End {
+			continue
+		}
+
+		result = append(result, mailItem)
+	}
+
+	less := func(i, j int) bool { return result[i].DateSent < result[j].DateSent }
+
+	switch strings.ToLower(mailSearch.OrderByField) {
+	case "subject":
+		less = func(i, j int) bool { return strings.ToLower(result[i].Subject) < strings.ToLower(result[j].Subject) }
+	case "from":
+		less = func(i, j int) bool {
+			return strings.ToLower(result[i].FromAddress) < strings.ToLower(result[j].FromAddress)
+		}
+	}
+
+	if strings.ToLower(mailSearch.OrderByDirection) == "asc" {
+		sort.SliceStable(result, less)
+	} else {
+		sort.SliceStable(result, func(i, j int) bool { return less(j, i) })
+	}
+
+	return result
+}
@@ -0,0 +1,550 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package mailstore
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/mailslurper/libmailslurper/configuration"
+	"github.com/mailslurper/libmailslurper/model/attachment"
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+	"github.com/mailslurper/libmailslurper/model/search"
+	"github.com/mailslurper/mailslurper/services/mailparser"
+	"github.com/nu7hatch/gouuid"
+
+	_ "github.com/lib/pq"
+)
+
+const (
+	// TO_ADDRESS_SEPARATOR joins the recipients stored in toAddressList
+	TO_ADDRESS_SEPARATOR string = "; "
+
+	// MAIL_ITEM_COLUMNS are the mailitem columns read by queryMailItems
+	MAIL_ITEM_COLUMNS string = "id, dateSent, fromAddress, toAddressList, subject, xmailer, mimeVersion, body, contentType, boundary"
+)
+
+/*
+postgresTables is the schema created by PostgresStorage. It matches
+scripts/create-postgres.sql.
+*/
+var postgresTables = []string{
+	`CREATE TABLE IF NOT EXISTS mailitem (
+		id VARCHAR(36) NOT NULL PRIMARY KEY,
+		dateSent TIMESTAMPTZ NOT NULL,
+		fromAddress TEXT NOT NULL,
+		toAddressList TEXT NOT NULL,
+		subject TEXT,
+		xmailer TEXT,
+		mimeVersion TEXT,
+		body TEXT,
+		contentType TEXT,
+		boundary TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS attachment (
+		id VARCHAR(36) NOT NULL PRIMARY KEY,
+		mailItemId VARCHAR(36) NOT NULL REFERENCES mailitem (id) ON DELETE CASCADE,
+		fileName TEXT,
+		contentType TEXT,
+		contentTransferEncoding TEXT,
+		content BYTEA
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_mailitem_datesent ON mailitem (dateSent)`,
+	`CREATE INDEX IF NOT EXISTS idx_attachment_mailitemid ON attachment (mailItemId)`,
+}
+
+/*
+postgresSearchIndexes speed up the substring searches on subject, body
+and addresses. They need the pg_trgm extension, which not every
+database user may create, so failing to add them is not fatal.
+*/
+var postgresSearchIndexes = []string{
+	`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+	`CREATE INDEX IF NOT EXISTS idx_mailitem_subject_trgm ON mailitem USING gin (subject gin_trgm_ops)`,
+	`CREATE INDEX IF NOT EXISTS idx_mailitem_body_trgm ON mailitem USING gin (body gin_trgm_ops)`,
+	`CREATE INDEX IF NOT EXISTS idx_mailitem_fromaddress_trgm ON mailitem USING gin (fromAddress gin_trgm_ops)`,
+	`CREATE INDEX IF NOT EXISTS idx_mailitem_toaddresslist_trgm ON mailitem USING gin (toAddressList gin_trgm_ops)`,
+}
+
+/*
+PostgresStorage implements the libmailslurper storage.IStorage
+interface for PostgreSQL, which libmailslurper does not support. The
+mail item date is a timestamptz and attachment contents are bytea.
+*/
+type PostgresStorage struct {
+	db             *sql.DB
+	dataSourceName string
+}
+
+/*
+NewPostgresStorage connects to the PostgreSQL database described in the
+configuration and creates the schema when it does not exist yet.
+*/
+func NewPostgresStorage(config *configuration.Configuration) (*PostgresStorage, error) {
+	result := &PostgresStorage{
+		dataSourceName: postgresDataSourceName(config),
+	}
+
+	if err := result.Connect(); err != nil {
+		return nil, err
+	}
+
+	if err := result.Create(); err != nil {
+		result.Disconnect()
+		return nil, err
+	}
+
+	return result, nil
+}
+
+/*
+Connect opens the database connection
+*/
+func (storage *PostgresStorage) Connect() error {
+	var err error
+
+	if storage.db, err = sql.Open("postgres", storage.dataSourceName); err != nil {
+		return err
+	}
+
+	if err = storage.db.Ping(); err != nil {
+		storage.db.Close()
+		return err
+	}
+
+	return nil
+}
+
+/*
+Disconnect closes the database connection
+*/
+func (storage *PostgresStorage) Disconnect() {
+	storage.db.Close()
+}
+
+/*
+Create creates the tables and indexes when they do not exist
+*/
+func (storage *PostgresStorage) Create() error {
+	for _, statement := range postgresTables {
+		if _, err := storage.db.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	for _, statement := range postgresSearchIndexes {
+		if _, err := storage.db.Exec(statement); err != nil {
+			log.Printf("MailSlurper: INFO - PostgreSQL search indexes were not created, searches will be slower: %s\n", err.Error())
+			break
+		}
+	}
+
+	return nil
+}
+
+/*
+GetAttachment returns a single attachment with its contents
+*/
+func (storage *PostgresStorage) GetAttachment(mailID, attachmentID string) (attachment.Attachment, error) {
+	var content []byte
+
+	result := attachment.Attachment{
+		Headers: &attachment.AttachmentHeader{},
+	}
+
+	err := storage.db.QueryRow(
+		"SELECT id, mailItemId, fileName, contentType, contentTransferEncoding, content FROM attachment WHERE mailItemId=$1 AND id=$2",
+		mailID,
+		attachmentID,
+	).Scan(
+		&result.ID,
+		&result.MailID,
+		&result.Headers.FileName,
+		&result.Headers.ContentType,
+		&result.Headers.ContentTransferEncoding,
+		&content,
+	)
+
+	if err != nil {
+		return result, err
+	}
+
+	result.Contents = string(content)
+	return result, nil
+}
+
+/*
+GetMailByID returns a single mail item. Attachments are listed without
+their contents; use GetAttachment to read them.
+*/
+func (storage *PostgresStorage) GetMailByID(id string) (mailitem.MailItem, error) {
+	mailItems, err := storage.queryMailItems("SELECT "+MAIL_ITEM_COLUMNS+" FROM mailitem WHERE id=$1", id)
+	if err != nil {
+		return mailitem.MailItem{}, err
+	}
+
+	if len(mailItems) == 0 {
+		return mailitem.MailItem{}, sql.ErrNoRows
+	}
+
+	return mailItems[0], nil
+}
+
+/*
+GetMailCollection returns a page of mail items matching the search
+criteria
+*/
+func (storage *PostgresStorage) GetMailCollection(offset, length int, mailSearch *search.MailSearch) ([]mailitem.MailItem, error) {
+	where, parameters, err := postgresSearchCriteria(mailSearch)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(
+		"SELECT %s FROM mailitem%s ORDER BY %s LIMIT $%d OFFSET $%d",
+		MAIL_ITEM_COLUMNS,
+		where,
+		searchOrderBy(mailSearch),
+		len(parameters)+1,
+		len(parameters)+2,
+	)
+
+	return storage.queryMailItems(query, append(parameters, length, offset)...)
+}
+
+/*
+GetMailCount returns the number of mail items matching the search
+criteria
+*/
+func (storage *PostgresStorage) GetMailCount(mailSearch *search.MailSearch) (int, error) {
+	var result int
+
+	where, parameters, err := postgresSearchCriteria(mailSearch)
+	if err != nil {
+		return 0, err
+	}
+
+	err = storage.db.QueryRow("SELECT COUNT(*) FROM mailitem"+where, parameters...).Scan(&result)
+	return result, err
+}
+
+/*
+DeleteMailsAfterDate removes mail sent on or before startDate, along
+with everything MailSlurper recorded for it. This matches the other
+engines despite the name. An empty date removes everything.
+*/
+func (storage *PostgresStorage) DeleteMailsAfterDate(startDate string) error {
+	var err error
+	var transaction *sql.Tx
+
+	where := ""
+	parameters := make([]interface{}, 0, 1)
+
+	if startDate != "" {
+		date, err := parseSearchDate(startDate)
+		if err != nil {
+			return err
+		}
+
+		where = " WHERE dateSent <= $1"
+		parameters = append(parameters, date)
+	}
+
+	if transaction, err = storage.db.Begin(); err != nil {
+		return err
+	}
+
+	for _, table := range []string{"attachment", "mailmetadata", "mailsession", "mailraw", "attachmentref", "mailsearch", "mailheader"} {
+		if _, err = transaction.Exec("DELETE FROM "+table+" WHERE mailItemId IN (SELECT id FROM mailitem"+where+")", parameters...); err != nil {
+			transaction.Rollback()
+			return err
+		}
+	}
+
+	if _, err = transaction.Exec("DELETE FROM mailitem"+where, parameters...); err != nil {
+		transaction.Rollback()
+		return err
+	}
+
+	return transaction.Commit()
+}
+
+/*
+StoreMail writes a mail item and its attachments. The mail item's ID
+is kept when it has one.
+*/
+func (storage *PostgresStorage) StoreMail(mailItem *mailitem.MailItem) (string, error) {
+	var err error
+	var transaction *sql.Tx
+
+	if mailItem.ID == "" {
+		var id *uuid.UUID
+
+		if id, err = uuid.NewV4(); err != nil {
+			return "", err
+		}
+
+		mailItem.ID = id.String()
+	}
+
+	dateSent, err := time.ParseInLocation(mailparser.DATE_FORMAT, mailItem.DateSent, time.Local)
+	if err != nil {
+		dateSent = time.Now()
+	}
+
+	if transaction, err = storage.db.Begin(); err != nil {
+		return "", err
+	}
+
+	_, err = transaction.Exec(
+		"INSERT INTO mailitem (id, dateSent, fromAddress, toAddressList, subject, xmailer, mimeVersion, body, contentType, boundary) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
+		mailItem.ID,
+		dateSent,
+		mailItem.FromAddress,
+		strings.Join(mailItem.ToAddresses, TO_ADDRESS_SEPARATOR),
+		mailItem.Subject,
+		mailItem.XMailer,
+		mailItem.MIMEVersion,
+		mailItem.Body,
+		mailItem.ContentType,
+		mailItem.Boundary,
+	)
+
+	if err != nil {
+		transaction.Rollback()
+		return "", err
+	}
+
+	for _, mailAttachment := range mailItem.Attachments {
+		var id *uuid.UUID
+
+		if id, err = uuid.NewV4(); err != nil {
+			transaction.Rollback()
+			return "", err
+		}
+
+		mailAttachment.ID = id.String()
+		mailAttachment.MailID = mailItem.ID
+
+		headers := mailAttachment.Headers
+		if headers == nil {
+			headers = &attachment.AttachmentHeader{}
+		}
+
+		_, err = transaction.Exec(
+			"INSERT INTO attachment (id, mailItemId, fileName, contentType, contentTransferEncoding, content) VALUES ($1, $2, $3, $4, $5, $6)",
+			mailAttachment.ID,
+			mailItem.ID,
+			headers.FileName,
+			headers.ContentType,
+			headers.ContentTransferEncoding,
+			[]byte(mailAttachment.Contents),
+		)
+
+		if err != nil {
+			transaction.Rollback()
+			return "", err
+		}
+	}
+
+	return mailItem.ID, transaction.Commit()
+}
+
+/*
+queryMailItems runs a query selecting MAIL_ITEM_COLUMNS and attaches
+each mail item's attachment list
+*/
+func (storage *PostgresStorage) queryMailItems(query string, parameters ...interface{}) ([]mailitem.MailItem, error) {
+	result := make([]mailitem.MailItem, 0)
+	index := make(map[string]int)
+
+	rows, err := storage.db.Query(query, parameters...)
+	if err != nil {
+		return result, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var dateSent time.Time
+		var toAddressList string
+		var subject, xmailer, mimeVersion, body, contentType, boundary sql.NullString
+
+		mailItem := mailitem.MailItem{
+			Attachments: make([]*attachment.Attachment, 0),
+		}
+
+		if err = rows.Scan(&mailItem.ID, &dateSent, &mailItem.FromAddress, &toAddressList, &subject, &xmailer, &mimeVersion, &body, &contentType, &boundary); err != nil {
+			return result, err
+		}
+
+		mailItem.DateSent = dateSent.In(time.Local).Format(mailparser.DATE_FORMAT)
+		mailItem.ToAddresses = strings.Split(toAddressList, TO_ADDRESS_SEPARATOR)
+		mailItem.Subject = subject.String
+		mailItem.XMailer = xmailer.String
+		mailItem.MIMEVersion = mimeVersion.String
+		mailItem.Body = body.String
+		mailItem.ContentType = contentType.String
+		mailItem.Boundary = boundary.String
+
+		index[mailItem.ID] = len(result)
+		result = append(result, mailItem)
+	}
+
+	if err = rows.Err(); err != nil || len(result) == 0 {
+		return result, err
+	}
+
+	placeholders := make([]string, 0, len(result))
+	mailIDs := make([]interface{}, 0, len(result))
+
+	for _, mailItem := range result {
+		mailIDs = append(mailIDs, mailItem.ID)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(mailIDs)))
+	}
+
+	attachmentRows, err := storage.db.Query(
+		"SELECT id, mailItemId, fileName, contentType, contentTransferEncoding FROM attachment WHERE mailItemId IN ("+strings.Join(placeholders, ", ")+") ORDER BY fileName",
+		mailIDs...,
+	)
+
+	if err != nil {
+		return result, err
+	}
+
+	defer attachmentRows.Close()
+
+	for attachmentRows.Next() {
+		var fileName, contentType, contentTransferEncoding sql.NullString
+		mailAttachment := &attachment.Attachment{}
+
+		if err = attachmentRows.Scan(&mailAttachment.ID, &mailAttachment.MailID, &fileName, &contentType, &contentTransferEncoding); err != nil {
+			return result, err
+		}
+
+		mailAttachment.Headers = &attachment.AttachmentHeader{
+			FileName:                fileName.String,
+			ContentType:             contentType.String,
+			ContentTransferEncoding: contentTransferEncoding.String,
+		}
+
+		mailItem := &result[index[mailAttachment.MailID]]
+		mailItem.Attachments = append(mailItem.Attachments, mailAttachment)
+	}
+
+	return result, attachmentRows.Err()
+}
+
+/*
+postgresSearchCriteria builds the WHERE clause for a search. Text
+criteria are case-insensitive substring matches, like the other
+engines.
+*/
+func postgresSearchCriteria(mailSearch *search.MailSearch) (string, []interface{}, error) {
+	conditions := make([]string, 0)
+	parameters := make([]interface{}, 0)
+
+	if mailSearch == nil {
+		return "", parameters, nil
+	}
+
+	addParameter := func(value interface{}) string {
+		parameters = append(parameters, value)
+		return fmt.Sprintf("$%d", len(parameters))
+	}
+
+	if message := strings.TrimSpace(mailSearch.Message); message != "" {
+		placeholder := addParameter(likePattern(message))
+		conditions = append(conditions, fmt.Sprintf("(body ILIKE %[1]s%[2]s OR subject ILIKE %[1]s%[2]s)", placeholder, LIKE_ESCAPE))
+	}
+
+	if from := strings.TrimSpace(mailSearch.From); from != "" {
+		conditions = append(conditions, "fromAddress ILIKE "+addParameter(likePattern(from))+LIKE_ESCAPE)
+	}
+
+	if to := strings.TrimSpace(mailSearch.To); to != "" {
+		conditions = append(conditions, "toAddressList ILIKE "+addParameter(likePattern(to))+LIKE_ESCAPE)
+	}
+
+	if mailSearch.Start != "" {
+		start, err := parseSearchDate(mailSearch.Start)
+		if err != nil {
+			return "", nil, err
+		}
+
+		conditions = append(conditions, "dateSent >= "+addParameter(start))
+	}
+
+	if mailSearch.End != "" {
+		end, err := parseSearchDate(mailSearch.End)
+		if err != nil {
+			return "", nil, err
+		}
+
+		conditions = append(conditions, "dateSent <= "+addParameter(end))
+	}
+
+	if len(conditions) == 0 {
+		return "", parameters, nil
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND "), parameters, nil
+}
+
+/*
+searchOrderBy returns the ORDER BY expression for a search, newest
+first unless another order is asked for
+*/
+func searchOrderBy(mailSearch *search.MailSearch) string {
+	column := "dateSent"
+	direction := "DESC"
+
+	if mailSearch != nil {
+		switch strings.ToLower(mailSearch.OrderByField) {
+		case "subject":
+			column = "subject"
+		case "from":
+			column = "fromAddress"
+		}
+
+		if strings.ToLower(mailSearch.OrderByDirection) == "asc" {
+			direction = "ASC"
+		}
+	}
+
+	return column + " " + direction
+}
+
+/*
+parseSearchDate reads a search date given either as a date or as a date
+and time, in local time
+*/
+func parseSearchDate(value string) (time.Time, error) {
+	for _, layout := range []string{mailparser.DATE_FORMAT, "2006-01-02"} {
+		if result, err := time.ParseInLocation(layout, strings.TrimSpace(value), time.Local); err == nil {
+			return result, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Invalid date '%s'", value)
+}
+
+func postgresDataSourceName(config *configuration.Configuration) string {
+	quote := func(value string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+	}
+
+	return fmt.Sprintf(
+		"host=%s port=%d dbname=%s user=%s password=%s sslmode=disable",
+		quote(config.DBHost),
+		config.DBPort,
+		quote(config.DBDatabase),
+		quote(config.DBUserName),
+		quote(config.DBPassword),
+	)
+}
@@ -0,0 +1,23 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package mailstore
+
+import (
+	"github.com/mailslurper/libmailslurper/storage"
+)
+
+/*
+DeleteMailsAfterDate removes the mail sent on or before startDate from
+both the mail store and the storage engine. The libmailslurper engines
+only know about their own tables, so everything MailSlurper recorded
+for the mail goes first. An empty date removes all mail.
+*/
+func DeleteMailsAfterDate(database storage.IStorage, mailStore IMailStore, startDate string) error {
+	if err := mailStore.DeleteMailDataAfterDate(startDate); err != nil {
+		return err
+	}
+
+	return database.DeleteMailsAfterDate(startDate)
+}
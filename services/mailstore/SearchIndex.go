@@ -0,0 +1,357 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package mailstore
+
+import (
+	"database/sql"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mailslurper/mailslurper/model"
+)
+
+const (
+	// SUBJECT_WEIGHT, ADDRESS_WEIGHT and BODY_WEIGHT decide how much a matching word counts towards a rank
+	SUBJECT_WEIGHT float64 = 3
+	ADDRESS_WEIGHT float64 = 2
+	BODY_WEIGHT    float64 = 1
+)
+
+var searchTermPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+/*
+SearchTerms splits search text into the lower case words a full-text
+search looks for. Punctuation and search operators are dropped, so the
+result is always safe to build an engine's query syntax from. Each
+word matches any word starting with it.
+*/
+func SearchTerms(text string) []string {
+	return searchTermPattern.FindAllString(strings.ToLower(text), -1)
+}
+
+/*
+IndexMail adds a mail item to the full-text search index, replacing
+what was indexed for it before. SQLite keeps the words in an FTS4 table,
+MySQL in a FULLTEXT index and PostgreSQL in a weighted tsvector. MSSQL
+full-text search needs a catalog set up by hand, so MSSQL searches the
+stored text directly.
+*/
+func (store *SQLMailStore) IndexMail(document *model.SearchDocument) error {
+	var err error
+	var transaction *sql.Tx
+
+	if transaction, err = store.db.Begin(); err != nil {
+		return err
+	}
+
+	if err = store.deleteSearchDocument(transaction, document.MailItemID); err != nil {
+		transaction.Rollback()
+		return err
+	}
+
+	switch store.engine {
+	case "postgres":
+		_, err = transaction.Exec(`
+			INSERT INTO mailsearch (mailItemId, subject, body, fromAddress, toAddresses, document) VALUES (
+				$1, $2::text, $3::text, $4::text, $5::text,
+				setweight(to_tsvector('simple', $2::text), 'A') ||
+				setweight(to_tsvector('simple', $4::text || ' ' || $5::text), 'B') ||
+				setweight(to_tsvector('simple', $3::text), 'C')
+			)
+		`, document.MailItemID, document.Subject, document.Body, document.FromAddress, document.ToAddresses)
+
+	default:
+		_, err = transaction.Exec(store.rebind("INSERT INTO mailsearch (mailItemId, subject, body, fromAddress, toAddresses) VALUES (?, ?, ?, ?, ?)"), document.MailItemID, document.Subject, document.Body, document.FromAddress, document.ToAddresses)
+
+		if err == nil && store.engine == "sqlite" {
+			_, err = transaction.Exec("INSERT INTO mailsearchindex (docid, subject, body, fromAddress, toAddresses) SELECT searchId, subject, body, fromAddress, toAddresses FROM mailsearch WHERE mailItemId=?", document.MailItemID)
+		}
+	}
+
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+
+	return transaction.Commit()
+}
+
+/*
+GetSearchDocument returns what the full-text search index holds for a
+mail item, or nil when it has not been indexed
+*/
+func (store *SQLMailStore) GetSearchDocument(mailID string) (*model.SearchDocument, error) {
+	result := &model.SearchDocument{
+		MailItemID: mailID,
+	}
+
+	err := store.db.QueryRow(store.rebind("SELECT subject, body, fromAddress, toAddresses FROM mailsearch WHERE mailItemId=?"), mailID).Scan(&result.Subject, &result.Body, &result.FromAddress, &result.ToAddresses)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+/*
+GetUnindexedMailIDs returns the IDs of the mail items that are not in
+the full-text search index, oldest first
+*/
+func (store *SQLMailStore) GetUnindexedMailIDs() ([]string, error) {
+	result := make([]string, 0)
+
+	rows, err := store.db.Query("SELECT mailitem.id FROM mailitem LEFT JOIN mailsearch ON mailsearch.mailItemId=mailitem.id WHERE mailsearch.mailItemId IS NULL ORDER BY mailitem.dateSent")
+	if err != nil {
+		return result, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var mailID string
+
+		if err = rows.Scan(&mailID); err != nil {
+			return result, err
+		}
+
+		result = append(result, mailID)
+	}
+
+	return result, rows.Err()
+}
+
+/*
+SearchMail returns the mail items containing every word of the search
+text, best match first. Matches in the subject count for more than
+matches in the addresses, which count for more than matches in the
+body.
+*/
+func (store *SQLMailStore) SearchMail(text string) ([]*model.SearchMatch, error) {
+	var err error
+	var result []*model.SearchMatch
+
+	terms := SearchTerms(text)
+	if len(terms) == 0 {
+		return []*model.SearchMatch{}, nil
+	}
+
+	switch store.engine {
+	case "sqlite":
+		result, err = store.searchSQLite(terms)
+	case "mysql":
+		result, err = store.searchMySQL(terms)
+	case "postgres":
+		result, err = store.searchPostgres(terms)
+	default:
+		result, err = store.searchText(terms)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	sortMatches(result)
+	return result, nil
+}
+
+/*
+deleteSearchDocument removes a mail item from the full-text search
+index. An FTS4 table with external content has to be told the values
+being removed before the content row goes.
+*/
+func (store *SQLMailStore) deleteSearchDocument(transaction *sql.Tx, mailID string) error {
+	if store.engine == "sqlite" {
+		if _, err := transaction.Exec("INSERT INTO mailsearchindex (mailsearchindex, docid, subject, body, fromAddress, toAddresses) SELECT 'delete', searchId, subject, body, fromAddress, toAddresses FROM mailsearch WHERE mailItemId=?", mailID); err != nil {
+			return err
+		}
+	}
+
+	_, err := transaction.Exec(store.rebind("DELETE FROM mailsearch WHERE mailItemId=?"), mailID)
+	return err
+}
+
+/*
+searchSQLite ranks FTS4 matches by counting the matching words in each
+column, as reported by offsets()
+*/
+func (store *SQLMailStore) searchSQLite(terms []string) ([]*model.SearchMatch, error) {
+	result := make([]*model.SearchMatch, 0)
+	weights := []float64{SUBJECT_WEIGHT, BODY_WEIGHT, ADDRESS_WEIGHT, ADDRESS_WEIGHT}
+
+	rows, err := store.db.Query(`
+		SELECT mailsearch.mailItemId, offsets(mailsearchindex)
+		FROM mailsearchindex
+			INNER JOIN mailsearch ON mailsearch.searchId=mailsearchindex.docid
+		WHERE mailsearchindex MATCH ?
+	`, strings.Join(terms, "* ")+"*")
+	if err != nil {
+		return result, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var offsets string
+		match := &model.SearchMatch{}
+
+		if err = rows.Scan(&match.MailItemID, &offsets); err != nil {
+			return result, err
+		}
+
+		/*
+		 * offsets() lists four numbers per matching word: the column, the
+		 * search term, the byte offset and the size
+		 */
+		values := strings.Fields(offsets)
+
+		for index := 0; index+3 < len(values); index += 4 {
+			if column, err := strconv.Atoi(values[index]); err == nil && column < len(weights) {
+				match.Rank += weights[column]
+			}
+		}
+
+		result = append(result, match)
+	}
+
+	return result, rows.Err()
+}
+
+/*
+searchMySQL uses the FULLTEXT index in boolean mode, requiring every
+word
+*/
+func (store *SQLMailStore) searchMySQL(terms []string) ([]*model.SearchMatch, error) {
+	query := "+" + strings.Join(terms, "* +") + "*"
+
+	return store.queryMatches(`
+		SELECT mailItemId, MATCH (subject, body, fromAddress, toAddresses) AGAINST (? IN BOOLEAN MODE)
+		FROM mailsearch
+		WHERE MATCH (subject, body, fromAddress, toAddresses) AGAINST (? IN BOOLEAN MODE)
+	`, query, query)
+}
+
+/*
+searchPostgres matches the weighted tsvector and ranks with ts_rank
+*/
+func (store *SQLMailStore) searchPostgres(terms []string) ([]*model.SearchMatch, error) {
+	return store.queryMatches(`
+		SELECT mailItemId, ts_rank(document, to_tsquery('simple', $1))
+		FROM mailsearch
+		WHERE document @@ to_tsquery('simple', $1)
+	`, strings.Join(terms, ":* & ")+":*")
+}
+
+/*
+searchText finds candidates with LIKE and ranks them in Go. It is used
+for engines without a full-text index.
+*/
+func (store *SQLMailStore) searchText(terms []string) ([]*model.SearchMatch, error) {
+	result := make([]*model.SearchMatch, 0)
+	conditions := make([]string, 0, len(terms))
+	parameters := make([]interface{}, 0, len(terms)*4)
+
+	for _, term := range terms {
+		conditions = append(conditions, "(subject LIKE ?"+LIKE_ESCAPE+" OR body LIKE ?"+LIKE_ESCAPE+" OR fromAddress LIKE ?"+LIKE_ESCAPE+" OR toAddresses LIKE ?"+LIKE_ESCAPE+")")
+
+		for index := 0; index < 4; index++ {
+			parameters = append(parameters, likePattern(term))
+		}
+	}
+
+	rows, err := store.db.Query(store.rebind("SELECT mailItemId, subject, body, fromAddress, toAddresses FROM mailsearch WHERE "+strings.Join(conditions, " AND ")), parameters...)
+	if err != nil {
+		return result, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		document := &model.SearchDocument{}
+
+		if err = rows.Scan(&document.MailItemID, &document.Subject, &document.Body, &document.FromAddress, &document.ToAddresses); err != nil {
+			return result, err
+		}
+
+		if rank := rankDocument(document, terms); rank > 0 {
+			result = append(result, &model.SearchMatch{MailItemID: document.MailItemID, Rank: rank})
+		}
+	}
+
+	return result, rows.Err()
+}
+
+func (store *SQLMailStore) queryMatches(query string, parameters ...interface{}) ([]*model.SearchMatch, error) {
+	result := make([]*model.SearchMatch, 0)
+
+	rows, err := store.db.Query(query, parameters...)
+	if err != nil {
+		return result, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		match := &model.SearchMatch{}
+
+		if err = rows.Scan(&match.MailItemID, &match.Rank); err != nil {
+			return result, err
+		}
+
+		result = append(result, match)
+	}
+
+	return result, rows.Err()
+}
+
+/*
+rankDocument scores a document the way the full-text engines do: each
+word starting with a search term adds the weight of the field it is in.
+A document missing any term scores zero.
+*/
+func rankDocument(document *model.SearchDocument, terms []string) float64 {
+	fields := []struct {
+		words  []string
+		weight float64
+	}{
+		{SearchTerms(document.Subject), SUBJECT_WEIGHT},
+		{SearchTerms(document.FromAddress + " " + document.ToAddresses), ADDRESS_WEIGHT},
+		{SearchTerms(document.Body), BODY_WEIGHT},
+	}
+
+	var result float64
+
+	for _, term := range terms {
+		var termRank float64
+
+		for _, field := range fields {
+			for _, word := range field.words {
+				if strings.HasPrefix(word, term) {
+					termRank += field.weight
+				}
+			}
+		}
+
+		if termRank == 0 {
+			return 0
+		}
+
+		result += termRank
+	}
+
+	return result
+}
+
+/*
+sortMatches puts the best matches first
+*/
+func sortMatches(matches []*model.SearchMatch) {
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Rank > matches[j].Rank })
+}
@@ -0,0 +1,76 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package mailstream
+
+import (
+	"sync"
+
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+)
+
+/*
+MailStreamReceiver is a mail item receiver which fans new mail items out
+to any number of subscribers, such as browsers connected to the
+Server-Sent Events endpoint.
+*/
+type MailStreamReceiver struct {
+	lock        sync.RWMutex
+	subscribers map[chan *mailitem.MailItem]bool
+}
+
+/*
+NewMailStreamReceiver creates a new MailStreamReceiver object
+*/
+func NewMailStreamReceiver() *MailStreamReceiver {
+	return &MailStreamReceiver{
+		subscribers: make(map[chan *mailitem.MailItem]bool),
+	}
+}
+
+/*
+Receive hands the new mail item to every subscriber. Subscribers that
+are not keeping up have the item dropped rather than blocking the SMTP
+worker.
+*/
+func (receiver *MailStreamReceiver) Receive(mailItem *mailitem.MailItem) error {
+	receiver.lock.RLock()
+	defer receiver.lock.RUnlock()
+
+	for subscriber := range receiver.subscribers {
+		select {
+		case subscriber <- mailItem:
+		default:
+		}
+	}
+
+	return nil
+}
+
+/*
+Subscribe returns a channel which receives each new mail item. Callers
+must call Unsubscribe when they are done.
+*/
+func (receiver *MailStreamReceiver) Subscribe() chan *mailitem.MailItem {
+	subscriber := make(chan *mailitem.MailItem, 10)
+
+	receiver.lock.Lock()
+	receiver.subscribers[subscriber] = true
+	receiver.lock.Unlock()
+
+	return subscriber
+}
+
+/*
+Unsubscribe removes a subscriber and closes its channel
+*/
+func (receiver *MailStreamReceiver) Unsubscribe(subscriber chan *mailitem.MailItem) {
+	receiver.lock.Lock()
+	defer receiver.lock.Unlock()
+
+	if _, ok := receiver.subscribers[subscriber]; ok {
+		delete(receiver.subscribers, subscriber)
+		close(subscriber)
+	}
+}
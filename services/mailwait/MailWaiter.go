@@ -0,0 +1,213 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package mailwait
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+	"github.com/mailslurper/libmailslurper/model/search"
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+	"github.com/mailslurper/mailslurper/services/mailstream"
+)
+
+const (
+	// SCAN_LIMIT is how many of the newest mail items are checked against the criteria
+	SCAN_LIMIT int = 500
+
+	// RESCAN_INTERVAL is how often stored mail is checked again, in case the stream dropped an item
+	RESCAN_INTERVAL time.Duration = 5 * time.Second
+
+	// MAX_NEAR_MISSES is how many near misses are reported when a wait times out
+	MAX_NEAR_MISSES int = 5
+)
+
+/*
+MailWaiter blocks until mail matching a WaitCriteria has been received.
+Mail already stored is checked first, then each new mail item as it
+arrives on the mail stream.
+*/
+type MailWaiter struct {
+	database   storage.IStorage
+	mailStore  mailstore.IMailStore
+	mailStream *mailstream.MailStreamReceiver
+}
+
+/*
+NewMailWaiter creates a new MailWaiter object
+*/
+func NewMailWaiter(database storage.IStorage, mailStore mailstore.IMailStore, mailStream *mailstream.MailStreamReceiver) *MailWaiter {
+	return &MailWaiter{
+		database:   database,
+		mailStore:  mailStore,
+		mailStream: mailStream,
+	}
+}
+
+/*
+Wait blocks until criteria.Count mail items match, criteria.Timeout
+passes or ctx is done. The second return value is true when enough mail
+matched. Otherwise the response carries the matches found so far and
+the mail items which came closest to matching.
+*/
+func (waiter *MailWaiter) Wait(ctx context.Context, criteria *WaitCriteria) (*model.MailWaitResponse, bool, error) {
+	state := &waitState{
+		criteria: criteria,
+		matches:  make([]mailitem.MailItem, 0),
+		misses:   make(map[string]*model.MailNearMiss),
+		seen:     make(map[string]bool),
+	}
+
+	/*
+	 * Subscribe before scanning, so mail arriving during the scan is
+	 * not missed. Anything seen twice is ignored.
+	 */
+	subscriber := waiter.mailStream.Subscribe()
+	defer waiter.mailStream.Unsubscribe(subscriber)
+
+	if err := waiter.scan(state); err != nil {
+		return nil, false, err
+	}
+
+	timeout := time.NewTimer(criteria.Timeout)
+	defer timeout.Stop()
+
+	rescan := time.NewTicker(RESCAN_INTERVAL)
+	defer rescan.Stop()
+
+	for !state.done() {
+		select {
+		case <-ctx.Done():
+			return state.response(), false, nil
+
+		case <-timeout.C:
+			return state.response(), false, nil
+
+		case <-rescan.C:
+			if err := waiter.scan(state); err != nil {
+				log.Printf("MailSlurper: ERROR - Unable to check stored mail while waiting: %s\n", err.Error())
+			}
+
+		case mailItem, ok := <-subscriber:
+			if !ok {
+				return state.response(), false, nil
+			}
+
+			waiter.check(state, mailItem)
+		}
+	}
+
+	return state.response(), true, nil
+}
+
+func (waiter *MailWaiter) scan(state *waitState) error {
+	mailSearch := &search.MailSearch{
+		OrderByField:     "date",
+		OrderByDirection: "desc",
+	}
+
+	if !state.criteria.ReceivedAfter.IsZero() {
+		mailSearch.Start = state.criteria.ReceivedAfter.In(time.Local).Format("2006-01-02")
+	}
+
+	mailItems, err := waiter.database.GetMailCollection(0, SCAN_LIMIT, mailSearch)
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Check oldest first so matches come back in the order they
+	 * were received
+	 */
+	for index := len(mailItems) - 1; index >= 0 && !state.done(); index-- {
+		waiter.check(state, &mailItems[index])
+	}
+
+	return nil
+}
+
+func (waiter *MailWaiter) check(state *waitState, mailItem *mailitem.MailItem) {
+	var err error
+
+	if state.seen[mailItem.ID] {
+		return
+	}
+
+	headers := make([]*model.MailHeader, 0)
+
+	if state.criteria.NeedsHeaders() {
+		if headers, err = waiter.mailStore.GetMailHeaders(mailItem.ID); err != nil {
+			log.Printf("MailSlurper: ERROR - Unable to read the headers for mail item %s: %s\n", mailItem.ID, err.Error())
+			return
+		}
+	}
+
+	state.seen[mailItem.ID] = true
+	mismatches := state.criteria.Mismatches(mailItem, headers)
+
+	if len(mismatches) == 0 {
+		state.matches = append(state.matches, *mailItem)
+		return
+	}
+
+	state.misses[mailItem.ID] = &model.MailNearMiss{
+		MailItemID:  mailItem.ID,
+		DateSent:    mailItem.DateSent,
+		FromAddress: mailItem.FromAddress,
+		ToAddresses: mailItem.ToAddresses,
+		Subject:     mailItem.Subject,
+		Mismatches:  mismatches,
+	}
+}
+
+type waitState struct {
+	criteria *WaitCriteria
+	matches  []mailitem.MailItem
+	misses   map[string]*model.MailNearMiss
+	seen     map[string]bool
+}
+
+func (state *waitState) done() bool {
+	return len(state.matches) >= state.criteria.Count
+}
+
+/*
+response returns the matches and, when there are not enough of them,
+the near misses with the fewest failed criteria, newest first
+*/
+func (state *waitState) response() *model.MailWaitResponse {
+	result := &model.MailWaitResponse{
+		MailItems: state.matches,
+	}
+
+	if state.done() {
+		return result
+	}
+
+	nearMisses := make([]*model.MailNearMiss, 0, len(state.misses))
+	for _, nearMiss := range state.misses {
+		nearMisses = append(nearMisses, nearMiss)
+	}
+
+	sort.Slice(nearMisses, func(i, j int) bool {
+		if len(nearMisses[i].Mismatches) != len(nearMisses[j].Mismatches) {
+			return len(nearMisses[i].Mismatches) < len(nearMisses[j].Mismatches)
+		}
+
+		return nearMisses[i].DateSent > nearMisses[j].DateSent
+	})
+
+	if len(nearMisses) > MAX_NEAR_MISSES {
+		nearMisses = nearMisses[:MAX_NEAR_MISSES]
+	}
+
+	result.NearMisses = nearMisses
+	return result
+}
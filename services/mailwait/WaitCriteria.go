@@ -0,0 +1,180 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package mailwait
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/mailslurper/mailslurper/services/mailparser"
+	"github.com/mailslurper/mailslurper/services/mailquery"
+)
+
+const (
+	// DEFAULT_TIMEOUT is how long to wait when the request does not say
+	DEFAULT_TIMEOUT time.Duration = 30 * time.Second
+
+	// MAX_TIMEOUT is the longest a request may wait
+	MAX_TIMEOUT time.Duration = 5 * time.Minute
+)
+
+/*
+WaitCriteria describes the mail a test is waiting for. Every criterion
+that is set must match. To and From are case-insensitive substrings of
+a recipient and the sender, Subject is a regular expression, each of
+Headers must be present (an empty value matches any value), and the mail
+must have been received at or after ReceivedAfter, to the second. Count
+is how many matching mail items to wait for.
+*/
+type WaitCriteria struct {
+	To            string
+	From          string
+	Subject       *regexp.Regexp
+	Headers       []*model.MailHeader
+	ReceivedAfter time.Time
+	Count         int
+	Timeout       time.Duration
+}
+
+/*
+NewWaitCriteriaFromValues reads WaitCriteria from the query string of
+GET /mail/wait: to, from, subject, header.<name>, receivedAfter (RFC 3339
+or "2006-01-02 15:04:05" local time), count and timeout (seconds, or a
+duration such as "1m30s").
+*/
+func NewWaitCriteriaFromValues(values url.Values) (*WaitCriteria, error) {
+	var err error
+
+	result := &WaitCriteria{
+		To:      values.Get("to"),
+		From:    values.Get("from"),
+		Headers: mailquery.NewMailQueryFromValues(values).Headers,
+		Count:   1,
+		Timeout: DEFAULT_TIMEOUT,
+	}
+
+	if value := values.Get("subject"); value != "" {
+		if result.Subject, err = regexp.Compile(value); err != nil {
+			return nil, fmt.Errorf("Invalid subject expression: %s", err.Error())
+		}
+	}
+
+	if value := values.Get("receivedAfter"); value != "" {
+		if result.ReceivedAfter, err = parseTime(value); err != nil {
+			return nil, fmt.Errorf("Invalid receivedAfter '%s': use RFC 3339 or %s", value, mailparser.DATE_FORMAT)
+		}
+	}
+
+	if value := values.Get("count"); value != "" {
+		if result.Count, err = strconv.Atoi(value); err != nil || result.Count < 1 {
+			return nil, fmt.Errorf("Invalid count '%s'", value)
+		}
+	}
+
+	if value := values.Get("timeout"); value != "" {
+		if result.Timeout, err = parseTimeout(value); err != nil || result.Timeout <= 0 {
+			return nil, fmt.Errorf("Invalid timeout '%s'", value)
+		}
+	}
+
+	if result.Timeout > MAX_TIMEOUT {
+		result.Timeout = MAX_TIMEOUT
+	}
+
+	return result, nil
+}
+
+/*
+Mismatches returns a description of each criterion a mail item fails.
+A mail item matches when there are none.
+*/
+func (criteria *WaitCriteria) Mismatches(mailItem *mailitem.MailItem, headers []*model.MailHeader) []string {
+	result := make([]string, 0)
+
+	if criteria.To != "" && !anyContains(mailItem.ToAddresses, criteria.To) {
+		result = append(result, fmt.Sprintf("no recipient contains '%s'", criteria.To))
+	}
+
+	if criteria.From != "" && !containsFold(mailItem.FromAddress, criteria.From) {
+		result = append(result, fmt.Sprintf("sender does not contain '%s'", criteria.From))
+	}
+
+	if criteria.Subject != nil && !criteria.Subject.MatchString(mailItem.Subject) {
+		result = append(result, fmt.Sprintf("subject does not match /%s/", criteria.Subject.String()))
+	}
+
+	for _, wanted := range criteria.Headers {
+		if !hasHeader(headers, wanted) {
+			if wanted.Value == "" {
+				result = append(result, fmt.Sprintf("no %s header", wanted.Name))
+			} else {
+				result = append(result, fmt.Sprintf("no %s header with value '%s'", wanted.Name, wanted.Value))
+			}
+		}
+	}
+
+	if !criteria.ReceivedAfter.IsZero() {
+		received, err := time.ParseInLocation(mailparser.DATE_FORMAT, mailItem.DateSent, time.Local)
+		if err != nil || received.Before(criteria.ReceivedAfter.Truncate(time.Second)) {
+			result = append(result, fmt.Sprintf("received before %s", criteria.ReceivedAfter.In(time.Local).Format(mailparser.DATE_FORMAT)))
+		}
+	}
+
+	return result
+}
+
+/*
+NeedsHeaders returns true when matching needs the headers of each mail
+item
+*/
+func (criteria *WaitCriteria) NeedsHeaders() bool {
+	return len(criteria.Headers) > 0
+}
+
+func anyContains(values []string, wanted string) bool {
+	for _, value := range values {
+		if containsFold(value, wanted) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsFold(value, wanted string) bool {
+	return strings.Contains(strings.ToLower(value), strings.ToLower(wanted))
+}
+
+func hasHeader(headers []*model.MailHeader, wanted *model.MailHeader) bool {
+	for _, header := range headers {
+		if strings.EqualFold(header.Name, wanted.Name) && (wanted.Value == "" || header.Value == wanted.Value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseTime(value string) (time.Time, error) {
+	if result, err := time.Parse(time.RFC3339, value); err == nil {
+		return result, nil
+	}
+
+	return time.ParseInLocation(mailparser.DATE_FORMAT, value, time.Local)
+}
+
+func parseTimeout(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,285 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package messagebuilder
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+	"time"
+
+	"github.com/mailslurper/libmailslurper/model/attachment"
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+)
+
+const (
+	// DATE_FORMAT is the format libmailslurper uses for the dateSent column
+	DATE_FORMAT string = "2006-01-02 15:04:05"
+)
+
+/*
+LoadMailItem retrieves a mail item along with the contents of each
+of its attachments.
+*/
+func LoadMailItem(database storage.IStorage, mailID string) (*mailitem.MailItem, error) {
+	var err error
+	var mailItem mailitem.MailItem
+
+	if mailItem, err = database.GetMailByID(mailID); err != nil {
+		return nil, err
+	}
+
+	for index, mailAttachment := range mailItem.Attachments {
+		if mailAttachment.Contents != "" {
+			continue
+		}
+
+		var fullAttachment attachment.Attachment
+
+		if fullAttachment, err = database.GetAttachment(mailID, mailAttachment.ID); err != nil {
+			return nil, err
+		}
+
+		mailItem.Attachments[index] = &fullAttachment
+	}
+
+	return &mailItem, nil
+}
+
+/*
+GetMessageContents returns a mail item as an RFC 5322 message for a
+mail client. The message is returned as it was received when the raw
+message was kept, otherwise it is rebuilt from the stored mail item.
+Line endings are always CRLF.
+*/
+func GetMessageContents(database storage.IStorage, mailStore mailstore.IMailStore, mailID string) ([]byte, error) {
+	raw, err := mailStore.GetRawMessage(mailID)
+	if err != nil {
+		return nil, err
+	}
+
+	if raw != nil {
+		return toCRLF(raw), nil
+	}
+
+	mailItem, err := LoadMailItem(database, mailID)
+	if err != nil {
+		return nil, err
+	}
+
+	return Build(mailItem)
+}
+
+/*
+Build reassembles an RFC 5322 message from a stored mail item. The
+result uses CRLF line endings and is suitable for handing to a mail
+client over POP3 or IMAP, or for saving as an .eml file.
+*/
+func Build(mailItem *mailitem.MailItem) ([]byte, error) {
+	var err error
+	result := &bytes.Buffer{}
+
+	writeHeader(result, "Message-ID", "<"+mailItem.ID+"@mailslurper>")
+	writeHeader(result, "Date", formatDate(mailItem.DateSent))
+	writeHeader(result, "From", mailItem.FromAddress)
+	writeHeader(result, "To", strings.Join(mailItem.ToAddresses, ", "))
+	writeHeader(result, "Subject", mime.QEncoding.Encode("utf-8", mailItem.Subject))
+
+	if mailItem.XMailer != "" {
+		writeHeader(result, "X-Mailer", mailItem.XMailer)
+	}
+
+	writeHeader(result, "MIME-Version", "1.0")
+
+	if len(mailItem.Attachments) == 0 {
+		if err = writeBodyPart(result, mailItem); err != nil {
+			return nil, err
+		}
+
+		return result.Bytes(), nil
+	}
+
+	boundary := "mailslurper-" + mailItem.ID
+
+	writeHeader(result, "Content-Type", fmt.Sprintf("multipart/mixed; boundary=\"%s\"", boundary))
+	result.WriteString("\r\n")
+	result.WriteString("This is a multi-part message in MIME format.\r\n")
+
+	result.WriteString("\r\n--" + boundary + "\r\n")
+
+	if err = writeBodyPart(result, mailItem); err != nil {
+		return nil, err
+	}
+
+	for _, mailAttachment := range mailItem.Attachments {
+		result.WriteString("\r\n--" + boundary + "\r\n")
+		writeAttachmentPart(result, mailAttachment)
+	}
+
+	result.WriteString("\r\n--" + boundary + "--\r\n")
+	return result.Bytes(), nil
+}
+
+/*
+BodyContentType returns the content type of the displayable body of a
+mail item. libmailslurper keeps the content type of the whole message,
+which for multipart messages does not describe the stored body.
+*/
+func BodyContentType(mailItem *mailitem.MailItem) string {
+	mediaType, params, err := mime.ParseMediaType(mailItem.ContentType)
+
+	if err == nil && strings.HasPrefix(mediaType, "text/") {
+		if _, ok := params["charset"]; !ok {
+			params["charset"] = "UTF-8"
+		}
+
+		return mime.FormatMediaType(mediaType, params)
+	}
+
+	if looksLikeHTML(mailItem.Body) {
+		return "text/html; charset=UTF-8"
+	}
+
+	return "text/plain; charset=UTF-8"
+}
+
+/*
+DecodeAttachment returns the raw bytes of an attachment, undoing the
+transfer encoding it was sent with.
+*/
+func DecodeAttachment(mailAttachment *attachment.Attachment) ([]byte, error) {
+	encoding := ""
+	if mailAttachment.Headers != nil {
+		encoding = strings.ToLower(strings.TrimSpace(mailAttachment.Headers.ContentTransferEncoding))
+	}
+
+	switch encoding {
+	case "base64":
+		cleaned := strings.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+				return -1
+			}
+
+			return r
+		}, mailAttachment.Contents)
+
+		return base64.StdEncoding.DecodeString(cleaned)
+
+	case "quoted-printable":
+		return ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(mailAttachment.Contents)))
+	}
+
+	return []byte(mailAttachment.Contents), nil
+}
+
+/*
+toCRLF turns bare LF line endings into CRLF
+*/
+func toCRLF(contents []byte) []byte {
+	if bytes.Count(contents, []byte("\n")) == bytes.Count(contents, []byte("\r\n")) {
+		return contents
+	}
+
+	result := bytes.NewBuffer(make([]byte, 0, len(contents)+len(contents)/32))
+
+	for index, character := range contents {
+		if character == '\n' && (index == 0 || contents[index-1] != '\r') {
+			result.WriteByte('\r')
+		}
+
+		result.WriteByte(character)
+	}
+
+	return result.Bytes()
+}
+
+func writeHeader(result *bytes.Buffer, name, value string) {
+	result.WriteString(name + ": " + value + "\r\n")
+}
+
+func writeBodyPart(result *bytes.Buffer, mailItem *mailitem.MailItem) error {
+	writeHeader(result, "Content-Type", BodyContentType(mailItem))
+	writeHeader(result, "Content-Transfer-Encoding", "quoted-printable")
+	result.WriteString("\r\n")
+
+	writer := quotedprintable.NewWriter(result)
+	if _, err := writer.Write([]byte(mailItem.Body)); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	result.WriteString("\r\n")
+	return nil
+}
+
+func writeAttachmentPart(result *bytes.Buffer, mailAttachment *attachment.Attachment) {
+	contentType := "application/octet-stream"
+	fileName := mailAttachment.ID
+
+	if mailAttachment.Headers != nil {
+		if mailAttachment.Headers.ContentType != "" {
+			contentType = mailAttachment.Headers.ContentType
+		}
+
+		if mailAttachment.Headers.FileName != "" {
+			fileName = mailAttachment.Headers.FileName
+		}
+	}
+
+	contents, err := DecodeAttachment(mailAttachment)
+	if err != nil {
+		contents = []byte(mailAttachment.Contents)
+	}
+
+	if mediaType, params, err := mime.ParseMediaType(contentType); err == nil {
+		params["name"] = fileName
+
+		if formatted := mime.FormatMediaType(mediaType, params); formatted != "" {
+			contentType = formatted
+		}
+	}
+
+	contentDisposition := mime.FormatMediaType("attachment", map[string]string{"filename": fileName})
+	if contentDisposition == "" {
+		contentDisposition = "attachment"
+	}
+
+	writeHeader(result, "Content-Type", contentType)
+	writeHeader(result, "Content-Transfer-Encoding", "base64")
+	writeHeader(result, "Content-Disposition", contentDisposition)
+	result.WriteString("\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString(contents)
+
+	for len(encoded) > 76 {
+		result.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+
+	result.WriteString(encoded + "\r\n")
+}
+
+func formatDate(dateSent string) string {
+	parsed, err := time.ParseInLocation(DATE_FORMAT, dateSent, time.Local)
+	if err != nil {
+		return time.Now().Format(time.RFC1123Z)
+	}
+
+	return parsed.Format(time.RFC1123Z)
+}
+
+func looksLikeHTML(body string) bool {
+	lowerBody := strings.ToLower(body)
+	return strings.Contains(lowerBody, "<html") || strings.Contains(lowerBody, "<body") || strings.Contains(lowerBody, "<div") || strings.Contains(lowerBody, "<p>")
+}
@@ -9,6 +9,12 @@ import (
 
 	"github.com/gorilla/context"
 	"github.com/mailslurper/libmailslurper/configuration"
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/services/dispatch"
+	"github.com/mailslurper/mailslurper/services/faultinjection"
+	"github.com/mailslurper/mailslurper/services/mailextract"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+	"github.com/mailslurper/mailslurper/services/mailstream"
 )
 
 /*
@@ -16,9 +22,20 @@ AppContext holds context data for the application. This can hold information
 such as a database connection, session data, user info, and more. Your middlewares
 should attach functions to this structure to pass critical data to request
 handlers.
+
+Database and MailStore are the storage of the server handling the
+request. ServicePathPrefix is where the web listener serves the service
+tier in single port mode, and empty otherwise.
 */
 type AppContext struct {
-	Config *configuration.Configuration
+	Config            *configuration.Configuration
+	Database          storage.IStorage
+	MailStore         mailstore.IMailStore
+	MailStream        *mailstream.MailStreamReceiver
+	FaultRules        *faultinjection.FaultRuleEngine
+	MailExtractor     *mailextract.MailExtractor
+	Dispatcher        *dispatch.MailDispatcher
+	ServicePathPrefix string
 }
 
 /*
@@ -29,6 +46,13 @@ Context which comes across in the request.
 func (ctx *AppContext) StartAppContext(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		context.Set(request, "config", ctx.Config)
+		context.Set(request, "database", ctx.Database)
+		context.Set(request, "mailStore", ctx.MailStore)
+		context.Set(request, "mailStream", ctx.MailStream)
+		context.Set(request, "faultRules", ctx.FaultRules)
+		context.Set(request, "mailExtractor", ctx.MailExtractor)
+		context.Set(request, "dispatcher", ctx.Dispatcher)
+		context.Set(request, "servicePathPrefix", ctx.ServicePathPrefix)
 
 		h.ServeHTTP(writer, request)
 	})
@@ -0,0 +1,272 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package migration
+
+import (
+	"fmt"
+)
+
+/*
+Migration is one numbered change to the database schema. Statements
+returns the SQL for an engine ("sqlite", "mysql", "mssql" or
+"postgres"); an engine that needs no change gets no statements, and the
+migration is simply recorded.
+*/
+type Migration struct {
+	Version     int
+	Description string
+	Statements  func(engine string) []string
+}
+
+/*
+MIGRATIONS lists every schema migration in the order they are applied.
+Append new migrations to the end and never change one that has been
+released.
+*/
+var MIGRATIONS = []*Migration{
+	{
+		Version:     1,
+		Description: "Create the MailSlurper tables",
+		Statements:  createTables,
+	},
+	{
+		Version:     2,
+		Description: "Widen mailitem.fromAddress to 255 characters",
+		Statements:  widenFromAddress,
+	},
+	{
+		Version:     3,
+		Description: "Add the full-text search index",
+		Statements:  createSearchIndex,
+	},
+	{
+		Version:     4,
+		Description: "Store the headers of each mail item",
+		Statements:  createHeaderTable,
+	},
+}
+
+/*
+createTables creates the tables MailSlurper keeps alongside the mail
+items. SQLite and PostgreSQL storage create the mail item and
+attachment tables themselves; MySQL and MSSQL get them here, as
+described by the scripts in the scripts directory.
+*/
+func createTables(engine string) []string {
+	result := make([]string, 0)
+
+	if engine == "mysql" || engine == "mssql" {
+		result = append(result,
+			createTable(engine, "mailitem", `mailitem (
+				id VARCHAR(36) NOT NULL PRIMARY KEY,
+				dateSent DATETIME,
+				fromAddress VARCHAR(50) NOT NULL,
+				toAddressList VARCHAR(1024) NOT NULL,
+				subject VARCHAR(255),
+				xmailer VARCHAR(50),
+				body TEXT,
+				contentType VARCHAR(50),
+				boundary VARCHAR(255)
+			)`),
+			createTable(engine, "attachment", `attachment (
+				id VARCHAR(36) NOT NULL PRIMARY KEY,
+				mailItemId VARCHAR(36) NOT NULL,
+				fileName VARCHAR(255),
+				contentType VARCHAR(50),
+				content TEXT
+			)`),
+		)
+	}
+
+	return append(result,
+		createTable(engine, "mailmetadata", `mailmetadata (
+			mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+			tlsVersion VARCHAR(20),
+			tlsCipher VARCHAR(100),
+			authUser VARCHAR(255),
+			tag VARCHAR(100)
+		)`),
+		createTable(engine, "mailsession", `mailsession (
+			mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+			clientIp VARCHAR(45),
+			clientPort INT,
+			helo VARCHAR(255),
+			mailFrom VARCHAR(255),
+			rcptTo TEXT,
+			authUser VARCHAR(255),
+			tlsVersion VARCHAR(20),
+			tlsCipher VARCHAR(100),
+			startedAt VARCHAR(20),
+			transcript TEXT
+		)`),
+		createTable(engine, "mailraw", `mailraw (
+			mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+			content `+binaryType(engine)+`
+		)`),
+		createTable(engine, "attachmentref", `attachmentref (
+			mailItemId VARCHAR(36) NOT NULL,
+			attachmentHash VARCHAR(64) NOT NULL
+		)`),
+	)
+}
+
+/*
+widenFromAddress makes room for long sender addresses. SQLite does not
+enforce column lengths and PostgreSQL uses TEXT, so only MySQL and
+MSSQL change.
+*/
+func widenFromAddress(engine string) []string {
+	switch engine {
+	case "mysql":
+		return []string{"ALTER TABLE mailitem MODIFY fromAddress VARCHAR(255) NOT NULL"}
+	case "mssql":
+		return []string{"ALTER TABLE mailitem ALTER COLUMN fromAddress VARCHAR(255) NOT NULL"}
+	}
+
+	return []string{}
+}
+
+/*
+createSearchIndex creates the table holding the searchable text of each
+mail item and the engine's full-text index over it. SQLite uses an FTS4
+table reading from mailsearch, MySQL an InnoDB FULLTEXT index (MySQL
+5.6 or later), so the index is written in the same transactions as the
+mail, and PostgreSQL a GIN index on a tsvector column. MSSQL full-text search needs a catalog
+set up by hand, so MSSQL only gets the table.
+*/
+func createSearchIndex(engine string) []string {
+	switch engine {
+	case "sqlite":
+		return []string{
+			createTable(engine, "mailsearch", `mailsearch (
+				searchId INTEGER PRIMARY KEY,
+				mailItemId VARCHAR(36) NOT NULL UNIQUE,
+				subject TEXT,
+				body TEXT,
+				fromAddress TEXT,
+				toAddresses TEXT
+			)`),
+			`CREATE VIRTUAL TABLE IF NOT EXISTS mailsearchindex USING fts4(content="mailsearch", subject, body, fromAddress, toAddresses)`,
+		}
+
+	case "mysql":
+		return []string{
+			createTable(engine, "mailsearch", `mailsearch (
+				mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+				subject TEXT,
+				body MEDIUMTEXT,
+				fromAddress VARCHAR(255),
+				toAddresses TEXT,
+				FULLTEXT ft_mailsearch (subject, body, fromAddress, toAddresses)
+			) ENGINE=InnoDB`),
+		}
+
+	case "mssql":
+		return []string{
+			createTable(engine, "mailsearch", `mailsearch (
+				mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+				subject VARCHAR(MAX),
+				body VARCHAR(MAX),
+				fromAddress VARCHAR(255),
+				toAddresses VARCHAR(MAX)
+			)`),
+		}
+
+	case "postgres":
+		return []string{
+			createTable(engine, "mailsearch", `mailsearch (
+				mailItemId VARCHAR(36) NOT NULL PRIMARY KEY,
+				subject TEXT,
+				body TEXT,
+				fromAddress TEXT,
+				toAddresses TEXT,
+				document TSVECTOR
+			)`),
+			"CREATE INDEX IF NOT EXISTS idx_mailsearch_document ON mailsearch USING gin (document)",
+		}
+	}
+
+	return []string{}
+}
+
+/*
+createHeaderTable creates the table holding every header of each mail
+item, indexed by mail item and by header. headerKey is the header name
+in lower case, so header queries are not case sensitive on any engine.
+MySQL cannot create an index only when it is missing, so its indexes
+are part of the table.
+*/
+func createHeaderTable(engine string) []string {
+	valueType := "TEXT"
+	indexes := ""
+
+	switch engine {
+	case "mssql":
+		valueType = "VARCHAR(MAX)"
+	case "mysql":
+		indexes = `,
+			INDEX idx_mailheader_mailItemId (mailItemId),
+			INDEX idx_mailheader_headerKey (headerKey)`
+	}
+
+	result := []string{
+		createTable(engine, "mailheader", `mailheader (
+			mailItemId VARCHAR(36) NOT NULL,
+			headerIndex INT NOT NULL,
+			headerName VARCHAR(255) NOT NULL,
+			headerKey VARCHAR(255) NOT NULL,
+			headerValue `+valueType+indexes+`
+		)`),
+	}
+
+	if engine == "mysql" {
+		return result
+	}
+
+	return append(result,
+		createIndex(engine, "idx_mailheader_mailItemId", "mailheader (mailItemId)"),
+		createIndex(engine, "idx_mailheader_headerKey", "mailheader (headerKey)"),
+	)
+}
+
+/*
+createIndex returns a statement creating an index when it does not
+exist yet
+*/
+func createIndex(engine, name, definition string) string {
+	if engine == "mssql" {
+		return fmt.Sprintf("IF NOT EXISTS (SELECT * FROM sys.indexes WHERE name='%s') CREATE INDEX %s ON %s", name, name, definition)
+	}
+
+	return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s", name, definition)
+}
+
+/*
+createTable returns a statement creating a table when it does not exist
+yet
+*/
+func createTable(engine, name, definition string) string {
+	if engine == "mssql" {
+		return fmt.Sprintf("IF NOT EXISTS (SELECT * FROM sysobjects WHERE name='%s' AND xtype='U') CREATE TABLE %s", name, definition)
+	}
+
+	return "CREATE TABLE IF NOT EXISTS " + definition
+}
+
+/*
+binaryType returns the column type used for raw message bytes
+*/
+func binaryType(engine string) string {
+	switch engine {
+	case "mysql":
+		return "LONGBLOB"
+	case "mssql":
+		return "VARBINARY(MAX)"
+	case "postgres":
+		return "BYTEA"
+	}
+
+	return "BLOB"
+}
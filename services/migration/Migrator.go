@@ -0,0 +1,196 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package migration
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// VERSION_TABLE records each migration applied to a database
+	VERSION_TABLE string = "schemaversion"
+
+	// APPLIED_AT_FORMAT is how the time a migration was applied is recorded
+	APPLIED_AT_FORMAT string = "2006-01-02 15:04:05"
+)
+
+/*
+Migrator brings a database schema up to date by applying the migrations
+it has not seen yet, in order. The version of every applied migration is
+recorded in the schemaversion table.
+*/
+type Migrator struct {
+	db     *sql.DB
+	engine string
+}
+
+/*
+NewMigrator creates a new Migrator object for a database connection.
+engine is one of "sqlite", "mysql", "mssql" or "postgres".
+*/
+func NewMigrator(db *sql.DB, engine string) *Migrator {
+	return &Migrator{
+		db:     db,
+		engine: engine,
+	}
+}
+
+/*
+GetVersion returns the version of the last migration applied. A
+database that has never been migrated is at version zero.
+*/
+func (migrator *Migrator) GetVersion() (int, error) {
+	var version int
+
+	exists, err := migrator.versionTableExists()
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	err = migrator.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM " + VERSION_TABLE).Scan(&version)
+	return version, err
+}
+
+/*
+GetPendingMigrations returns the migrations not applied yet, in the
+order they will be applied
+*/
+func (migrator *Migrator) GetPendingMigrations() ([]*Migration, error) {
+	result := make([]*Migration, 0)
+
+	version, err := migrator.GetVersion()
+	if err != nil {
+		return result, err
+	}
+
+	for _, migration := range MIGRATIONS {
+		if migration.Version > version {
+			result = append(result, migration)
+		}
+	}
+
+	return result, nil
+}
+
+/*
+Migrate applies every pending migration and returns how many were
+applied. Each migration and its version record are applied in one
+transaction, so a failed migration is attempted again next time.
+Engines that cannot roll back schema changes (MySQL) may be left
+part way through a failed migration.
+*/
+func (migrator *Migrator) Migrate() (int, error) {
+	if _, err := migrator.db.Exec(migrator.versionTableStatement()); err != nil {
+		return 0, err
+	}
+
+	pending, err := migrator.GetPendingMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	for index, migration := range pending {
+		if err = migrator.apply(migration); err != nil {
+			return index, fmt.Errorf("Migration %d (%s) failed: %s", migration.Version, migration.Description, err.Error())
+		}
+
+		log.Printf("MailSlurper: INFO - Applied database migration %d: %s\n", migration.Version, migration.Description)
+	}
+
+	return len(pending), nil
+}
+
+/*
+WritePendingSQL writes the SQL Migrate would run, without running it
+*/
+func (migrator *Migrator) WritePendingSQL(writer io.Writer) error {
+	pending, err := migrator.GetPendingMigrations()
+	if err != nil {
+		return err
+	}
+
+	if len(pending) == 0 {
+		fmt.Fprintf(writer, "-- The %s database schema is up to date\n", migrator.engine)
+		return nil
+	}
+
+	fmt.Fprintf(writer, "%s;\n", migrator.versionTableStatement())
+
+	for _, migration := range pending {
+		fmt.Fprintf(writer, "\n-- Migration %d: %s\n", migration.Version, migration.Description)
+
+		for _, statement := range migration.Statements(migrator.engine) {
+			fmt.Fprintf(writer, "%s;\n", statement)
+		}
+
+		fmt.Fprintf(writer, "INSERT INTO %s (version, description, appliedAt) VALUES (%d, '%s', '%s');\n", VERSION_TABLE, migration.Version, strings.Replace(migration.Description, "'", "''", -1), time.Now().Format(APPLIED_AT_FORMAT))
+	}
+
+	return nil
+}
+
+func (migrator *Migrator) apply(migration *Migration) error {
+	transaction, err := migrator.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range migration.Statements(migrator.engine) {
+		if _, err = transaction.Exec(statement); err != nil {
+			transaction.Rollback()
+			return err
+		}
+	}
+
+	insert := "INSERT INTO " + VERSION_TABLE + " (version, description, appliedAt) VALUES (?, ?, ?)"
+	if migrator.engine == "postgres" {
+		insert = "INSERT INTO " + VERSION_TABLE + " (version, description, appliedAt) VALUES ($1, $2, $3)"
+	}
+
+	if _, err = transaction.Exec(insert, migration.Version, migration.Description, time.Now().Format(APPLIED_AT_FORMAT)); err != nil {
+		transaction.Rollback()
+		return err
+	}
+
+	return transaction.Commit()
+}
+
+func (migrator *Migrator) versionTableStatement() string {
+	return createTable(migrator.engine, VERSION_TABLE, VERSION_TABLE+` (
+		version INT NOT NULL PRIMARY KEY,
+		description VARCHAR(255),
+		appliedAt VARCHAR(20)
+	)`)
+}
+
+/*
+versionTableExists asks the database's catalog whether the version
+table has been created
+*/
+func (migrator *Migrator) versionTableExists() (bool, error) {
+	var query string
+	var count int
+
+	switch migrator.engine {
+	case "sqlite":
+		query = "SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?"
+	case "mysql":
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema=DATABASE() AND table_name=?"
+	case "mssql":
+		query = "SELECT COUNT(*) FROM sysobjects WHERE xtype='U' AND name=?"
+	case "postgres":
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema=current_schema() AND table_name=$1"
+	default:
+		return false, fmt.Errorf("Unsupported database engine '%s'", migrator.engine)
+	}
+
+	err := migrator.db.QueryRow(query, VERSION_TABLE).Scan(&count)
+	return count > 0, err
+}
@@ -0,0 +1,102 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package pop3
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+)
+
+/*
+POP3Server is a POP3 listener which lets real mail clients download the
+mail MailSlurper has captured. Typical usage is to call NewPOP3Server(),
+then Start, and Close when shutting down.
+*/
+type POP3Server struct {
+	Address  string
+	Port     int
+	UserName string
+	Password string
+
+	database  storage.IStorage
+	mailStore mailstore.IMailStore
+	listener  net.Listener
+}
+
+/*
+NewPOP3Server creates a new POP3Server object. When userName is empty
+any credentials are accepted.
+*/
+func NewPOP3Server(
+	address string,
+	port int,
+	userName string,
+	password string,
+	database storage.IStorage,
+	mailStore mailstore.IMailStore,
+) *POP3Server {
+	return &POP3Server{
+		Address:  address,
+		Port:     port,
+		UserName: userName,
+		Password: password,
+
+		database:  database,
+		mailStore: mailStore,
+	}
+}
+
+/*
+Start opens the listener and begins accepting connections in the
+background.
+*/
+func (server *POP3Server) Start() error {
+	var err error
+
+	if server.listener, err = net.Listen("tcp", fmt.Sprintf("%s:%d", server.Address, server.Port)); err != nil {
+		return err
+	}
+
+	log.Printf("MailSlurper: INFO - POP3 listener started on %s:%d\n", server.Address, server.Port)
+
+	go server.acceptConnections()
+	return nil
+}
+
+/*
+Close stops accepting new connections
+*/
+func (server *POP3Server) Close() error {
+	if server.listener == nil {
+		return nil
+	}
+
+	return server.listener.Close()
+}
+
+func (server *POP3Server) acceptConnections() {
+	for {
+		connection, err := server.listener.Accept()
+		if err != nil {
+			log.Printf("MailSlurper: INFO - POP3 listener stopped: %s\n", err.Error())
+			return
+		}
+
+		session := newPOP3Session(server, connection)
+		go session.run()
+	}
+}
+
+func (server *POP3Server) authenticate(userName, password string) bool {
+	if server.UserName == "" {
+		return true
+	}
+
+	return userName == server.UserName && password == server.Password
+}
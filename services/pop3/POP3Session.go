@@ -0,0 +1,397 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package pop3
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mailslurper/libmailslurper/model/search"
+	"github.com/mailslurper/mailslurper/services/messagebuilder"
+)
+
+const (
+	STATE_AUTHORIZATION int = iota
+	STATE_TRANSACTION
+)
+
+const (
+	SESSION_TIMEOUT time.Duration = 10 * time.Minute
+)
+
+type pop3Message struct {
+	mailID   string
+	contents []byte
+	deleted  bool
+}
+
+type pop3Session struct {
+	server     *POP3Server
+	connection net.Conn
+	reader     *bufio.Reader
+	state      int
+	userName   string
+	messages   []*pop3Message
+}
+
+func newPOP3Session(server *POP3Server, connection net.Conn) *pop3Session {
+	return &pop3Session{
+		server:     server,
+		connection: connection,
+		reader:     bufio.NewReader(connection),
+		state:      STATE_AUTHORIZATION,
+	}
+}
+
+func (session *pop3Session) run() {
+	defer session.connection.Close()
+
+	session.writeLine("+OK MailSlurper POP3 server ready")
+
+	for {
+		session.connection.SetReadDeadline(time.Now().Add(SESSION_TIMEOUT))
+
+		line, err := session.reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		command, argument := splitCommand(line)
+
+		if command == "QUIT" {
+			session.quit()
+			return
+		}
+
+		session.dispatch(command, argument)
+	}
+}
+
+func (session *pop3Session) dispatch(command, argument string) {
+	if session.state == STATE_AUTHORIZATION {
+		switch command {
+		case "CAPA":
+			session.capa()
+		case "USER":
+			session.user(argument)
+		case "PASS":
+			session.pass(argument)
+		default:
+			session.writeLine("-ERR Not allowed before authentication")
+		}
+
+		return
+	}
+
+	switch command {
+	case "CAPA":
+		session.capa()
+	case "STAT":
+		session.stat()
+	case "LIST":
+		session.list(argument)
+	case "UIDL":
+		session.uidl(argument)
+	case "RETR":
+		session.retr(argument)
+	case "TOP":
+		session.top(argument)
+	case "DELE":
+		session.dele(argument)
+	case "RSET":
+		session.rset()
+	case "NOOP":
+		session.writeLine("+OK")
+	default:
+		session.writeLine("-ERR Unknown command")
+	}
+}
+
+func (session *pop3Session) capa() {
+	session.writeLine("+OK Capability list follows")
+	session.writeLine("USER")
+	session.writeLine("TOP")
+	session.writeLine("UIDL")
+	session.writeLine(".")
+}
+
+func (session *pop3Session) user(argument string) {
+	if argument == "" {
+		session.writeLine("-ERR A user name is required")
+		return
+	}
+
+	session.userName = argument
+	session.writeLine("+OK")
+}
+
+func (session *pop3Session) pass(argument string) {
+	var err error
+
+	if session.userName == "" {
+		session.writeLine("-ERR USER first")
+		return
+	}
+
+	if !session.server.authenticate(session.userName, argument) {
+		session.userName = ""
+		session.writeLine("-ERR Invalid user name or password")
+		return
+	}
+
+	if err = session.loadMailbox(); err != nil {
+		log.Printf("MailSlurper: ERROR - POP3 could not load mailbox: %s\n", err.Error())
+		session.writeLine("-ERR Unable to read the mailbox")
+		return
+	}
+
+	session.state = STATE_TRANSACTION
+	session.writeLine(fmt.Sprintf("+OK %d message(s)", len(session.messages)))
+}
+
+func (session *pop3Session) stat() {
+	count := 0
+	size := 0
+
+	for _, message := range session.messages {
+		if !message.deleted {
+			count++
+			size += len(message.contents)
+		}
+	}
+
+	session.writeLine(fmt.Sprintf("+OK %d %d", count, size))
+}
+
+func (session *pop3Session) list(argument string) {
+	if argument != "" {
+		if index, message := session.findMessage(argument); message != nil {
+			session.writeLine(fmt.Sprintf("+OK %d %d", index, len(message.contents)))
+		}
+
+		return
+	}
+
+	session.writeLine("+OK Scan listing follows")
+
+	for index, message := range session.messages {
+		if !message.deleted {
+			session.writeLine(fmt.Sprintf("%d %d", index+1, len(message.contents)))
+		}
+	}
+
+	session.writeLine(".")
+}
+
+func (session *pop3Session) uidl(argument string) {
+	if argument != "" {
+		if index, message := session.findMessage(argument); message != nil {
+			session.writeLine(fmt.Sprintf("+OK %d %s", index, message.mailID))
+		}
+
+		return
+	}
+
+	session.writeLine("+OK Unique-ID listing follows")
+
+	for index, message := range session.messages {
+		if !message.deleted {
+			session.writeLine(fmt.Sprintf("%d %s", index+1, message.mailID))
+		}
+	}
+
+	session.writeLine(".")
+}
+
+func (session *pop3Session) retr(argument string) {
+	_, message := session.findMessage(argument)
+	if message == nil {
+		return
+	}
+
+	session.writeLine(fmt.Sprintf("+OK %d octets", len(message.contents)))
+	session.writeMultiLine(message.contents)
+}
+
+func (session *pop3Session) top(argument string) {
+	arguments := strings.Fields(argument)
+	if len(arguments) != 2 {
+		session.writeLine("-ERR Usage: TOP msg n")
+		return
+	}
+
+	lineCount, err := strconv.Atoi(arguments[1])
+	if err != nil || lineCount < 0 {
+		session.writeLine("-ERR Invalid line count")
+		return
+	}
+
+	_, message := session.findMessage(arguments[0])
+	if message == nil {
+		return
+	}
+
+	headers := message.contents
+	var body []byte
+
+	if separator := bytes.Index(message.contents, []byte("\r\n\r\n")); separator > -1 {
+		headers = message.contents[:separator+2]
+		body = message.contents[separator+4:]
+	}
+
+	bodyLines := bytes.SplitAfter(body, []byte("\r\n"))
+	if lineCount < len(bodyLines) {
+		bodyLines = bodyLines[:lineCount]
+	}
+
+	result := append([]byte{}, headers...)
+	result = append(result, []byte("\r\n")...)
+	result = append(result, bytes.Join(bodyLines, nil)...)
+
+	session.writeLine("+OK Top of message follows")
+	session.writeMultiLine(result)
+}
+
+func (session *pop3Session) dele(argument string) {
+	index, message := session.findMessage(argument)
+	if message == nil {
+		return
+	}
+
+	message.deleted = true
+	session.writeLine(fmt.Sprintf("+OK Message %d deleted", index))
+}
+
+func (session *pop3Session) rset() {
+	for _, message := range session.messages {
+		message.deleted = false
+	}
+
+	session.writeLine(fmt.Sprintf("+OK %d message(s)", len(session.messages)))
+}
+
+/*
+quit enters the UPDATE state. Messages marked with DELE are removed
+from storage before the connection closes.
+*/
+func (session *pop3Session) quit() {
+	if session.state == STATE_TRANSACTION {
+		for _, message := range session.messages {
+			if !message.deleted {
+				continue
+			}
+
+			if err := session.server.mailStore.DeleteMail(message.mailID); err != nil {
+				log.Printf("MailSlurper: ERROR - POP3 could not delete mail item %s: %s\n", message.mailID, err.Error())
+				session.writeLine("-ERR Some deleted messages were not removed")
+				return
+			}
+		}
+	}
+
+	session.writeLine("+OK MailSlurper POP3 server signing off")
+}
+
+/*
+loadMailbox takes a snapshot of every stored mail item, oldest first,
+for the rest of the session.
+*/
+func (session *pop3Session) loadMailbox() error {
+	mailSearch := &search.MailSearch{
+		OrderByField:     "date",
+		OrderByDirection: "asc",
+	}
+
+	mailCount, err := session.server.database.GetMailCount(mailSearch)
+	if err != nil {
+		return err
+	}
+
+	mailItems, err := session.server.database.GetMailCollection(0, mailCount, mailSearch)
+	if err != nil {
+		return err
+	}
+
+	session.messages = make([]*pop3Message, 0, len(mailItems))
+
+	for _, mailItem := range mailItems {
+		contents, err := messagebuilder.GetMessageContents(session.server.database, session.server.mailStore, mailItem.ID)
+		if err != nil {
+			return err
+		}
+
+		session.messages = append(session.messages, &pop3Message{
+			mailID:   mailItem.ID,
+			contents: contents,
+		})
+	}
+
+	return nil
+}
+
+/*
+findMessage resolves a 1-based message number. An error response is
+written and nil returned when the message does not exist.
+*/
+func (session *pop3Session) findMessage(argument string) (int, *pop3Message) {
+	index, err := strconv.Atoi(strings.TrimSpace(argument))
+	if err != nil || index < 1 || index > len(session.messages) || session.messages[index-1].deleted {
+		session.writeLine("-ERR No such message")
+		return 0, nil
+	}
+
+	return index, session.messages[index-1]
+}
+
+func (session *pop3Session) writeLine(line string) {
+	fmt.Fprintf(session.connection, "%s\r\n", line)
+}
+
+/*
+writeMultiLine writes a dot-stuffed multi-line response followed by the
+termination octet.
+*/
+func (session *pop3Session) writeMultiLine(contents []byte) {
+	writer := bufio.NewWriter(session.connection)
+
+	for _, line := range bytes.SplitAfter(contents, []byte("\r\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		if line[0] == '.' {
+			writer.WriteByte('.')
+		}
+
+		writer.Write(line)
+	}
+
+	if !bytes.HasSuffix(contents, []byte("\r\n")) {
+		writer.WriteString("\r\n")
+	}
+
+	writer.WriteString(".\r\n")
+	writer.Flush()
+}
+
+func splitCommand(line string) (string, string) {
+	line = strings.TrimRight(line, "\r\n")
+	parts := strings.SplitN(line, " ", 2)
+
+	command := strings.ToUpper(parts[0])
+	argument := ""
+
+	if len(parts) > 1 {
+		argument = strings.TrimSpace(parts[1])
+	}
+
+	return command, argument
+}
@@ -0,0 +1,169 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package pop3
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+	"github.com/mailslurper/mailslurper/services/sessiontest"
+)
+
+/*
+newTestMailbox returns a store holding messageCount mail items, oldest
+first, along with their IDs
+*/
+func newTestMailbox(t *testing.T, messageCount int) (*mailstore.MemoryStore, []string) {
+	store := mailstore.NewMemoryStore(0)
+	mailIDs := make([]string, 0, messageCount)
+
+	for index := 1; index <= messageCount; index++ {
+		mailID, err := store.StoreMail(&mailitem.MailItem{
+			DateSent: fmt.Sprintf("2016-01-0%d 10:00:00", index),
+			Subject:  fmt.Sprintf("Message %d", index),
+		})
+		if err != nil {
+			t.Fatalf("unable to store mail: %s", err.Error())
+		}
+
+		if err = store.StoreRawMessage(mailID, []byte(fmt.Sprintf("Subject: Message %d\r\n\r\nBody %d\r\n", index, index))); err != nil {
+			t.Fatalf("unable to store mail: %s", err.Error())
+		}
+
+		mailIDs = append(mailIDs, mailID)
+	}
+
+	return store, mailIDs
+}
+
+/*
+runSession sends each command over a session with the store and returns
+the first line of each reply. Only commands with single line replies
+can be used. The connection is dropped after the last command.
+*/
+func runSession(store *mailstore.MemoryStore, commands []string) []string {
+	server := NewPOP3Server("127.0.0.1", 0, "", "", store, store)
+	client := sessiontest.NewClient(func(connection net.Conn) {
+		newPOP3Session(server, connection).run()
+	})
+
+	client.ReadLine()
+	result := make([]string, 0, len(commands))
+
+	for _, command := range commands {
+		client.Send(command + "\r\n")
+
+		line, err := client.ReadLine()
+		if err != nil {
+			break
+		}
+
+		result = append(result, line)
+	}
+
+	client.Close()
+	client.Ended()
+
+	return result
+}
+
+func TestSessionDeletion(t *testing.T) {
+	tests := []struct {
+		name      string
+		commands  []string
+		expected  []string
+		remaining []int
+	}{
+		{
+			name:      "QUIT removes deleted messages",
+			commands:  []string{"USER u", "PASS p", "DELE 1", "DELE 3", "QUIT"},
+			expected:  []string{"+OK", "+OK 3 message(s)", "+OK Message 1 deleted", "+OK Message 3 deleted", "+OK MailSlurper POP3 server signing off"},
+			remaining: []int{2},
+		},
+		{
+			name:      "RSET undoes DELE",
+			commands:  []string{"USER u", "PASS p", "DELE 1", "RSET", "STAT", "QUIT"},
+			expected:  []string{"+OK", "+OK 3 message(s)", "+OK Message 1 deleted", "+OK 3 message(s)", "+OK 3 90", "+OK MailSlurper POP3 server signing off"},
+			remaining: []int{1, 2, 3},
+		},
+		{
+			name:      "deleted messages are hidden until QUIT",
+			commands:  []string{"USER u", "PASS p", "DELE 2", "STAT", "RETR 2", "LIST 2", "DELE 2", "LIST 3", "QUIT"},
+			expected:  []string{"+OK", "+OK 3 message(s)", "+OK Message 2 deleted", "+OK 2 60", "-ERR No such message", "-ERR No such message", "-ERR No such message", "+OK 3 30", "+OK MailSlurper POP3 server signing off"},
+			remaining: []int{1, 3},
+		},
+		{
+			name:      "dropping the connection keeps deleted messages",
+			commands:  []string{"USER u", "PASS p", "DELE 1"},
+			expected:  []string{"+OK", "+OK 3 message(s)", "+OK Message 1 deleted"},
+			remaining: []int{1, 2, 3},
+		},
+		{
+			name:      "QUIT before logging in deletes nothing",
+			commands:  []string{"USER u", "DELE 1", "QUIT"},
+			expected:  []string{"+OK", "-ERR Not allowed before authentication", "+OK MailSlurper POP3 server signing off"},
+			remaining: []int{1, 2, 3},
+		},
+		{
+			name:      "invalid message numbers",
+			commands:  []string{"USER u", "PASS p", "DELE 0", "DELE 4", "DELE x", "QUIT"},
+			expected:  []string{"+OK", "+OK 3 message(s)", "-ERR No such message", "-ERR No such message", "-ERR No such message", "+OK MailSlurper POP3 server signing off"},
+			remaining: []int{1, 2, 3},
+		},
+	}
+
+	for _, test := range tests {
+		store, mailIDs := newTestMailbox(t, 3)
+
+		actual := runSession(store, test.commands)
+
+		if strings.Join(actual, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("%s: expected replies %q, got %q", test.name, test.expected, actual)
+		}
+
+		remaining, _ := store.GetMailIDs(nil)
+		expected := make([]string, 0, len(test.remaining))
+
+		for _, number := range test.remaining {
+			expected = append(expected, mailIDs[number-1])
+		}
+
+		if len(remaining) != len(expected) {
+			t.Errorf("%s: expected %d mail item(s) to remain, got %d", test.name, len(expected), len(remaining))
+			continue
+		}
+
+		for _, mailID := range expected {
+			if _, err := store.GetMailByID(mailID); err != nil {
+				t.Errorf("%s: expected mail item %s to remain", test.name, mailID)
+			}
+		}
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		line     string
+		command  string
+		argument string
+	}{
+		{line: "QUIT\r\n", command: "QUIT"},
+		{line: "dele 1\r\n", command: "DELE", argument: "1"},
+		{line: "TOP 1  10 \n", command: "TOP", argument: "1  10"},
+		{line: "PASS pass word\r\n", command: "PASS", argument: "pass word"},
+	}
+
+	for _, test := range tests {
+		command, argument := splitCommand(test.line)
+
+		if command != test.command || argument != test.argument {
+			t.Errorf("%q: expected %q %q, got %q %q", test.line, test.command, test.argument, command, argument)
+		}
+	}
+}
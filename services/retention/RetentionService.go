@@ -0,0 +1,182 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package retention
+
+import (
+	"log"
+	"time"
+
+	"github.com/mailslurper/libmailslurper/model/search"
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+)
+
+const (
+	// DEFAULT_INTERVAL_MINUTES is how often the policy is applied when the configuration does not say
+	DEFAULT_INTERVAL_MINUTES int = 60
+
+	// CUTOFF_DATE_FORMAT is the date passed to the storage engine when removing old mail
+	CUTOFF_DATE_FORMAT string = "2006-01-02"
+)
+
+/*
+RetentionService applies a retention policy in the background. The
+policy is applied once when the service starts and then on a schedule
+until Close is called. Everything removed is logged.
+*/
+type RetentionService struct {
+	policy    *model.RetentionPolicy
+	mailStore mailstore.IMailStore
+	done      chan bool
+}
+
+/*
+NewRetentionService creates a new RetentionService object
+*/
+func NewRetentionService(policy *model.RetentionPolicy, mailStore mailstore.IMailStore) *RetentionService {
+	return &RetentionService{
+		policy:    policy,
+		mailStore: mailStore,
+		done:      make(chan bool),
+	}
+}
+
+/*
+Start applies the policy now and then on its schedule, in a goroutine
+*/
+func (service *RetentionService) Start() {
+	interval := service.policy.IntervalMinutes
+	if interval <= 0 {
+		interval = DEFAULT_INTERVAL_MINUTES
+	}
+
+	log.Printf("MailSlurper: INFO - Retention policy (max age: %d day(s), max mail: %d, max storage: %d bytes) applied every %d minute(s)\n", service.policy.MaxAgeDays, service.policy.MaxMailCount, service.policy.MaxStorageSize, interval)
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+		defer ticker.Stop()
+
+		for {
+			if err := service.Enforce(); err != nil {
+				log.Printf("MailSlurper: ERROR - Unable to apply the retention policy: %s\n", err.Error())
+			}
+
+			select {
+			case <-ticker.C:
+			case <-service.done:
+				return
+			}
+		}
+	}()
+}
+
+/*
+Close stops applying the policy
+*/
+func (service *RetentionService) Close() {
+	close(service.done)
+}
+
+/*
+Enforce applies the policy once. Mail older than the maximum age goes
+first. The oldest of what remains is then removed until both the mail
+count and the storage used are within their limits. Each step removes
+its mail items in a single bulk delete.
+*/
+func (service *RetentionService) Enforce() error {
+	var err error
+	var mailSizes []*model.MailSize
+
+	if service.policy.MaxAgeDays > 0 {
+		if err = service.removeOldMail(); err != nil {
+			return err
+		}
+	}
+
+	if service.policy.MaxMailCount <= 0 && service.policy.MaxStorageSize <= 0 {
+		return nil
+	}
+
+	if mailSizes, err = service.mailStore.GetMailSizes(); err != nil {
+		return err
+	}
+
+	var totalSize int64
+	for _, mailSize := range mailSizes {
+		totalSize += mailSize.Size
+	}
+
+	mailIDs := make([]string, 0)
+	removedForCount := 0
+	removedForSize := 0
+	remaining := len(mailSizes)
+
+	for _, mailSize := range mailSizes {
+		overCount := service.policy.MaxMailCount > 0 && remaining > service.policy.MaxMailCount
+		overSize := service.policy.MaxStorageSize > 0 && totalSize > service.policy.MaxStorageSize
+
+		if !overCount && !overSize {
+			break
+		}
+
+		mailIDs = append(mailIDs, mailSize.MailItemID)
+
+		if overCount {
+			removedForCount++
+		} else {
+			removedForSize++
+		}
+
+		remaining--
+		totalSize -= mailSize.Size
+	}
+
+	if len(mailIDs) == 0 {
+		return nil
+	}
+
+	if _, err = service.mailStore.DeleteMails(mailIDs); err != nil {
+		return err
+	}
+
+	if removedForCount > 0 {
+		log.Printf("MailSlurper: INFO - Retention removed %d mail item(s) to stay within %d mail item(s)\n", removedForCount, service.policy.MaxMailCount)
+	}
+
+	if removedForSize > 0 {
+		log.Printf("MailSlurper: INFO - Retention removed %d mail item(s) to stay within %d bytes of storage\n", removedForSize, service.policy.MaxStorageSize)
+	}
+
+	return nil
+}
+
+/*
+removeOldMail removes mail older than the maximum age. Age is counted
+in whole days: mail sent before the start of the cutoff date goes, as
+when pruning by date.
+*/
+func (service *RetentionService) removeOldMail() error {
+	cutoff := time.Now().AddDate(0, 0, -service.policy.MaxAgeDays).Format(CUTOFF_DATE_FORMAT)
+
+	mailIDs, err := service.mailStore.GetMailIDs(&search.MailSearch{End: cutoff})
+	if err != nil {
+		return err
+	}
+
+	if len(mailIDs) == 0 {
+		return nil
+	}
+
+	removed, err := service.mailStore.DeleteMails(mailIDs)
+	if err != nil {
+		return err
+	}
+
+	if removed > 0 {
+		log.Printf("MailSlurper: INFO - Retention removed %d mail item(s) older than %d day(s)\n", removed, service.policy.MaxAgeDays)
+	}
+
+	return nil
+}
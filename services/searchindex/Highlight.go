@@ -0,0 +1,116 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package searchindex
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/mailslurper/mailslurper/model"
+)
+
+const (
+	// SNIPPET_WORDS is how many words a snippet shows
+	SNIPPET_WORDS int = 30
+
+	// SNIPPET_LEADING_WORDS is how many words are shown before the first match
+	SNIPPET_LEADING_WORDS int = 8
+
+	SNIPPET_ELLIPSIS string = "&hellip;"
+)
+
+var wordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+/*
+Highlight returns an HTML snippet explaining why a document matched the
+search terms. The snippet is taken from the body around the first
+match, or from the subject when only the subject matched. Matching
+words are wrapped in <mark> and everything else is escaped.
+*/
+func Highlight(document *model.SearchDocument, terms []string) string {
+	if snippet, ok := highlightText(document.Body, terms); ok {
+		return snippet
+	}
+
+	if snippet, ok := highlightText(document.Subject, terms); ok {
+		return snippet
+	}
+
+	snippet, _ := highlightText(document.Body, []string{})
+	return snippet
+}
+
+/*
+highlightText builds a snippet of text around the first word matching
+a term. ok is false when no word matches, in which case the snippet is
+the start of the text.
+*/
+func highlightText(text string, terms []string) (string, bool) {
+	words := wordPattern.FindAllStringIndex(text, -1)
+	if len(words) == 0 {
+		return "", false
+	}
+
+	first := -1
+
+	for index, word := range words {
+		if matchesTerm(text[word[0]:word[1]], terms) {
+			first = index
+			break
+		}
+	}
+
+	startWord := 0
+	if first > SNIPPET_LEADING_WORDS {
+		startWord = first - SNIPPET_LEADING_WORDS
+	}
+
+	endWord := startWord + SNIPPET_WORDS
+	if endWord > len(words) {
+		endWord = len(words)
+	}
+
+	cursor := 0
+	result := &bytes.Buffer{}
+
+	if startWord > 0 {
+		cursor = words[startWord][0]
+		result.WriteString(SNIPPET_ELLIPSIS + " ")
+	}
+
+	for _, word := range words[startWord:endWord] {
+		result.WriteString(html.EscapeString(text[cursor:word[0]]))
+
+		if matchesTerm(text[word[0]:word[1]], terms) {
+			result.WriteString("<mark>" + html.EscapeString(text[word[0]:word[1]]) + "</mark>")
+		} else {
+			result.WriteString(html.EscapeString(text[word[0]:word[1]]))
+		}
+
+		cursor = word[1]
+	}
+
+	if endWord < len(words) {
+		result.WriteString(" " + SNIPPET_ELLIPSIS)
+	} else {
+		result.WriteString(html.EscapeString(text[cursor:]))
+	}
+
+	return result.String(), first >= 0
+}
+
+func matchesTerm(word string, terms []string) bool {
+	word = strings.ToLower(word)
+
+	for _, term := range terms {
+		if strings.HasPrefix(word, term) {
+			return true
+		}
+	}
+
+	return false
+}
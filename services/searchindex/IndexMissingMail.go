@@ -0,0 +1,45 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package searchindex
+
+import (
+	"log"
+
+	"github.com/mailslurper/libmailslurper/storage"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+)
+
+/*
+IndexMissingMail adds every stored mail item that is not in the
+full-text search index yet, such as mail captured before the index
+existed. The missing mail items are found with a single query, so
+nothing but that query runs once everything is indexed. It returns how
+many mail items were indexed.
+*/
+func IndexMissingMail(database storage.IStorage, mailStore mailstore.IMailStore) (int, error) {
+	indexed := 0
+
+	mailIDs, err := mailStore.GetUnindexedMailIDs()
+	if err != nil {
+		return indexed, err
+	}
+
+	for _, mailID := range mailIDs {
+		mailItem, err := database.GetMailByID(mailID)
+		if err != nil {
+			log.Printf("MailSlurper: ERROR - Unable to read mail item %s for the search index: %s\n", mailID, err.Error())
+			continue
+		}
+
+		if err = mailStore.IndexMail(NewSearchDocument(&mailItem)); err != nil {
+			log.Printf("MailSlurper: ERROR - Unable to index mail item %s: %s\n", mailID, err.Error())
+			continue
+		}
+
+		indexed++
+	}
+
+	return indexed, nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package searchindex
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+	"github.com/mailslurper/mailslurper/model"
+)
+
+var hiddenElementPattern = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)\s*>`)
+var tagPattern = regexp.MustCompile(`(?s)<[^>]*>`)
+var htmlPattern = regexp.MustCompile(`(?i)<(html|body|div|p|br|table|span|a|img)\b`)
+var whitespacePattern = regexp.MustCompile(`\s+`)
+
+/*
+NewSearchDocument returns the searchable text of a mail item. HTML
+bodies are reduced to their visible text.
+*/
+func NewSearchDocument(mailItem *mailitem.MailItem) *model.SearchDocument {
+	return &model.SearchDocument{
+		MailItemID:  mailItem.ID,
+		Subject:     mailItem.Subject,
+		Body:        BodyText(mailItem),
+		FromAddress: mailItem.FromAddress,
+		ToAddresses: strings.Join(mailItem.ToAddresses, ", "),
+	}
+}
+
+/*
+BodyText returns the body of a mail item as plain text on a single
+line. Scripts, styles and tags are dropped from HTML and entities are
+decoded.
+*/
+func BodyText(mailItem *mailitem.MailItem) string {
+	body := mailItem.Body
+
+	if strings.Contains(strings.ToLower(mailItem.ContentType), "html") || htmlPattern.MatchString(body) {
+		body = hiddenElementPattern.ReplaceAllString(body, " ")
+		body = tagPattern.ReplaceAllString(body, " ")
+		body = html.UnescapeString(body)
+	}
+
+	return strings.TrimSpace(whitespacePattern.ReplaceAllString(body, " "))
+}
@@ -0,0 +1,90 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package sessiontest
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// CLIENT_TIMEOUT is how long a client waits on the session before giving up
+	CLIENT_TIMEOUT time.Duration = 5 * time.Second
+)
+
+/*
+Client is connected to a session running in its own goroutine
+*/
+type Client struct {
+	connection net.Conn
+	reader     *bufio.Reader
+	done       chan bool
+}
+
+/*
+NewClient starts run with the server end of an in-memory connection and
+returns a client holding the other end
+*/
+func NewClient(run func(connection net.Conn)) *Client {
+	serverConnection, clientConnection := net.Pipe()
+	clientConnection.SetDeadline(time.Now().Add(CLIENT_TIMEOUT))
+
+	result := &Client{
+		connection: clientConnection,
+		reader:     bufio.NewReader(clientConnection),
+		done:       make(chan bool),
+	}
+
+	go func() {
+		run(serverConnection)
+		close(result.done)
+	}()
+
+	return result
+}
+
+/*
+Send writes text to the session in one write. The write does not wait
+for the session to read it, so a session that stops reading does not
+block the test.
+*/
+func (client *Client) Send(text string) {
+	go client.connection.Write([]byte(text))
+}
+
+/*
+ReadLine returns the next line written by the session, without its line
+ending
+*/
+func (client *Client) ReadLine() (string, error) {
+	line, err := client.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+/*
+Close drops the client end of the connection
+*/
+func (client *Client) Close() {
+	client.connection.Close()
+}
+
+/*
+Ended waits for the session to return and reports whether it did so
+before CLIENT_TIMEOUT
+*/
+func (client *Client) Ended() bool {
+	select {
+	case <-client.done:
+		return true
+	case <-time.After(CLIENT_TIMEOUT):
+		return false
+	}
+}
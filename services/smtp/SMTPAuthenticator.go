@@ -0,0 +1,74 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package smtp
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/hex"
+
+	"github.com/mailslurper/mailslurper/model"
+)
+
+/*
+SMTPAuthenticator checks SMTP AUTH credentials. When Enforce is false
+any credentials are accepted, so mailers that always authenticate work
+without changes. When Enforce is true credentials must match one of the
+configured users.
+*/
+type SMTPAuthenticator struct {
+	Enforce bool
+
+	passwords map[string]string
+}
+
+/*
+NewSMTPAuthenticator creates a new SMTPAuthenticator object
+*/
+func NewSMTPAuthenticator(enforce bool, users []*model.SMTPUser) *SMTPAuthenticator {
+	result := &SMTPAuthenticator{
+		Enforce:   enforce,
+		passwords: make(map[string]string),
+	}
+
+	for _, user := range users {
+		result.passwords[user.UserName] = user.Password
+	}
+
+	return result
+}
+
+/*
+CheckPassword validates a user name and clear text password, as sent
+with AUTH PLAIN and AUTH LOGIN.
+*/
+func (authenticator *SMTPAuthenticator) CheckPassword(userName, password string) bool {
+	if !authenticator.Enforce {
+		return true
+	}
+
+	expected, ok := authenticator.passwords[userName]
+	return ok && hmac.Equal([]byte(expected), []byte(password))
+}
+
+/*
+CheckCRAMMD5 validates an AUTH CRAM-MD5 response, which is the hex
+HMAC-MD5 digest of the challenge keyed with the user's password.
+*/
+func (authenticator *SMTPAuthenticator) CheckCRAMMD5(userName, challenge, digest string) bool {
+	if !authenticator.Enforce {
+		return true
+	}
+
+	password, ok := authenticator.passwords[userName]
+	if !ok {
+		return false
+	}
+
+	hash := hmac.New(md5.New, []byte(password))
+	hash.Write([]byte(challenge))
+
+	return hmac.Equal([]byte(hex.EncodeToString(hash.Sum(nil))), []byte(digest))
+}
@@ -0,0 +1,185 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package smtp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/mailslurper/mailslurper/services/dispatch"
+	"github.com/mailslurper/mailslurper/services/faultinjection"
+)
+
+const (
+	TLS_MODE_NONE     string = "none"
+	TLS_MODE_STARTTLS string = "starttls"
+	TLS_MODE_IMPLICIT string = "implicit"
+)
+
+/*
+SMTPServer accepts mail over SMTP, optionally behind STARTTLS or implicit
+TLS, and hands each message, tagged with Tag, to a MailDispatcher.
+Typical usage is to call NewSMTPServer(), then Start, and Close when
+shutting down.
+*/
+type SMTPServer struct {
+	Address string
+	Port    int
+	TLSMode string
+	Tag     string
+
+	extensions    *model.SMTPExtensionConfiguration
+	tlsConfig     *tls.Config
+	authenticator *SMTPAuthenticator
+	faultRules    *faultinjection.FaultRuleEngine
+	dispatcher    *dispatch.MailDispatcher
+	workers       chan bool
+	listener      net.Listener
+}
+
+/*
+NewSMTPServer creates a new SMTPServer object for a listener
+configuration. A nil extension configuration disables every optional
+extension. tlsConfig may be nil when the TLS mode is "none".
+maxWorkers limits the number of connections served at once; further
+connections wait until a worker is free. Zero means no limit.
+*/
+func NewSMTPServer(
+	listenerConfig *model.SMTPListenerConfiguration,
+	extensions *model.SMTPExtensionConfiguration,
+	tlsConfig *tls.Config,
+	maxWorkers int,
+	authenticator *SMTPAuthenticator,
+	faultRules *faultinjection.FaultRuleEngine,
+	dispatcher *dispatch.MailDispatcher,
+) *SMTPServer {
+	result := &SMTPServer{
+		Address: listenerConfig.Address,
+		Port:    listenerConfig.Port,
+		TLSMode: listenerConfig.TLSMode,
+		Tag:     listenerConfig.Tag,
+
+		extensions:    extensions,
+		tlsConfig:     tlsConfig,
+		authenticator: authenticator,
+		faultRules:    faultRules,
+		dispatcher:    dispatcher,
+	}
+
+	if result.extensions == nil {
+		result.extensions = &model.SMTPExtensionConfiguration{}
+	}
+
+	if maxWorkers > 0 {
+		result.workers = make(chan bool, maxWorkers)
+	}
+
+	return result
+}
+
+/*
+LoadTLSConfig reads a certificate and key pair for use by the SMTP
+listeners. A nil configuration is returned when either file name is
+empty.
+*/
+func LoadTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+	}, nil
+}
+
+/*
+Start opens the listener and begins accepting connections in the
+background.
+*/
+func (server *SMTPServer) Start() error {
+	var err error
+
+	switch server.TLSMode {
+	case TLS_MODE_NONE, "":
+		server.tlsConfig = nil
+
+	case TLS_MODE_STARTTLS, TLS_MODE_IMPLICIT:
+		if server.tlsConfig == nil {
+			return fmt.Errorf("TLS mode '%s' requires a certificate and key file", server.TLSMode)
+		}
+
+	default:
+		return fmt.Errorf("Unknown TLS mode '%s'", server.TLSMode)
+	}
+
+	if server.listener, err = net.Listen("tcp", fmt.Sprintf("%s:%d", server.Address, server.Port)); err != nil {
+		return err
+	}
+
+	log.Printf("MailSlurper: INFO - SMTP listener (TLS: %s, tag: '%s') started on %s\n", server.TLSMode, server.Tag, server.listener.Addr().String())
+
+	go server.acceptConnections()
+	return nil
+}
+
+/*
+Addr returns the address the listener is accepting connections on,
+which tells the port chosen when Port is zero. It is nil until Start
+succeeds.
+*/
+func (server *SMTPServer) Addr() net.Addr {
+	if server.listener == nil {
+		return nil
+	}
+
+	return server.listener.Addr()
+}
+
+/*
+Close stops accepting new connections
+*/
+func (server *SMTPServer) Close() error {
+	if server.listener == nil {
+		return nil
+	}
+
+	return server.listener.Close()
+}
+
+func (server *SMTPServer) acceptConnections() {
+	for {
+		connection, err := server.listener.Accept()
+		if err != nil {
+			log.Printf("MailSlurper: INFO - SMTP listener stopped: %s\n", err.Error())
+			return
+		}
+
+		if server.workers != nil {
+			server.workers <- true
+		}
+
+		if server.TLSMode == TLS_MODE_IMPLICIT {
+			connection = tls.Server(connection, server.tlsConfig)
+		}
+
+		session := newSMTPSession(server, connection)
+
+		go func() {
+			session.run()
+
+			if server.workers != nil {
+				<-server.workers
+			}
+		}()
+	}
+}
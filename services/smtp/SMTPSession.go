@@ -0,0 +1,962 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/mailslurper/mailslurper/services/faultinjection"
+	"github.com/mailslurper/mailslurper/services/mailparser"
+)
+
+const (
+	SESSION_TIMEOUT   time.Duration = 5 * time.Minute
+	HANDSHAKE_TIMEOUT time.Duration = 30 * time.Second
+
+	AUTH_MECHANISMS string = "PLAIN LOGIN CRAM-MD5"
+
+	// MAX_TRANSCRIPT_ENTRIES caps the transcript kept for one connection
+	MAX_TRANSCRIPT_ENTRIES int = 200
+
+	// CREDENTIALS_MASK replaces AUTH credentials in the transcript
+	CREDENTIALS_MASK string = "****"
+
+	TRANSCRIPT_TIME_FORMAT string = "2006-01-02 15:04:05.000"
+
+	// DEFAULT_MAX_CHUNKED_SIZE caps a message sent with BDAT when no maximum message size is configured
+	DEFAULT_MAX_CHUNKED_SIZE int = 64 << 20
+
+	// SERVICE_CLOSING_CODE is the reply code that closes the connection at any stage (RFC 5321 section 3.8)
+	SERVICE_CLOSING_CODE int = 421
+)
+
+var mailFromPattern = regexp.MustCompile(`(?i)^FROM:\s*<?([^>\s]*)>?\s*(.*)$`)
+var rcptToPattern = regexp.MustCompile(`(?i)^TO:\s*<?([^>\s]*)>?\s*(.*)$`)
+
+type smtpSession struct {
+	server     *SMTPServer
+	connection net.Conn
+	reader     *bufio.Reader
+	writer     *bufio.Writer
+
+	helo         string
+	from         string
+	hasFrom      bool
+	declaredSize int
+	smtpUTF8     bool
+	recipients   []string
+	chunks       *bytes.Buffer
+	tlsState     *tls.ConnectionState
+	authUser     string
+	closed       bool
+
+	startedAt   time.Time
+	transcript  []*model.SMTPTranscriptEntry
+	current     *model.SMTPTranscriptEntry
+	commandTime time.Time
+}
+
+func newSMTPSession(server *SMTPServer, connection net.Conn) *smtpSession {
+	return &smtpSession{
+		server:       server,
+		connection:   connection,
+		reader:       bufio.NewReader(connection),
+		writer:       bufio.NewWriter(connection),
+		recipients:   make([]string, 0),
+		declaredSize: -1,
+		startedAt:    time.Now(),
+		transcript:   make([]*model.SMTPTranscriptEntry, 0),
+	}
+}
+
+func (session *smtpSession) run() {
+	defer func() {
+		session.writer.Flush()
+		session.connection.Close()
+	}()
+
+	if tlsConnection, ok := session.connection.(*tls.Conn); ok {
+		if err := session.handshake(tlsConnection); err != nil {
+			log.Printf("MailSlurper: ERROR - SMTPS handshake with %s failed: %s\n", session.connection.RemoteAddr(), err.Error())
+			return
+		}
+	}
+
+	session.recordCommand("")
+
+	if rule := session.applyFault(faultinjection.STAGE_CONNECT, nil, -1); rule != nil {
+		if session.closed || rule.ReplyCode >= 400 {
+			return
+		}
+	} else {
+		session.reply(220, fmt.Sprintf("%s ESMTP MailSlurper ready", session.server.Address))
+	}
+
+	for {
+		session.connection.SetReadDeadline(time.Now().Add(SESSION_TIMEOUT))
+
+		line, err := session.reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		command, argument := splitCommand(line)
+		session.recordCommand(maskCredentials(strings.TrimRight(line, "\r\n")))
+
+		if command == "QUIT" {
+			session.reply(221, "Bye")
+			return
+		}
+
+		session.dispatch(command, argument)
+
+		if session.closed {
+			return
+		}
+	}
+}
+
+func (session *smtpSession) dispatch(command, argument string) {
+	switch command {
+	case "HELO":
+		session.hello(argument, false)
+	case "EHLO":
+		session.hello(argument, true)
+	case "STARTTLS":
+		session.startTLS()
+	case "AUTH":
+		session.auth(argument)
+	case "MAIL":
+		session.mail(argument)
+	case "RCPT":
+		session.rcpt(argument)
+	case "DATA":
+		session.data()
+	case "BDAT":
+		session.bdat(argument)
+	case "RSET":
+		session.resetTransaction()
+		session.reply(250, "OK")
+	case "NOOP":
+		session.reply(250, "OK")
+	case "VRFY":
+		session.reply(252, "Cannot verify user, but will accept message")
+	case "HELP":
+		session.reply(214, "See RFC 5321")
+	default:
+		session.reply(500, "Command not recognized")
+	}
+}
+
+func (session *smtpSession) hello(argument string, extended bool) {
+	if argument == "" {
+		session.reply(501, "Domain name required")
+		return
+	}
+
+	if session.applyFault(faultinjection.STAGE_HELO, nil, -1) != nil {
+		return
+	}
+
+	session.resetTransaction()
+	session.helo = argument
+
+	if !extended {
+		session.reply(250, fmt.Sprintf("%s Hello %s", session.server.Address, argument))
+		return
+	}
+
+	lines := []string{fmt.Sprintf("%s Hello %s", session.server.Address, argument)}
+
+	if session.canStartTLS() {
+		lines = append(lines, "STARTTLS")
+	}
+
+	extensions := session.server.extensions
+
+	if extensions.Size {
+		lines = append(lines, fmt.Sprintf("SIZE %d", extensions.MaxMessageSize))
+	}
+
+	if extensions.EightBitMIME {
+		lines = append(lines, "8BITMIME")
+	}
+
+	if extensions.Pipelining {
+		lines = append(lines, "PIPELINING")
+	}
+
+	if extensions.SMTPUTF8 {
+		lines = append(lines, "SMTPUTF8")
+	}
+
+	if extensions.Chunking {
+		lines = append(lines, "CHUNKING")
+	}
+
+	lines = append(lines, "AUTH "+AUTH_MECHANISMS, "HELP")
+	session.replyLines(250, lines)
+}
+
+func (session *smtpSession) canStartTLS() bool {
+	return session.server.tlsConfig != nil && session.tlsState == nil
+}
+
+/*
+startTLS upgrades the connection to TLS. Per RFC 3207 the client must
+start over with EHLO afterwards, so all session state is discarded.
+*/
+func (session *smtpSession) startTLS() {
+	if !session.canStartTLS() {
+		session.reply(502, "STARTTLS not available")
+		return
+	}
+
+	session.reply(220, "Ready to start TLS")
+	session.writer.Flush()
+
+	tlsConnection := tls.Server(session.connection, session.server.tlsConfig)
+
+	if err := session.handshake(tlsConnection); err != nil {
+		log.Printf("MailSlurper: ERROR - STARTTLS handshake with %s failed: %s\n", session.connection.RemoteAddr(), err.Error())
+		session.connection.Close()
+		return
+	}
+
+	session.connection = tlsConnection
+	session.reader = bufio.NewReader(tlsConnection)
+	session.writer = bufio.NewWriter(tlsConnection)
+	session.helo = ""
+	session.authUser = ""
+	session.resetTransaction()
+}
+
+func (session *smtpSession) handshake(tlsConnection *tls.Conn) error {
+	tlsConnection.SetDeadline(time.Now().Add(HANDSHAKE_TIMEOUT))
+
+	if err := tlsConnection.Handshake(); err != nil {
+		return err
+	}
+
+	tlsConnection.SetDeadline(time.Time{})
+
+	state := tlsConnection.ConnectionState()
+	session.tlsState = &state
+	return nil
+}
+
+/*
+auth runs an AUTH exchange for the PLAIN, LOGIN or CRAM-MD5 mechanism
+*/
+func (session *smtpSession) auth(argument string) {
+	if session.helo == "" {
+		session.reply(503, "Send EHLO first")
+		return
+	}
+
+	if session.authUser != "" {
+		session.reply(503, "Already authenticated")
+		return
+	}
+
+	if session.hasFrom {
+		session.reply(503, "AUTH not permitted during a mail transaction")
+		return
+	}
+
+	parts := strings.Fields(argument)
+	if len(parts) == 0 {
+		session.reply(501, "Syntax: AUTH mechanism")
+		return
+	}
+
+	var userName string
+	var ok bool
+	var err error
+
+	switch strings.ToUpper(parts[0]) {
+	case "PLAIN":
+		userName, ok, err = session.authPlain(parts[1:])
+	case "LOGIN":
+		userName, ok, err = session.authLogin(parts[1:])
+	case "CRAM-MD5":
+		userName, ok, err = session.authCRAMMD5()
+	default:
+		session.reply(504, "Unrecognized authentication mechanism")
+		return
+	}
+
+	if err != nil {
+		session.reply(501, err.Error())
+		return
+	}
+
+	if !ok {
+		log.Printf("MailSlurper: INFO - SMTP authentication failed for user '%s'\n", userName)
+		session.reply(535, "5.7.8 Authentication credentials invalid")
+		return
+	}
+
+	session.authUser = userName
+	session.reply(235, "2.7.0 Authentication successful")
+}
+
+func (session *smtpSession) authPlain(initialResponse []string) (string, bool, error) {
+	response, err := session.authResponse(initialResponse, "")
+	if err != nil {
+		return "", false, err
+	}
+
+	parts := bytes.Split(response, []byte{0})
+	if len(parts) != 3 {
+		return "", false, fmt.Errorf("Invalid PLAIN response")
+	}
+
+	userName := string(parts[1])
+	return userName, session.server.authenticator.CheckPassword(userName, string(parts[2])), nil
+}
+
+func (session *smtpSession) authLogin(initialResponse []string) (string, bool, error) {
+	userName, err := session.authResponse(initialResponse, "Username:")
+	if err != nil {
+		return "", false, err
+	}
+
+	password, err := session.authResponse(nil, "Password:")
+	if err != nil {
+		return "", false, err
+	}
+
+	return string(userName), session.server.authenticator.CheckPassword(string(userName), string(password)), nil
+}
+
+func (session *smtpSession) authCRAMMD5() (string, bool, error) {
+	challenge := session.challenge()
+
+	response, err := session.authResponse(nil, challenge)
+	if err != nil {
+		return "", false, err
+	}
+
+	parts := strings.Fields(string(response))
+	if len(parts) != 2 {
+		return "", false, fmt.Errorf("Invalid CRAM-MD5 response")
+	}
+
+	return parts[0], session.server.authenticator.CheckCRAMMD5(parts[0], challenge, parts[1]), nil
+}
+
+/*
+authResponse returns the decoded initial response when the client sent
+one, otherwise it sends a 334 prompt and reads the client's reply.
+*/
+func (session *smtpSession) authResponse(initialResponse []string, prompt string) ([]byte, error) {
+	var encoded string
+
+	if len(initialResponse) > 0 {
+		encoded = initialResponse[0]
+	} else {
+		session.reply(334, base64.StdEncoding.EncodeToString([]byte(prompt)))
+
+		line, err := session.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		encoded = strings.TrimSpace(line)
+		session.recordClientLine(CREDENTIALS_MASK)
+	}
+
+	if encoded == "*" {
+		return nil, fmt.Errorf("Authentication cancelled")
+	}
+
+	if encoded == "=" {
+		return []byte{}, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid base64 response")
+	}
+
+	return decoded, nil
+}
+
+func (session *smtpSession) challenge() string {
+	random := make([]byte, 8)
+	rand.Read(random)
+
+	return fmt.Sprintf("<%d.%d@%s>", binary.BigEndian.Uint64(random)>>1, time.Now().Unix(), session.server.Address)
+}
+
+func (session *smtpSession) mail(argument string) {
+	if session.helo == "" {
+		session.reply(503, "Send HELO or EHLO first")
+		return
+	}
+
+	if session.hasFrom {
+		session.reply(503, "Sender already specified")
+		return
+	}
+
+	if session.server.authenticator.Enforce && session.authUser == "" {
+		session.reply(530, "5.7.0 Authentication required")
+		return
+	}
+
+	match := mailFromPattern.FindStringSubmatch(argument)
+	if match == nil {
+		session.reply(501, "Syntax: MAIL FROM:<address>")
+		return
+	}
+
+	extensions := session.server.extensions
+	declaredSize := -1
+	smtpUTF8 := false
+
+	for _, parameter := range strings.Fields(match[2]) {
+		name, value := splitParameter(parameter)
+
+		switch {
+		case name == "SIZE" && extensions.Size:
+			size, err := strconv.Atoi(value)
+			if err != nil || size < 0 {
+				session.reply(501, "5.5.4 Invalid SIZE parameter")
+				return
+			}
+
+			declaredSize = size
+
+		case name == "BODY" && extensions.EightBitMIME:
+			if !strings.EqualFold(value, "7BIT") && !strings.EqualFold(value, "8BITMIME") {
+				session.reply(501, "5.5.4 Invalid BODY parameter")
+				return
+			}
+
+		case name == "SMTPUTF8" && extensions.SMTPUTF8:
+			smtpUTF8 = true
+
+		case name == "AUTH":
+
+		default:
+			session.reply(555, "5.5.4 MAIL FROM parameter not recognized: "+name)
+			return
+		}
+	}
+
+	if session.exceedsMaxSize(declaredSize) {
+		session.reply(552, "5.3.4 Message size exceeds fixed maximum message size")
+		return
+	}
+
+	if !smtpUTF8 && !isASCII(match[1]) {
+		session.reply(553, "5.6.7 Non-ASCII addresses require SMTPUTF8")
+		return
+	}
+
+	session.from = match[1]
+	session.hasFrom = true
+
+	if session.applyFault(faultinjection.STAGE_MAIL, nil, declaredSize) != nil {
+		session.resetTransaction()
+		return
+	}
+
+	session.declaredSize = declaredSize
+	session.smtpUTF8 = smtpUTF8
+	session.reply(250, "OK")
+}
+
+func (session *smtpSession) rcpt(argument string) {
+	if !session.hasFrom {
+		session.reply(503, "Send MAIL first")
+		return
+	}
+
+	match := rcptToPattern.FindStringSubmatch(argument)
+	if match == nil || match[1] == "" {
+		session.reply(501, "Syntax: RCPT TO:<address>")
+		return
+	}
+
+	if match[2] != "" {
+		name, _ := splitParameter(strings.Fields(match[2])[0])
+		session.reply(555, "5.5.4 RCPT TO parameter not recognized: "+name)
+		return
+	}
+
+	if !session.smtpUTF8 && !isASCII(match[1]) {
+		session.reply(553, "5.6.7 Non-ASCII addresses require SMTPUTF8")
+		return
+	}
+
+	if session.applyFault(faultinjection.STAGE_RCPT, []string{match[1]}, session.declaredSize) != nil {
+		return
+	}
+
+	session.recipients = append(session.recipients, match[1])
+	session.reply(250, "OK")
+}
+
+func (session *smtpSession) data() {
+	if len(session.recipients) == 0 {
+		session.reply(503, "Send RCPT first")
+		return
+	}
+
+	if session.chunks != nil {
+		session.reply(503, "5.5.1 DATA not permitted during BDAT transfer")
+		return
+	}
+
+	if session.applyFault(faultinjection.STAGE_DATA, session.recipients, session.declaredSize) != nil {
+		return
+	}
+
+	session.reply(354, "End data with <CR><LF>.<CR><LF>")
+
+	contents, tooLarge, err := session.readData()
+	if err != nil {
+		return
+	}
+
+	session.recordClientLine(fmt.Sprintf("[%d octets of message data]", len(contents)))
+
+	if tooLarge {
+		session.resetTransaction()
+		session.reply(552, "5.3.4 Message size exceeds fixed maximum message size")
+		return
+	}
+
+	session.deliver(contents)
+}
+
+/*
+bdat receives one chunk of a message sent with BDAT (RFC 3030). The
+chunk is always read off the connection, even when it is rejected, so
+the session stays in step with the client. Once the chunk marked LAST
+arrives the whole message is delivered.
+*/
+func (session *smtpSession) bdat(argument string) {
+	if !session.server.extensions.Chunking {
+		session.reply(502, "5.5.1 BDAT not available")
+		return
+	}
+
+	parts := strings.Fields(argument)
+	size := -1
+
+	if len(parts) == 1 || len(parts) == 2 {
+		if parsed, err := strconv.Atoi(parts[0]); err == nil {
+			size = parsed
+		}
+	}
+
+	last := len(parts) == 2 && strings.EqualFold(parts[1], "LAST")
+
+	if size < 0 || (len(parts) == 2 && !last) {
+		session.reply(501, "5.5.4 Syntax: BDAT size [LAST]")
+		session.closed = true
+		return
+	}
+
+	accepted := len(session.recipients) > 0
+	firstChunk := session.chunks == nil
+
+	if accepted && firstChunk {
+		session.chunks = &bytes.Buffer{}
+	}
+
+	var destination io.Writer = ioutil.Discard
+	if accepted && !session.exceedsChunkedMaxSize(size) {
+		destination = session.chunks
+	}
+
+	session.connection.SetReadDeadline(time.Now().Add(SESSION_TIMEOUT))
+
+	if _, err := io.CopyN(destination, session.reader, int64(size)); err != nil {
+		session.closed = true
+		return
+	}
+
+	if !accepted {
+		session.reply(503, "Send RCPT first")
+		return
+	}
+
+	if destination == ioutil.Discard {
+		session.resetTransaction()
+		session.reply(552, "5.3.4 Message size exceeds fixed maximum message size")
+		return
+	}
+
+	if firstChunk && session.applyFault(faultinjection.STAGE_DATA, session.recipients, session.declaredSize) != nil {
+		session.resetTransaction()
+		return
+	}
+
+	if !last {
+		session.reply(250, fmt.Sprintf("2.0.0 %d octets received", size))
+		return
+	}
+
+	session.deliver(session.chunks.Bytes())
+}
+
+/*
+deliver parses a complete message received with DATA or BDAT and hands
+it to the dispatcher. The mail transaction ends either way.
+*/
+func (session *smtpSession) deliver(contents []byte) {
+	defer session.resetTransaction()
+
+	if session.applyFault(faultinjection.STAGE_MESSAGE, session.recipients, len(contents)) != nil {
+		return
+	}
+
+	mailItem, err := mailparser.Parse(contents, session.from, session.recipients)
+	if err != nil {
+		log.Printf("MailSlurper: ERROR - Unable to parse message from %s: %s\n", session.from, err.Error())
+		session.reply(554, "Unable to parse message")
+		return
+	}
+
+	if err = session.server.dispatcher.Dispatch(mailItem, contents, session.metadata()); err != nil {
+		log.Printf("MailSlurper: ERROR - Unable to store message from %s: %s\n", session.from, err.Error())
+		session.reply(451, "Unable to store message")
+		return
+	}
+
+	session.reply(250, "OK queued as "+mailItem.ID)
+	session.server.dispatcher.RecordSession(session.sessionRecord(mailItem.ID))
+}
+
+/*
+readData reads message content up to the terminating "." line,
+removing dot-stuffing while keeping the original line endings. Content
+past the maximum message size is read but not kept, and tooLarge is
+set.
+*/
+func (session *smtpSession) readData() ([]byte, bool, error) {
+	var contents bytes.Buffer
+	tooLarge := false
+
+	for {
+		session.connection.SetReadDeadline(time.Now().Add(SESSION_TIMEOUT))
+
+		line, err := session.reader.ReadString('\n')
+		if err != nil {
+			return nil, false, err
+		}
+
+		if line == ".\r\n" || line == ".\n" {
+			return contents.Bytes(), tooLarge, nil
+		}
+
+		if tooLarge {
+			continue
+		}
+
+		if strings.HasPrefix(line, ".") {
+			line = line[1:]
+		}
+
+		contents.WriteString(line)
+
+		if session.exceedsMaxSize(contents.Len()) {
+			contents.Reset()
+			tooLarge = true
+		}
+	}
+}
+
+func (session *smtpSession) exceedsMaxSize(size int) bool {
+	maxMessageSize := session.server.extensions.MaxMessageSize
+	return maxMessageSize > 0 && size > maxMessageSize
+}
+
+/*
+exceedsChunkedMaxSize returns true when adding a BDAT chunk would make
+the message too large. BDAT chunks are kept in memory until the last
+one arrives, so DEFAULT_MAX_CHUNKED_SIZE applies when no maximum
+message size is configured.
+*/
+func (session *smtpSession) exceedsChunkedMaxSize(chunkSize int) bool {
+	maxMessageSize := session.server.extensions.MaxMessageSize
+	if maxMessageSize <= 0 {
+		maxMessageSize = DEFAULT_MAX_CHUNKED_SIZE
+	}
+
+	return chunkSize > maxMessageSize || session.chunks.Len()+chunkSize > maxMessageSize
+}
+
+/*
+applyFault evaluates the fault rules for a stage and carries out the
+matching rule's action. It returns the rule when the normal handling of
+the stage must be skipped; a delay rule only slows the session down, so
+nil is returned for it. A 421 reply closes the connection, as it would
+from a real server shutting down.
+*/
+func (session *smtpSession) applyFault(stage string, recipients []string, size int) *model.FaultRule {
+	if session.server.faultRules == nil {
+		return nil
+	}
+
+	context := &faultinjection.FaultContext{
+		ClientIP:    session.clientIP(),
+		MailFrom:    session.from,
+		HasMailFrom: session.hasFrom,
+		Recipients:  recipients,
+		Size:        size,
+	}
+
+	rule := session.server.faultRules.Evaluate(stage, context)
+	if rule == nil {
+		return nil
+	}
+
+	switch rule.Action {
+	case faultinjection.ACTION_DELAY:
+		time.Sleep(time.Duration(rule.DelaySeconds) * time.Second)
+		return nil
+
+	case faultinjection.ACTION_DISCONNECT:
+		session.connection.Close()
+		session.closed = true
+
+	default:
+		session.reply(rule.ReplyCode, rule.ReplyText)
+
+		if rule.ReplyCode == SERVICE_CLOSING_CODE {
+			session.connection.Close()
+			session.closed = true
+		}
+	}
+
+	return rule
+}
+
+func (session *smtpSession) clientIP() string {
+	host, _ := session.clientAddress()
+	return host
+}
+
+func (session *smtpSession) clientAddress() (string, int) {
+	host, port, err := net.SplitHostPort(session.connection.RemoteAddr().String())
+	if err != nil {
+		return session.connection.RemoteAddr().String(), 0
+	}
+
+	portNumber, _ := strconv.Atoi(port)
+	return host, portNumber
+}
+
+func (session *smtpSession) metadata() *model.MailMetadata {
+	result := &model.MailMetadata{
+		AuthUser: session.authUser,
+		Tag:      session.server.Tag,
+	}
+
+	if session.tlsState != nil {
+		result.TLSVersion = tlsVersionName(session.tlsState.Version)
+		result.TLSCipher = tls.CipherSuiteName(session.tlsState.CipherSuite)
+	}
+
+	return result
+}
+
+/*
+sessionRecord describes the session so far for the mail item just
+accepted
+*/
+func (session *smtpSession) sessionRecord(mailID string) *model.SMTPSessionRecord {
+	result := &model.SMTPSessionRecord{
+		MailItemID: mailID,
+		Helo:       session.helo,
+		MailFrom:   session.from,
+		RcptTo:     session.recipients,
+		AuthUser:   session.authUser,
+		StartedAt:  session.startedAt.Format(mailparser.DATE_FORMAT),
+		Transcript: session.transcript,
+	}
+
+	result.ClientIP, result.ClientPort = session.clientAddress()
+
+	if session.tlsState != nil {
+		result.TLSVersion = tlsVersionName(session.tlsState.Version)
+		result.TLSCipher = tls.CipherSuiteName(session.tlsState.CipherSuite)
+	}
+
+	return result
+}
+
+/*
+recordCommand starts a transcript entry for a line sent by the client.
+The greeting is recorded as an entry with an empty command. Once the
+transcript is full nothing more is recorded.
+*/
+func (session *smtpSession) recordCommand(line string) {
+	session.current = nil
+
+	if len(session.transcript) >= MAX_TRANSCRIPT_ENTRIES {
+		return
+	}
+
+	session.commandTime = time.Now()
+	session.current = &model.SMTPTranscriptEntry{
+		ReceivedAt: session.commandTime.Format(TRANSCRIPT_TIME_FORMAT),
+		Command:    line,
+	}
+
+	session.transcript = append(session.transcript, session.current)
+}
+
+/*
+recordClientLine adds a line the client sent as part of the current
+command, such as an AUTH response, to the transcript
+*/
+func (session *smtpSession) recordClientLine(line string) {
+	if session.current != nil {
+		session.current.Command += "\n" + line
+	}
+}
+
+func (session *smtpSession) recordResponse(line string) {
+	if session.current == nil {
+		return
+	}
+
+	if session.current.Response != "" {
+		session.current.Response += "\n"
+	}
+
+	session.current.Response += line
+	session.current.Duration = float64(time.Since(session.commandTime)) / float64(time.Millisecond)
+}
+
+func (session *smtpSession) resetTransaction() {
+	session.from = ""
+	session.hasFrom = false
+	session.declaredSize = -1
+	session.smtpUTF8 = false
+	session.recipients = make([]string, 0)
+	session.chunks = nil
+}
+
+func (session *smtpSession) reply(code int, message string) {
+	session.recordResponse(fmt.Sprintf("%d %s", code, message))
+	session.writer.WriteString(fmt.Sprintf("%d %s\r\n", code, message))
+	session.flush()
+}
+
+func (session *smtpSession) replyLines(code int, lines []string) {
+	for index, line := range lines {
+		separator := "-"
+		if index == len(lines)-1 {
+			separator = " "
+		}
+
+		session.recordResponse(fmt.Sprintf("%d%s%s", code, separator, line))
+		session.writer.WriteString(fmt.Sprintf("%d%s%s\r\n", code, separator, line))
+	}
+
+	session.flush()
+}
+
+/*
+flush sends the buffered replies. With PIPELINING, replies are held
+back while the client still has commands waiting so a whole group of
+commands is answered at once (RFC 2920).
+*/
+func (session *smtpSession) flush() {
+	if session.server.extensions.Pipelining && session.reader.Buffered() > 0 {
+		return
+	}
+
+	session.writer.Flush()
+}
+
+/*
+splitParameter splits an ESMTP parameter such as SIZE=1024 into its
+upper-cased name and its value
+*/
+func splitParameter(parameter string) (string, string) {
+	parts := strings.SplitN(parameter, "=", 2)
+
+	if len(parts) == 2 {
+		return strings.ToUpper(parts[0]), parts[1]
+	}
+
+	return strings.ToUpper(parts[0]), ""
+}
+
+/*
+maskCredentials hides the initial response of an AUTH command
+*/
+func maskCredentials(line string) string {
+	parts := strings.Fields(line)
+
+	if len(parts) > 2 && strings.EqualFold(parts[0], "AUTH") {
+		return parts[0] + " " + parts[1] + " " + CREDENTIALS_MASK
+	}
+
+	return line
+}
+
+func isASCII(value string) bool {
+	for index := 0; index < len(value); index++ {
+		if value[index] >= 0x80 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func splitCommand(line string) (string, string) {
+	line = strings.TrimRight(line, "\r\n")
+	parts := strings.SplitN(line, " ", 2)
+
+	command := strings.ToUpper(parts[0])
+	argument := ""
+
+	if len(parts) > 1 {
+		argument = strings.TrimSpace(parts[1])
+	}
+
+	return command, argument
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+
+	return fmt.Sprintf("0x%04X", version)
+}
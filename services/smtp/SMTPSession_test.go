@@ -0,0 +1,304 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package smtp
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mailslurper/libmailslurper/receiver"
+	"github.com/mailslurper/mailslurper/model"
+	"github.com/mailslurper/mailslurper/services/dispatch"
+	"github.com/mailslurper/mailslurper/services/mailstore"
+	"github.com/mailslurper/mailslurper/services/sessiontest"
+)
+
+/*
+sessionStep is sent to the server in one write, after which the final
+lines of the expected replies are read and their codes compared
+*/
+type sessionStep struct {
+	send    string
+	replies []string
+}
+
+/*
+runSession plays the steps against a session and returns the store the
+mail was delivered to. The session must end once the steps are done.
+*/
+func runSession(t *testing.T, name string, extensions *model.SMTPExtensionConfiguration, steps []sessionStep) *mailstore.MemoryStore {
+	store := mailstore.NewMemoryStore(0)
+	dispatcher := dispatch.NewMailDispatcher(store, store, []receiver.IMailItemReceiver{})
+
+	server := NewSMTPServer(
+		&model.SMTPListenerConfiguration{Address: "127.0.0.1"},
+		extensions,
+		nil,
+		0,
+		NewSMTPAuthenticator(false, nil),
+		nil,
+		dispatcher,
+	)
+
+	client := sessiontest.NewClient(func(connection net.Conn) {
+		newSMTPSession(server, connection).run()
+	})
+	defer client.Close()
+
+	readReply := func() string {
+		for {
+			line, err := client.ReadLine()
+			if err != nil {
+				return "EOF"
+			}
+
+			if len(line) < 4 || line[3] != '-' {
+				return line[:3]
+			}
+		}
+	}
+
+	if greeting := readReply(); greeting != "220" {
+		t.Fatalf("%s: expected a 220 greeting, got %s", name, greeting)
+	}
+
+	for _, step := range steps {
+		client.Send(step.send)
+
+		actual := make([]string, 0, len(step.replies))
+
+		for range step.replies {
+			actual = append(actual, readReply())
+		}
+
+		if strings.Join(actual, " ") != strings.Join(step.replies, " ") {
+			t.Errorf("%s: after sending %q expected %v, got %v", name, step.send, step.replies, actual)
+			return store
+		}
+	}
+
+	if !client.Ended() {
+		t.Errorf("%s: expected the session to end", name)
+	}
+
+	return store
+}
+
+func TestSessionStateMachine(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions *model.SMTPExtensionConfiguration
+		steps      []sessionStep
+		delivered  int
+	}{
+		{
+			name: "commands out of order",
+			steps: []sessionStep{
+				{send: "MAIL FROM:<a@example.com>\r\n", replies: []string{"503"}},
+				{send: "HELO client\r\n", replies: []string{"250"}},
+				{send: "RCPT TO:<b@example.com>\r\n", replies: []string{"503"}},
+				{send: "DATA\r\n", replies: []string{"503"}},
+				{send: "MAIL FROM:<a@example.com>\r\n", replies: []string{"250"}},
+				{send: "MAIL FROM:<a@example.com>\r\n", replies: []string{"503"}},
+				{send: "DATA\r\n", replies: []string{"503"}},
+				{send: "RSET\r\n", replies: []string{"250"}},
+				{send: "RCPT TO:<b@example.com>\r\n", replies: []string{"503"}},
+				{send: "QUIT\r\n", replies: []string{"221"}},
+			},
+		},
+		{
+			name: "DATA delivery",
+			steps: []sessionStep{
+				{send: "EHLO client\r\n", replies: []string{"250"}},
+				{send: "MAIL FROM:<a@example.com>\r\n", replies: []string{"250"}},
+				{send: "RCPT TO:<b@example.com>\r\n", replies: []string{"250"}},
+				{send: "DATA\r\n", replies: []string{"354"}},
+				{send: "Subject: Hello\r\n\r\n..dot stuffed\r\n.\r\n", replies: []string{"250"}},
+				{send: "DATA\r\n", replies: []string{"503"}},
+				{send: "QUIT\r\n", replies: []string{"221"}},
+			},
+			delivered: 1,
+		},
+		{
+			name: "syntax errors",
+			steps: []sessionStep{
+				{send: "HELO\r\n", replies: []string{"501"}},
+				{send: "HELO client\r\n", replies: []string{"250"}},
+				{send: "MAIL a@example.com\r\n", replies: []string{"501"}},
+				{send: "MAIL FROM:<a@example.com> SIZE=10\r\n", replies: []string{"555"}},
+				{send: "MAIL FROM:<a@example.com>\r\n", replies: []string{"250"}},
+				{send: "RCPT TO:<>\r\n", replies: []string{"501"}},
+				{send: "RCPT TO:<b\xc3\xa9@example.com>\r\n", replies: []string{"553"}},
+				{send: "BOGUS\r\n", replies: []string{"500"}},
+				{send: "QUIT\r\n", replies: []string{"221"}},
+			},
+		},
+		{
+			name:       "SIZE limit",
+			extensions: &model.SMTPExtensionConfiguration{Size: true, MaxMessageSize: 20},
+			steps: []sessionStep{
+				{send: "EHLO client\r\n", replies: []string{"250"}},
+				{send: "MAIL FROM:<a@example.com> SIZE=21\r\n", replies: []string{"552"}},
+				{send: "MAIL FROM:<a@example.com> SIZE=x\r\n", replies: []string{"501"}},
+				{send: "MAIL FROM:<a@example.com> SIZE=10\r\n", replies: []string{"250"}},
+				{send: "RCPT TO:<b@example.com>\r\n", replies: []string{"250"}},
+				{send: "DATA\r\n", replies: []string{"354"}},
+				{send: "Subject: Hello\r\n\r\nThis is far too long\r\n.\r\n", replies: []string{"552"}},
+				{send: "QUIT\r\n", replies: []string{"221"}},
+			},
+		},
+		{
+			name: "pipelined commands without PIPELINING are answered in order",
+			steps: []sessionStep{
+				{send: "EHLO client\r\n", replies: []string{"250"}},
+				{send: "MAIL FROM:<a@example.com>\r\nRCPT TO:<b@example.com>\r\nRSET\r\nQUIT\r\n", replies: []string{"250", "250", "250", "221"}},
+			},
+		},
+		{
+			name:       "PIPELINING",
+			extensions: &model.SMTPExtensionConfiguration{Pipelining: true},
+			steps: []sessionStep{
+				{send: "EHLO client\r\n", replies: []string{"250"}},
+				{send: "MAIL FROM:<a@example.com>\r\nRCPT TO:<b@example.com>\r\nRCPT TO:<c@example.com>\r\nDATA\r\n", replies: []string{"250", "250", "250", "354"}},
+				{send: "Subject: Hello\r\n\r\nHello\r\n.\r\nMAIL FROM:<a@example.com>\r\nRCPT TO:<>\r\nRSET\r\nQUIT\r\n", replies: []string{"250", "250", "501", "250", "221"}},
+			},
+			delivered: 1,
+		},
+		{
+			name:       "BDAT",
+			extensions: &model.SMTPExtensionConfiguration{Chunking: true},
+			steps: []sessionStep{
+				{send: "EHLO client\r\n", replies: []string{"250"}},
+				{send: "MAIL FROM:<a@example.com>\r\n", replies: []string{"250"}},
+				{send: "RCPT TO:<b@example.com>\r\n", replies: []string{"250"}},
+				{send: "BDAT 21\r\nSubject: Hello\r\n\r\nHel", replies: []string{"250"}},
+				{send: "DATA\r\n", replies: []string{"503"}},
+				{send: "BDAT 4 LAST\r\nlo\r\n", replies: []string{"250"}},
+				{send: "BDAT 0 LAST\r\n", replies: []string{"503"}},
+				{send: "QUIT\r\n", replies: []string{"221"}},
+			},
+			delivered: 1,
+		},
+		{
+			name:       "BDAT before RCPT keeps the session in step",
+			extensions: &model.SMTPExtensionConfiguration{Chunking: true},
+			steps: []sessionStep{
+				{send: "EHLO client\r\n", replies: []string{"250"}},
+				{send: "BDAT 8\r\nNOOP\r\n\r\n", replies: []string{"503"}},
+				{send: "NOOP\r\n", replies: []string{"250"}},
+				{send: "QUIT\r\n", replies: []string{"221"}},
+			},
+		},
+		{
+			name:       "BDAT with a bad size ends the session",
+			extensions: &model.SMTPExtensionConfiguration{Chunking: true},
+			steps: []sessionStep{
+				{send: "EHLO client\r\n", replies: []string{"250"}},
+				{send: "BDAT x\r\n", replies: []string{"501", "EOF"}},
+			},
+		},
+		{
+			name: "BDAT without CHUNKING",
+			steps: []sessionStep{
+				{send: "EHLO client\r\n", replies: []string{"250"}},
+				{send: "BDAT 5 LAST\r\n", replies: []string{"502"}},
+				{send: "QUIT\r\n", replies: []string{"221"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		store := runSession(t, test.name, test.extensions, test.steps)
+
+		if delivered, _ := store.GetMailCount(nil); delivered != test.delivered {
+			t.Errorf("%s: expected %d mail item(s) delivered, got %d", test.name, test.delivered, delivered)
+		}
+	}
+}
+
+func TestSessionDeliveredContents(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions *model.SMTPExtensionConfiguration
+		steps      []sessionStep
+		raw        string
+		recipients []string
+	}{
+		{
+			name: "DATA removes dot-stuffing",
+			steps: []sessionStep{
+				{send: "HELO client\r\n", replies: []string{"250"}},
+				{send: "MAIL FROM:<a@example.com>\r\n", replies: []string{"250"}},
+				{send: "RCPT TO:<b@example.com>\r\n", replies: []string{"250"}},
+				{send: "DATA\r\n", replies: []string{"354"}},
+				{send: "Subject: Dots\r\n\r\n..one\r\n...two\r\n.\r\n", replies: []string{"250"}},
+				{send: "QUIT\r\n", replies: []string{"221"}},
+			},
+			raw:        "Subject: Dots\r\n\r\n.one\r\n..two\r\n",
+			recipients: []string{"b@example.com"},
+		},
+		{
+			name:       "BDAT chunks are joined as sent",
+			extensions: &model.SMTPExtensionConfiguration{Chunking: true, Pipelining: true},
+			steps: []sessionStep{
+				{send: "EHLO client\r\n", replies: []string{"250"}},
+				{send: "MAIL FROM:<a@example.com>\r\nRCPT TO:<b@example.com>\r\nRCPT TO:<c@example.com>\r\n", replies: []string{"250", "250", "250"}},
+				{send: "BDAT 17\r\nSubject: Chunks\r\nBDAT 8 LAST\r\n\r\n.dot\r\n", replies: []string{"250", "250"}},
+				{send: "QUIT\r\n", replies: []string{"221"}},
+			},
+			raw:        "Subject: Chunks\r\n\r\n.dot\r\n",
+			recipients: []string{"b@example.com", "c@example.com"},
+		},
+	}
+
+	for _, test := range tests {
+		store := runSession(t, test.name, test.extensions, test.steps)
+
+		mailIDs, _ := store.GetMailIDs(nil)
+		if len(mailIDs) != 1 {
+			t.Errorf("%s: expected one mail item, got %d", test.name, len(mailIDs))
+			continue
+		}
+
+		raw, _ := store.GetRawMessage(mailIDs[0])
+		if string(raw) != test.raw {
+			t.Errorf("%s: expected message %q, got %q", test.name, test.raw, raw)
+		}
+
+		mailItem, _ := store.GetMailByID(mailIDs[0])
+		if strings.Join(mailItem.ToAddresses, ",") != strings.Join(test.recipients, ",") {
+			t.Errorf("%s: expected recipients %v, got %v", test.name, test.recipients, mailItem.ToAddresses)
+		}
+	}
+}
+
+func TestExceedsChunkedMaxSize(t *testing.T) {
+	tests := []struct {
+		maxMessageSize int
+		buffered       int
+		chunkSize      int
+		expected       bool
+	}{
+		{maxMessageSize: 0, buffered: 0, chunkSize: DEFAULT_MAX_CHUNKED_SIZE, expected: false},
+		{maxMessageSize: 0, buffered: 1, chunkSize: DEFAULT_MAX_CHUNKED_SIZE, expected: true},
+		{maxMessageSize: 0, buffered: 0, chunkSize: int(^uint(0) >> 1), expected: true},
+		{maxMessageSize: 100, buffered: 60, chunkSize: 40, expected: false},
+		{maxMessageSize: 100, buffered: 60, chunkSize: 41, expected: true},
+		{maxMessageSize: DEFAULT_MAX_CHUNKED_SIZE * 2, buffered: 0, chunkSize: DEFAULT_MAX_CHUNKED_SIZE + 1, expected: false},
+	}
+
+	for _, test := range tests {
+		session := &smtpSession{
+			server: &SMTPServer{extensions: &model.SMTPExtensionConfiguration{MaxMessageSize: test.maxMessageSize}},
+			chunks: bytes.NewBuffer(make([]byte, test.buffered)),
+		}
+
+		if actual := session.exceedsChunkedMaxSize(test.chunkSize); actual != test.expected {
+			t.Errorf("max %d, buffered %d, chunk %d: expected %t, got %t", test.maxMessageSize, test.buffered, test.chunkSize, test.expected, actual)
+		}
+	}
+}
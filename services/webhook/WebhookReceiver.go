@@ -0,0 +1,191 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mailslurper/libmailslurper/model/mailitem"
+	"github.com/mailslurper/mailslurper/global"
+	"github.com/mailslurper/mailslurper/model"
+)
+
+const (
+	// SIGNATURE_HEADER carries the HMAC-SHA256 of the request body when a secret is configured
+	SIGNATURE_HEADER string = "X-MailSlurper-Signature"
+
+	DEFAULT_TIMEOUT_SECONDS     int = 10
+	DEFAULT_RETRY_DELAY_SECONDS int = 1
+)
+
+/*
+WebhookReceiver is a mail item receiver which POSTs each new mail item,
+serialized as JSON, to a set of configured URLs. Failed deliveries are
+retried with an exponential backoff.
+*/
+type WebhookReceiver struct {
+	webhooks []*model.WebhookConfiguration
+}
+
+/*
+NewWebhookReceiver creates a new WebhookReceiver object
+*/
+func NewWebhookReceiver(webhooks []*model.WebhookConfiguration) WebhookReceiver {
+	return WebhookReceiver{
+		webhooks: webhooks,
+	}
+}
+
+/*
+Receive sends the mail item to every webhook whose filters match it.
+Deliveries happen in the background so a slow endpoint does not hold
+up the SMTP worker.
+*/
+func (receiver WebhookReceiver) Receive(mailItem *mailitem.MailItem) error {
+	var err error
+	var body []byte
+
+	if body, err = json.Marshal(mailItem); err != nil {
+		log.Printf("MailSlurper: ERROR - Unable to serialize mail item %s for webhooks: %s\n", mailItem.ID, err.Error())
+		return err
+	}
+
+	for _, webhook := range receiver.webhooks {
+		if !webhookMatches(webhook, mailItem) {
+			continue
+		}
+
+		go deliver(webhook, mailItem.ID, body)
+	}
+
+	return nil
+}
+
+func deliver(webhook *model.WebhookConfiguration, mailItemID string, body []byte) {
+	var err error
+
+	timeout := webhook.TimeoutSeconds
+	if timeout <= 0 {
+		timeout = DEFAULT_TIMEOUT_SECONDS
+	}
+
+	retryDelay := webhook.RetryDelaySeconds
+	if retryDelay <= 0 {
+		retryDelay = DEFAULT_RETRY_DELAY_SECONDS
+	}
+
+	client := &http.Client{
+		Timeout: time.Duration(timeout) * time.Second,
+	}
+
+	delay := time.Duration(retryDelay) * time.Second
+
+	for attempt := 0; attempt <= webhook.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay = delay * 2
+		}
+
+		if err = post(client, webhook, body); err == nil {
+			return
+		}
+
+		log.Printf("MailSlurper: ERROR - Webhook %s failed for mail item %s (attempt %d of %d): %s\n", webhook.URL, mailItemID, attempt+1, webhook.MaxRetries+1, err.Error())
+	}
+
+	log.Printf("MailSlurper: ERROR - Giving up on webhook %s for mail item %s\n", webhook.URL, mailItemID)
+}
+
+func post(client *http.Client, webhook *model.WebhookConfiguration, body []byte) error {
+	var err error
+	var request *http.Request
+	var response *http.Response
+
+	if request, err = http.NewRequest("POST", webhook.URL, bytes.NewReader(body)); err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "MailSlurper/"+global.SERVER_VERSION)
+
+	if webhook.Secret != "" {
+		request.Header.Set(SIGNATURE_HEADER, "sha256="+sign(webhook.Secret, body))
+	}
+
+	if response, err = client.Do(request); err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("Endpoint responded with %s", response.Status)
+	}
+
+	return nil
+}
+
+/*
+sign returns the hex encoded HMAC-SHA256 of body using secret as the key
+*/
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhookMatches(webhook *model.WebhookConfiguration, mailItem *mailitem.MailItem) bool {
+	if len(webhook.FromFilters) > 0 && !addressMatchesAny(mailItem.FromAddress, webhook.FromFilters) {
+		return false
+	}
+
+	if len(webhook.ToFilters) > 0 {
+		for _, toAddress := range mailItem.ToAddresses {
+			if addressMatchesAny(toAddress, webhook.ToFilters) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+func addressMatchesAny(address string, patterns []string) bool {
+	address = strings.ToLower(addressOnly(address))
+
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), address); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+addressOnly strips a display name and angle brackets, turning
+"Bob <bob@example.com>" into "bob@example.com"
+*/
+func addressOnly(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		return parsed.Address
+	}
+
+	return strings.Trim(strings.TrimSpace(address), "<>")
+}
@@ -8,23 +8,27 @@ require(
 		"services/SettingsService",
 		"services/MailService",
 		"services/SeedService",
+		"services/FaultRuleService",
 		"services/AlertService",
 		"services/ThemeService",
 		"bootstrap-dialog",
 
 		"hbs!templates/adminPrune",
-		"hbs!templates/adminSettings"
+		"hbs!templates/adminSettings",
+		"hbs!templates/adminFaultRules"
 	],
 	function(
 		$,
 		settingsService,
 		MailService,
 		SeedService,
+		FaultRuleService,
 		alertService,
 		ThemeService,
 		Dialog,
 		adminPruneTemplate,
-		adminSettings
+		adminSettings,
+		adminFaultRulesTemplate
 	) {
 		"use strict";
 
@@ -32,7 +36,8 @@ require(
 			var settings = {
 				dateFormat: $("#dateFormat option:selected").val(),
 				autoRefresh: window.parseInt($("#autoRefresh option:selected").val(), 10),
-				theme: $("#theme option:selected").val()
+				theme: $("#theme option:selected").val(),
+				desktopNotifications: $("#desktopNotifications").is(":checked")
 			};
 
 			return settings;
@@ -43,6 +48,50 @@ require(
 			$("#btnSaveSettings").on("click", function() { onBtnSaveSettings(); });
 		};
 
+		var initializeFaultRules = function() {
+			$("#btnRefreshFaultRules").on("click", function() { loadFaultRules(); });
+			$("#btnSaveFaultRules").on("click", function() { onBtnSaveFaultRules(); });
+		};
+
+		var loadFaultRules = function() {
+			$.when(
+				FaultRuleService.getFaultRules(serviceURL),
+				FaultRuleService.getFaultRuleMatches(serviceURL)
+			).then(
+				function(rulesResult, matchesResult) {
+					renderFaultRulesTemplate(rulesResult[0], matchesResult[0]);
+					initializeFaultRules();
+				},
+
+				function() {
+					alertService.error("There was an error getting the fault rules.");
+				}
+			);
+		};
+
+		var onBtnSaveFaultRules = function() {
+			var rules;
+
+			try {
+				rules = JSON.parse($("#faultRules").val() || "[]");
+			} catch (e) {
+				alertService.error("The fault rules are not valid JSON.");
+				return;
+			}
+
+			FaultRuleService.saveFaultRules(serviceURL, rules).then(
+				function() {
+					alertService.success("Fault rules saved!");
+					loadFaultRules();
+				},
+
+				function(xhr) {
+					var message = (xhr.responseJSON && xhr.responseJSON.message) ? xhr.responseJSON.message : "There was an error saving the fault rules.";
+					alertService.error(message);
+				}
+			);
+		};
+
 		var onBtnRemoveClick = function() {
 			Dialog.confirm({
 				message: "Are you sure you wish to prune old emails?",
@@ -87,9 +136,26 @@ require(
 				ThemeService.applySavedTheme();
 			}
 
+			if (settings.desktopNotifications && ("Notification" in window) && Notification.permission !== "granted") {
+				Notification.requestPermission();
+			}
+
 			alertService.success("Settings saved!");
 		};
 
+		var renderFaultRulesTemplate = function(rules, matches) {
+			for (var index = 0; index < matches.length; index++) {
+				matches[index].sizeText = (matches[index].size >= 0) ? matches[index].size : "";
+			}
+
+			var html = adminFaultRulesTemplate({
+				rulesJSON: JSON.stringify(rules, null, "\t"),
+				matches: matches
+			});
+
+			$("#adminFaultRules").html(html);
+		};
+
 		var renderPruneTemplate = function(pruneOptions, mailCount) {
 			var html = adminPruneTemplate({
 				totalEmailCount: mailCount,
@@ -104,7 +170,8 @@ require(
 				dateFormat: settings.dateFormat,
 				dateFormatOptions: dateFormatOptions,
 				autoRefresh: settings.autoRefresh,
-				theme: settings.theme
+				theme: settings.theme,
+				desktopNotifications: settings.desktopNotifications
 			});
 
 			$("#adminSettings").html(html);
@@ -137,6 +204,7 @@ require(
 						renderPruneTemplate(pruneOptions, response.mailCount);
 						renderSettingsTemplate(settings, dateFormatOptions);
 						initialize();
+						loadFaultRules();
 
 						alertService.unblock();
 					}
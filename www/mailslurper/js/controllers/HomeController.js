@@ -46,6 +46,14 @@ require(
 			html += "<strong>From:</strong> " + searchCriteria.searchFrom + "<br />";
 			html += "<strong>To:</strong> " + searchCriteria.searchTo + "<br />";
 
+			if (searchCriteria.searchHeader) {
+				html += "<strong>Header:</strong> " + searchCriteria.searchHeader + "<br />";
+			}
+
+			if (searchCriteria.searchTag) {
+				html += "<strong>Mailbox:</strong> " + searchCriteria.searchTag + "<br />";
+			}
+
 			return html;
 		};
 
@@ -59,6 +67,24 @@ require(
 				$("#mailSearchNav").outerHeight(true);
 		};
 
+		/**
+		 * Copies the snippet of each full-text search match onto its mail
+		 * item and returns the mail items
+		 */
+		var applySearchHighlights = function(mailCollection) {
+			var highlights = mailCollection.highlights || {};
+
+			for (var index = 0; index < mailCollection.mailItems.length; index++) {
+				var highlight = highlights[mailCollection.mailItems[index].id];
+
+				if (highlight) {
+					mailCollection.mailItems[index].snippet = highlight.snippet;
+				}
+			}
+
+			return mailCollection.mailItems;
+		};
+
 		/**
 		 * Changes sort field and/or direction
 		 */
@@ -120,6 +146,12 @@ require(
 				renderSearchMailModal();
 			});
 
+			$("#mailbox").on("change", function() {
+				searchCriteria.searchTag = $(this).val();
+				page = 1;
+				performSearch();
+			});
+
 			$("#firstPage").on("click", function() {
 				page = 1;
 				performSearch();
@@ -172,16 +204,22 @@ require(
 
 		/**
 		 * Performs a search for mail items, then re-renders the mail items
-		 * window.
+		 * window. When quiet is true the screen is not blocked while searching.
 		 */
-		var performSearch = function() {
-			alertService.block("Searching...");
+		var performSearch = function(quiet) {
+			if (!quiet) {
+				alertService.block("Searching...");
+			}
 
-			mailService.getMails(serviceURL, page, searchCriteria, sortCriteria).then(
-				function(response, status, xhr) {
-					mails = response.mailItems;
-					totalPages = response.totalPages;
-					totalMailCount = response.totalRecordCount;
+			$.when(
+				mailService.getMails(serviceURL, page, searchCriteria, sortCriteria),
+				mailService.getMailboxes(serviceURL)
+			).then(
+				function(mailsResult, mailboxesResult) {
+					mails = applySearchHighlights(mailsResult[0]);
+					totalPages = mailsResult[0].totalPages;
+					totalMailCount = mailsResult[0].totalRecordCount;
+					mailboxes = mailboxesResult[0];
 
 					renderMailItems();
 					initializeMailItems();
@@ -244,9 +282,21 @@ require(
 		/**
 		 * Renders the detail view for a specific mailitem.
 		 */
-		var renderMailDetails = function(mail) {
-			var html = mailDetailsTemplate({mail: mail.mailItem});
+		var renderMailDetails = function(mail, metadata, session, headers, extraction) {
+			var html = mailDetailsTemplate({
+				mail: mail.mailItem,
+				metadata: metadata,
+				session: session,
+				headers: headers || [],
+				extraction: extraction || { links: [], codes: [] },
+				rawMessageURL: mailService.getRawMessageURL(mail.mailItem.id)
+			});
+
 			$("#mailDetails").html(html);
+
+			$("#btnViewSource").on("click", function() {
+				viewMessageSource(mail.mailItem.id);
+			});
 		};
 
 		/**
@@ -288,7 +338,11 @@ require(
 				dateSortIcon: dateSortIcon,
 				subjectSortIcon: subjectSortIcon,
 				fromSortIcon: fromSortIcon,
-				direction: sortCriteria.orderByDirection
+				direction: sortCriteria.orderByDirection,
+				mailboxes: mailboxes,
+				selectedMailbox: searchCriteria.searchTag,
+				exportMboxURL: mailService.getExportURL(serviceURL, "mbox", searchCriteria, sortCriteria),
+				exportZipURL: mailService.getExportURL(serviceURL, "zip", searchCriteria, sortCriteria)
 			});
 
 			$("#mailList").html(html);
@@ -316,7 +370,8 @@ require(
 							var searchCriteria = {
 								searchMessage: $("#txtMessage").val(),
 								searchFrom: $("#txtFrom").val(),
-								searchTo: $("#txtTo").val()
+								searchTo: $("#txtTo").val(),
+								searchHeader: $("#txtHeader").val()
 							};
 
 							SavedSearchesWidget.showSaveSearchModal(function(saveSearchName) {
@@ -337,6 +392,7 @@ require(
 							$("#txtMessage").val("");
 							$("#txtFrom").val("");
 							$("#txtTo").val("");
+							$("#txtHeader").val("");
 						}
 					},
 					{
@@ -356,6 +412,15 @@ require(
 							searchCriteria.searchMessage = $("#txtMessage").val();
 							searchCriteria.searchFrom = $("#txtFrom").val();
 							searchCriteria.searchTo = $("#txtTo").val();
+							searchCriteria.searchHeader = $("#txtHeader").val();
+
+							/*
+							 * Full-text matches are listed best first
+							 */
+							if (searchCriteria.searchMessage) {
+								sortCriteria.orderByField = "relevance";
+								sortCriteria.orderByDirection = "desc";
+							}
 
 							dialogRef.close();
 							performSearch();
@@ -369,6 +434,7 @@ require(
 
 					$("#txtFrom").val(searchCriteria.searchFrom);
 					$("#txtTo").val(searchCriteria.searchTo);
+					$("#txtHeader").val(searchCriteria.searchHeader);
 					$("#txtMessage").val(searchCriteria.searchMessage).focus();
 				}
 			});
@@ -411,6 +477,56 @@ require(
 			}
 		};
 
+		/*
+		 * Connects to the mail stream so new mail shows up the moment
+		 * it is received.
+		 */
+		var setupMailStream = function() {
+			if (!("EventSource" in window)) {
+				alertService.logMessage("This browser does not support live mail updates", "info");
+				return;
+			}
+
+			var mailStream = new EventSource("/mailstream");
+
+			mailStream.addEventListener("mail", function(e) {
+				var newMail = JSON.parse(e.data);
+
+				showDesktopNotification(newMail);
+
+				if (page === 1) {
+					performSearch(true);
+				}
+			});
+		};
+
+		/**
+		 * Displays a desktop notification for a new mail item, if the user
+		 * has turned them on and the browser has granted permission.
+		 */
+		var showDesktopNotification = function(newMail) {
+			var settings = settingsService.retrieveSettings();
+
+			if (!settings.desktopNotifications || !("Notification" in window) || Notification.permission !== "granted") {
+				return;
+			}
+
+			var notification = new Notification("New mail from " + newMail.fromAddress, {
+				body: newMail.subject,
+				icon: "/www/mailslurper/images/favicon.ico",
+				tag: newMail.id
+			});
+
+			notification.onclick = function() {
+				window.focus();
+
+				mailID = newMail.id;
+				viewMailDetails();
+
+				notification.close();
+			};
+		};
+
 		/**
 		 * Displays the saved searches modal
 		 */
@@ -419,6 +535,7 @@ require(
 				$("#txtMessage").val(savedSearch.searchMessage);
 				$("#txtFrom").val(savedSearch.searchFrom);
 				$("#txtTo").val(savedSearch.searchTo);
+				$("#txtHeader").val(savedSearch.searchHeader || "");
 			});
 		};
 
@@ -430,6 +547,61 @@ require(
 			refreshTime = moment.duration(refreshTime.asSeconds() - 10, "seconds");
 		};
 
+		/**
+		 * Resolves with the result of a request, or with null when the request
+		 * fails, for details the mail view can do without.
+		 */
+		var optional = function(request) {
+			var deferred = $.Deferred();
+
+			request.then(
+				function(response) {
+					deferred.resolve(response);
+				},
+
+				function() {
+					deferred.resolve(null);
+				}
+			);
+
+			return deferred.promise();
+		};
+
+		/**
+		 * Shows the message source of a mail item, as it was received, in a
+		 * modal dialog box.
+		 */
+		var viewMessageSource = function(id) {
+			alertService.block("Getting message source...");
+
+			mailService.getRawMessage(id).then(
+				function(source) {
+					alertService.unblock();
+
+					Dialog.show({
+						title: "Message Source",
+						message: $("<pre class=\"message-source\"></pre>").text(source),
+						closable: true,
+						nl2br: false,
+						size: Dialog.SIZE_WIDE,
+						buttons: [
+							{
+								label: "Close",
+								cssClass: "btn-primary",
+								action: function(dialogRef) {
+									dialogRef.close();
+								}
+							}
+						]
+					});
+				},
+
+				function() {
+					alertService.error("There was a problem getting this mail's source");
+				}
+			);
+		};
+
 		/**
 		 * Loads the details for a selected mail item, then renders them.
 		 */
@@ -438,8 +610,12 @@ require(
 
 			mailService.getMailByID(serviceURL, mailID).then(
 				function(response) {
-					renderMailDetails(response);
-					alertService.unblock();
+					$.when(optional(mailService.getMailMetadata(mailID)), optional(mailService.getMailSession(mailID)), optional(mailService.getMailHeaders(mailID)), optional(mailService.getMailExtraction(mailID))).then(
+						function(metadata, session, headers, extraction) {
+							renderMailDetails(response, metadata, session, headers, extraction);
+							alertService.unblock();
+						}
+					);
 				},
 
 				function() {
@@ -452,6 +628,7 @@ require(
 		 * Constructor
 		 ***************************************************************************/
 		var mails = [];
+		var mailboxes = [];
 		var mailID = 0;
 		var previousPage = 0;
 		var nextPage = 0;
@@ -464,7 +641,9 @@ require(
 			searchStart: moment().startOf("month"),
 			searchEnd: moment().endOf("month"),
 			searchFrom: "",
-			searchTo: ""
+			searchTo: "",
+			searchHeader: "",
+			searchTag: ""
 		};
 		var sortCriteria = {
 			orderByField: "date",
@@ -476,17 +655,22 @@ require(
 		ThemeService.applySavedTheme();
 		alertService.block("Loading");
 
-		mailService.getMails(serviceURL, page, searchCriteria, sortCriteria).then(
-			function(response, status, xhr) {
-				mails = response.mailItems;
-				totalPages = response.totalPages;
-				totalMailCount = response.totalRecordCount;
+		$.when(
+			mailService.getMails(serviceURL, page, searchCriteria, sortCriteria),
+			mailService.getMailboxes(serviceURL)
+		).then(
+			function(mailsResult, mailboxesResult) {
+				mails = applySearchHighlights(mailsResult[0]);
+				totalPages = mailsResult[0].totalPages;
+				totalMailCount = mailsResult[0].totalRecordCount;
+				mailboxes = mailboxesResult[0];
 
 				renderMailItems();
 				initializeMailItems();
 				alertService.unblock();
 
 				setupAutoRefresh();
+				setupMailStream();
 			},
 
 			function() {
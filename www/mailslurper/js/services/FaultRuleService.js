@@ -0,0 +1,52 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+define(
+	[
+		"jquery"
+	],
+	function($) {
+		"use strict";
+
+		var service = {
+			/**
+			 * getFaultRules returns the fault rules currently applied to SMTP
+			 * sessions.
+			 */
+			getFaultRules: function(serviceURL) {
+				return $.ajax({
+					method: "GET",
+					url: serviceURL + "/faultrules",
+					cache: false
+				});
+			},
+
+			/**
+			 * getFaultRuleMatches returns the most recent fault rule matches,
+			 * newest first.
+			 */
+			getFaultRuleMatches: function(serviceURL) {
+				return $.ajax({
+					method: "GET",
+					url: serviceURL + "/faultrules/matches",
+					cache: false
+				});
+			},
+
+			/**
+			 * saveFaultRules replaces every fault rule with the given list.
+			 */
+			saveFaultRules: function(serviceURL, rules) {
+				return $.ajax({
+					method: "PUT",
+					url: serviceURL + "/faultrules",
+					contentType: "application/json",
+					data: JSON.stringify(rules)
+				});
+			}
+		};
+
+		return service;
+	}
+);
@@ -11,6 +11,57 @@ define(
 	function($, moment, SettingsService) {
 		"use strict";
 
+		/**
+		 * searchQueryString returns the search and sort criteria as query
+		 * string parameters for GET /mail and GET /mail/export, each
+		 * starting with "&".
+		 */
+		var searchQueryString = function(searchCriteria, sortCriteria) {
+			var url = "";
+
+			if (searchCriteria.searchMessage) {
+				url += "&text=" + encodeURIComponent(searchCriteria.searchMessage);
+			}
+
+			if (searchCriteria.searchStart) {
+				url += "&start=" + searchCriteria.searchStart.format("YYYY-MM-DD");
+			}
+
+			if (searchCriteria.searchEnd) {
+				url += "&end=" + searchCriteria.searchEnd.format("YYYY-MM-DD");
+			}
+
+			if (searchCriteria.searchFrom) {
+				url += "&from=" + encodeURIComponent(searchCriteria.searchFrom);
+			}
+
+			if (searchCriteria.searchTo) {
+				url += "&to=" + encodeURIComponent(searchCriteria.searchTo);
+			}
+
+			if (searchCriteria.searchTag) {
+				url += "&tag=" + encodeURIComponent(searchCriteria.searchTag);
+			}
+
+			if (searchCriteria.searchHeader && searchCriteria.searchHeader.indexOf("=") > 0) {
+				var separator = searchCriteria.searchHeader.indexOf("=");
+				var headerName = $.trim(searchCriteria.searchHeader.substring(0, separator));
+				var headerValue = $.trim(searchCriteria.searchHeader.substring(separator + 1));
+
+				url += "&" + encodeURIComponent("header." + headerName) + "=" + encodeURIComponent(headerValue);
+			}
+
+			if (sortCriteria.orderByField) {
+				url += "&orderby=" + sortCriteria.orderByField;
+			}
+
+			if (sortCriteria.orderByDirection) {
+				url += "&dir=" + sortCriteria.orderByDirection;
+			}
+
+			return url;
+		};
+
 		var service = {
 			/**
 			 * deleteMailItems deletes a set of mail items. The criteria is defined
@@ -54,52 +105,121 @@ define(
 			},
 
 			/**
-			 * getMailCount returns the number of mail items in storage. This will put
-			 * the count into a key named "mailCount" in the context object.
+			 * getMailboxes returns the tags of the SMTP listeners mail has
+			 * been received on, with a mail count for each.
 			 */
-			getMailCount: function(serviceURL) {
+			getMailboxes: function(serviceURL) {
 				return $.ajax({
 					method: "GET",
-					url: serviceURL + "/mailcount",
+					url: serviceURL + "/mailboxes",
 					cache: false
 				});
 			},
 
 			/**
-			 * getMails returns a page of stored email. The page number must be a key
-			 * named "page" in the context object. This will return mail items as an
-			 * array in a key named "mails" in the context object.
+			 * getMailExtraction returns the links in a mail item, with their
+			 * anchor text, and the codes matching the configured code patterns,
+			 * such as one-time passwords. This is served by the application
+			 * server rather than the service tier.
 			 */
-			getMails: function(serviceURL, page, searchCriteria, sortCriteria) {
-				var url = serviceURL + "/mail?pageNumber=" + page;
+			getMailExtraction: function(mailID) {
+				return $.ajax({
+					method: "GET",
+					url: "/mail/" + mailID + "/extract",
+					cache: false
+				});
+			},
 
-				if (searchCriteria.message != "") {
-					url += "&message=" + searchCriteria.searchMessage;
-				}
+			/**
+			 * getMailHeaders returns every header of a mail item as an array of
+			 * name/value objects, in the order they appeared. This is served by
+			 * the application server rather than the service tier.
+			 */
+			getMailHeaders: function(mailID) {
+				return $.ajax({
+					method: "GET",
+					url: "/mail/" + mailID + "/headers",
+					cache: false
+				});
+			},
 
-				if (searchCriteria.searchStart) {
-					url += "&start=" + searchCriteria.searchStart.format("YYYY-MM-DD");
-				}
+			/**
+			 * getMailMetadata returns the details recorded when a mail item was
+			 * received, such as TLS version and cipher. This is served by the
+			 * application server rather than the service tier.
+			 */
+			getMailMetadata: function(mailID) {
+				return $.ajax({
+					method: "GET",
+					url: "/mail/" + mailID + "/metadata",
+					cache: false
+				});
+			},
 
-				if (searchCriteria.searchEnd) {
-					url += "&end=" + searchCriteria.searchEnd.format("YYYY-MM-DD");
-				}
+			/**
+			 * getMailSession returns the SMTP session a mail item arrived in,
+			 * including the command/response transcript. Mail that did not
+			 * arrive over SMTP has no session, and the request fails with a 404.
+			 */
+			getMailSession: function(mailID) {
+				return $.ajax({
+					method: "GET",
+					url: "/mail/" + mailID + "/session",
+					cache: false
+				});
+			},
+
+			/**
+			 * getRawMessage returns a mail item's message source as text,
+			 * exactly as it was received. This is served by the application
+			 * server rather than the service tier.
+			 */
+			getRawMessage: function(mailID) {
+				return $.ajax({
+					method: "GET",
+					url: "/mail/" + mailID + "/raw",
+					dataType: "text",
+					cache: false
+				});
+			},
 
-				if (searchCriteria.searchFrom) {
-					url += "&from=" + searchCriteria.searchFrom;
-				}
+			/**
+			 * getRawMessageURL returns the address that downloads a mail item
+			 * as an .eml file.
+			 */
+			getRawMessageURL: function(mailID) {
+				return "/mail/" + mailID + "/raw?download=true";
+			},
 
-				if (searchCriteria.searchTo) {
-					url += "&to=" + searchCriteria.searchTo;
-				}
+			/**
+			 * getExportURL returns the address that downloads the mail matching
+			 * the search criteria as an archive. format is "mbox" or "zip".
+			 */
+			getExportURL: function(serviceURL, format, searchCriteria, sortCriteria) {
+				return serviceURL + "/mail/export?format=" + format + searchQueryString(searchCriteria, sortCriteria);
+			},
 
-				if (sortCriteria.orderByField) {
-					url += "&orderby=" + sortCriteria.orderByField;
-				}
+			/**
+			 * getMailCount returns the number of mail items in storage. This will put
+			 * the count into a key named "mailCount" in the context object.
+			 */
+			getMailCount: function(serviceURL) {
+				return $.ajax({
+					method: "GET",
+					url: serviceURL + "/mailcount",
+					cache: false
+				});
+			},
 
-				if (sortCriteria.orderByDirection) {
-					url += "&dir=" + sortCriteria.orderByDirection;
-				}
+			/**
+			 * getMails returns a page of stored email. The page number must be a key
+			 * named "page" in the context object. This will return mail items as an
+			 * array in a key named "mails" in the context object. A message search
+			 * is a full-text search, and its results come with a "highlights"
+			 * object holding a snippet for each mail item.
+			 */
+			getMails: function(serviceURL, page, searchCriteria, sortCriteria) {
+				var url = serviceURL + "/mail?pageNumber=" + page + searchQueryString(searchCriteria, sortCriteria);
 
 				return $.ajax({
 					method: "GET",
@@ -62,10 +62,16 @@ define(
 
 			/**
 			 * getServiceURL returns a fully formatted service URL as a key named
-			 * "serviceURL" in the context object.
+			 * "serviceURL" in the context object. In single port mode this is
+			 * a path on the server that served the page.
 			 */
 			getServiceURL: function(context) {
 				var serviceSettings = service.retrieveServiceSettings();
+
+				if (serviceSettings.servicePathPrefix) {
+					return serviceSettings.servicePathPrefix;
+				}
+
 				return "//" + serviceSettings.serviceAddress + ":" + serviceSettings.servicePort;
 			},
 
@@ -74,11 +80,7 @@ define(
 			 * "serviceURL" directly instead of via a promise.
 			 */
 			getServiceURLNow: function() {
-				var serviceSettings = service.retrieveServiceSettings();
-
-				var serviceURL = "//" + serviceSettings.serviceAddress + ":" + serviceSettings.servicePort;
-
-				return serviceURL;
+				return service.getServiceURL();
 			},
 
 			/**
@@ -110,7 +112,8 @@ define(
 					return {
 						dateFormat: "YYYY-MM-DD hh:mm A",
 						autoRefresh: 0,
-						theme: "default"
+						theme: "default",
+						desktopNotifications: false
 					};
 				}
 			},
@@ -0,0 +1,18 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+define(
+	[
+		"hbs/handlebars"
+	],
+	function(Handlebars) {
+		"use strict";
+
+		var helper = function(milliseconds) {
+			return Number(milliseconds).toFixed(1) + " ms";
+		};
+
+		Handlebars.registerHelper("formatDuration", helper);
+		return helper;
+	}
+);
@@ -0,0 +1,33 @@
+// Copyright 2013-2016 Adam Presley. All rights reserved
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+define(
+	[
+		"hbs/handlebars"
+	],
+	function(Handlebars) {
+		"use strict";
+
+		var helper = function(elementName, mailboxes, selectedTag) {
+			var html = "<select id=\"" + elementName + "\" class=\"form-control\">";
+			html += "<option value=\"\">All Mail</option>";
+
+			for (var index = 0; index < mailboxes.length; index++) {
+				var tag = Handlebars.escapeExpression(mailboxes[index].tag);
+
+				html += "<option value=\"" + tag + "\"";
+				html += (selectedTag === mailboxes[index].tag) ? " selected=\"selected\"" : "";
+				html += ">";
+				html += tag + " (" + mailboxes[index].mailCount + ")";
+				html += "</option>";
+			}
+
+			html += "</select>";
+
+			return html;
+		};
+
+		Handlebars.registerHelper("mailboxSelector", helper);
+		return helper;
+	}
+);